@@ -0,0 +1,139 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/agent"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+var (
+	step       int64
+	stepFails  int32
+	stepPolled int64
+)
+
+// stepCollector reports the step gauge set by the tests and counts its polls. Its StepCount
+// counter is dropped by the agent, collectors return gauges only.
+type stepCollector struct{}
+
+func (stepCollector) Collect() ([]datastorage.Metrics, error) {
+	atomic.AddInt64(&stepPolled, 1)
+	if atomic.LoadInt32(&stepFails) == 1 {
+		return nil, errors.New("step is broken")
+	}
+	return []datastorage.Metrics{
+		{ID: "Step", MType: "gauge", Value: float64(atomic.LoadInt64(&step))},
+		{ID: "StepCount", MType: "counter", Delta: atomic.LoadInt64(&stepPolled)},
+	}, nil
+}
+
+func init() {
+	agent.Register("step", func() agent.Collector { return stepCollector{} })
+}
+
+func setStep(value int64) {
+	atomic.StoreInt64(&step, value)
+}
+
+func TestCollectorsRegistered(t *testing.T) {
+	assert.Equal(t, []string{"cpu", "memory", "random", "runtime", "step"}, agent.Collectors())
+	assert.Panics(t, func() {
+		agent.Register("step", func() agent.Collector { return stepCollector{} })
+	})
+}
+
+func TestParseCollectors(t *testing.T) {
+	configs, err := agent.ParseCollectors(" memory:5s, -random,cpu ,")
+	require.NoError(t, err)
+	assert.Equal(t, []agent.CollectorConfig{
+		{Name: "memory", Interval: 5 * time.Second},
+		{Name: "random", Disabled: true},
+		{Name: "cpu"},
+	}, configs)
+
+	for _, value := range []string{"memory:soon", "memory:-1s", "memory:0s"} {
+		_, err := agent.ParseCollectors(value)
+		assert.Error(t, err, value)
+	}
+	assert.Panics(t, func() { agent.New(agent.Config{Collectors: "gpu"}) })
+}
+
+func TestCollectorsConfig(t *testing.T) {
+	server := &flakyServer{status: http.StatusOK}
+	collector := agent.New(agent.Config{
+		Server:         startFlakyServer(t, server),
+		ReportInterval: time.Second,
+		Collectors:     "-random,-cpu",
+	})
+	setStep(7)
+
+	collector.Collect(time.Now())
+	collector.Report(time.Now())
+
+	batches := server.received()
+	require.Len(t, batches, 1)
+	for _, id := range []string{"Alloc", "FreeMemory", "Step", "PollCount"} {
+		_, ok := findMetric(batches[0], id)
+		assert.True(t, ok, id)
+	}
+	for _, id := range []string{"RandomValue", "cpu_utilization", "StepCount"} {
+		_, ok := findMetric(batches[0], id)
+		assert.False(t, ok, id)
+	}
+	metrics, _ := findMetric(batches[0], "Step")
+	assert.Equal(t, float64(7), metrics.Value)
+}
+
+func TestCollectorKeepsMetricsOnError(t *testing.T) {
+	server := &flakyServer{status: http.StatusOK}
+	collector := agent.New(agent.Config{Server: startFlakyServer(t, server), ReportInterval: time.Second})
+
+	setStep(1)
+	collector.Collect(time.Now())
+	atomic.StoreInt32(&stepFails, 1)
+	defer atomic.StoreInt32(&stepFails, 0)
+	setStep(2)
+	collector.Collect(time.Now())
+	collector.Report(time.Now())
+
+	batches := server.received()
+	require.Len(t, batches, 1)
+	metrics, ok := findMetric(batches[0], "Step")
+	require.True(t, ok)
+	assert.Equal(t, float64(1), metrics.Value)
+}
+
+func TestCollectorInterval(t *testing.T) {
+	server := &flakyServer{status: http.StatusOK}
+	collector := agent.New(agent.Config{
+		Server:         startFlakyServer(t, server),
+		PollInterval:   time.Hour,
+		ReportInterval: time.Hour,
+		Collectors:     "step:20ms",
+	})
+
+	atomic.StoreInt64(&stepPolled, 0)
+	collector.Collect(time.Now())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&stepPolled), "a collector with its own interval is not polled with the others")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		collector.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(150 * time.Millisecond)
+	cancel()
+	<-done
+
+	assert.GreaterOrEqual(t, atomic.LoadInt64(&stepPolled), int64(3))
+}
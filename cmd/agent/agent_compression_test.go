@@ -0,0 +1,124 @@
+package main_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/agent"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+// encodingServer runs the server router, with or without zstd, and records the request encodings.
+type encodingServer struct {
+	storage   *datastorage.FileStorage
+	gzipOnly  chi.Router
+	withZstd  chi.Router
+	zstd      int32
+	mu        sync.Mutex
+	encodings []string
+}
+
+func (s *encodingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.encodings = append(s.encodings, r.Header.Get("Content-Encoding"))
+	s.mu.Unlock()
+	if atomic.LoadInt32(&s.zstd) == 1 {
+		s.withZstd.ServeHTTP(w, r)
+	} else {
+		s.gzipOnly.ServeHTTP(w, r)
+	}
+}
+
+func (s *encodingServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.encodings...)
+}
+
+func newEncodingServer(t *testing.T, zstd bool) (*encodingServer, string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	storage := datastorage.NewFileStorage(datastorage.StorageConfig{})
+	go storage.RunReciver(ctx)
+	s := &encodingServer{
+		storage:  storage,
+		gzipOnly: server.MakeRouterWithOptions(storage, server.RouterOptions{}),
+		withZstd: server.MakeRouterWithOptions(storage, server.RouterOptions{Zstd: true}),
+	}
+	if zstd {
+		s.zstd = 1
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return s, strings.TrimPrefix(ts.URL, "http://")
+}
+
+func newCompressingAgent(addr string, compression string, minSize int) *agent.CollectorAgent {
+	return agent.New(agent.Config{
+		Server:          addr,
+		ReportInterval:  time.Second,
+		Compression:     compression,
+		CompressMinSize: minSize,
+	})
+}
+
+func TestAgentGzip(t *testing.T) {
+	s, addr := newEncodingServer(t, false)
+
+	for _, collector := range []*agent.CollectorAgent{
+		newCompressingAgent(addr, "gzip", 0),
+		newCompressingAgent(addr, "gzip", 1<<20),
+		newCompressingAgent(addr, "none", 0),
+	} {
+		collector.Collect(time.Now())
+		collector.Report(time.Now())
+	}
+
+	assert.Equal(t, []string{"gzip", "", ""}, s.received())
+	_, err := s.storage.GetGaugeValue("Alloc")
+	assert.NoError(t, err)
+}
+
+func TestAgentZstdNegotiation(t *testing.T) {
+	s, addr := newEncodingServer(t, true)
+	collector := newCompressingAgent(addr, "zstd", 0)
+
+	collector.PollCount = 1
+	collector.Report(time.Now())
+	collector.PollCount = 2
+	collector.Report(time.Now())
+
+	atomic.StoreInt32(&s.zstd, 0)
+	collector.PollCount = 3
+	collector.Report(time.Now())
+	collector.PollCount = 4
+	collector.Report(time.Now())
+
+	assert.Equal(t, []string{"gzip", "zstd", "zstd", "gzip", "gzip"}, s.received(),
+		"zstd after the server lists it, gzip again after 415")
+	value, err := s.storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), value)
+}
+
+func TestAgentZstdWithoutServerSupport(t *testing.T) {
+	s, addr := newEncodingServer(t, false)
+	collector := newCompressingAgent(addr, "zstd", 0)
+
+	collector.Report(time.Now())
+	collector.Report(time.Now())
+
+	assert.Equal(t, []string{"gzip", "gzip"}, s.received())
+}
@@ -0,0 +1,73 @@
+package main_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/agent"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+func pollCountDeltas(t *testing.T, batches [][]datastorage.Metrics) []int64 {
+	deltas := []int64{}
+	for _, batch := range batches {
+		metrics, ok := findMetric(batch, "PollCount")
+		require.True(t, ok)
+		deltas = append(deltas, metrics.Delta)
+	}
+	return deltas
+}
+
+func TestPollCountDeltas(t *testing.T) {
+	server := &flakyServer{status: http.StatusOK}
+	collector := agent.New(agent.Config{Server: startFlakyServer(t, server), ReportInterval: time.Second})
+
+	for i := 0; i < 3; i++ {
+		collector.Collect(time.Now())
+	}
+	collector.Report(time.Now())
+	collector.Collect(time.Now())
+	collector.Report(time.Now())
+	collector.Report(time.Now())
+
+	assert.Equal(t, []int64{3, 1, 0}, pollCountDeltas(t, server.received()))
+}
+
+func TestPollCountKeptOnFailure(t *testing.T) {
+	server := &flakyServer{status: http.StatusServiceUnavailable}
+	collector := agent.New(agent.Config{Server: startFlakyServer(t, server), ReportInterval: time.Second})
+
+	collector.PollCount = 2
+	collector.Report(time.Now())
+	server.setStatus(http.StatusBadRequest)
+	collector.PollCount = 3
+	collector.Report(time.Now())
+
+	server.setStatus(http.StatusOK)
+	collector.PollCount = 5
+	collector.Report(time.Now())
+	collector.Report(time.Now())
+
+	assert.Equal(t, []int64{5, 0}, pollCountDeltas(t, server.received()))
+}
+
+func TestMemoryMetricsAreGauges(t *testing.T) {
+	server := &flakyServer{status: http.StatusOK}
+	collector := agent.New(agent.Config{Server: startFlakyServer(t, server), ReportInterval: time.Second})
+
+	collector.Collect(time.Now())
+	collector.Report(time.Now())
+
+	batches := server.received()
+	require.Len(t, batches, 1)
+	for _, id := range []string{"FreeMemory", "TotalMemory"} {
+		metrics, ok := findMetric(batches[0], id)
+		require.True(t, ok, id)
+		assert.Equal(t, "gauge", metrics.MType, id)
+		assert.Greater(t, metrics.Value, float64(0), id)
+	}
+}
@@ -0,0 +1,197 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/agent"
+)
+
+type scriptedResponse struct {
+	status     int
+	retryAfter string
+}
+
+// scriptedServer answers with the responses in turn and then with 200.
+type scriptedServer struct {
+	mu           sync.Mutex
+	responses    []scriptedResponse
+	times        []time.Time
+	contentTypes []string
+}
+
+func (server *scriptedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.times = append(server.times, time.Now())
+	server.contentTypes = append(server.contentTypes, r.Header.Get("Content-Type"))
+	if len(server.responses) == 0 {
+		return
+	}
+	response := server.responses[0]
+	server.responses = server.responses[1:]
+	if response.retryAfter != "" {
+		w.Header().Set("Retry-After", response.retryAfter)
+	}
+	w.WriteHeader(response.status)
+}
+
+func (server *scriptedServer) script(responses ...scriptedResponse) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.responses = responses
+}
+
+func (server *scriptedServer) requests() []time.Time {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return append([]time.Time{}, server.times...)
+}
+
+func newRetryAgent(t *testing.T, cfg agent.Config, responses ...scriptedResponse) (*agent.CollectorAgent, *scriptedServer) {
+	server := &scriptedServer{responses: responses}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	cfg.Server = strings.TrimPrefix(ts.URL, "http://")
+	cfg.Transport = agent.TransportHTTP
+	if cfg.ReportInterval == 0 {
+		cfg.ReportInterval = 10 * time.Second
+	}
+	return agent.New(cfg), server
+}
+
+func unavailable(count int) []scriptedResponse {
+	responses := make([]scriptedResponse, count)
+	for i := range responses {
+		responses[i] = scriptedResponse{status: http.StatusServiceUnavailable}
+	}
+	return responses
+}
+
+func TestRetryBackoffSchedule(t *testing.T) {
+	backoff := []time.Duration{20 * time.Millisecond, 60 * time.Millisecond}
+	collector, server := newRetryAgent(t, agent.Config{ReportRetries: 3, RetryBackoff: backoff}, unavailable(3)...)
+
+	collector.Report(time.Now())
+
+	times := server.requests()
+	require.Len(t, times, 4)
+	expected := []time.Duration{20 * time.Millisecond, 60 * time.Millisecond, 60 * time.Millisecond}
+	for i, delay := range expected {
+		assert.GreaterOrEqual(t, times[i+1].Sub(times[i]), delay)
+	}
+	for _, contentType := range server.contentTypes {
+		assert.Equal(t, "application/json", contentType)
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	backoff := []time.Duration{40 * time.Millisecond}
+	collector, server := newRetryAgent(t, agent.Config{ReportRetries: 5, RetryBackoff: backoff, RetryJitter: 0.5}, unavailable(5)...)
+
+	collector.Report(time.Now())
+
+	times := server.requests()
+	require.Len(t, times, 6)
+	for i := 1; i < len(times); i++ {
+		assert.GreaterOrEqual(t, times[i].Sub(times[i-1]), 20*time.Millisecond)
+	}
+}
+
+func TestRetryOnlyRetryableStatus(t *testing.T) {
+	backoff := []time.Duration{time.Millisecond}
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusNotImplemented} {
+		collector, server := newRetryAgent(t, agent.Config{ReportRetries: 2, RetryBackoff: backoff}, scriptedResponse{status: status})
+		collector.Report(time.Now())
+
+		expected := 1
+		if status >= http.StatusInternalServerError {
+			expected = 2
+		}
+		assert.Len(t, server.requests(), expected, status)
+	}
+
+	collector, server := newRetryAgent(t, agent.Config{ReportRetries: 2, RetryBackoff: backoff},
+		scriptedResponse{status: http.StatusTooManyRequests}, scriptedResponse{status: http.StatusBadGateway})
+	collector.Report(time.Now())
+	assert.Len(t, server.requests(), 3)
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	ts.Close()
+
+	collector := agent.New(agent.Config{
+		Server:         addr,
+		ReportInterval: time.Second,
+		ReportRetries:  2,
+		RetryBackoff:   []time.Duration{30 * time.Millisecond},
+	})
+	start := time.Now()
+	collector.Report(time.Now())
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+}
+
+func TestRetryAfter(t *testing.T) {
+	backoff := []time.Duration{time.Millisecond}
+	collector, server := newRetryAgent(t, agent.Config{ReportRetries: 1, RetryBackoff: backoff},
+		scriptedResponse{status: http.StatusTooManyRequests, retryAfter: "1"})
+
+	collector.Report(time.Now())
+
+	times := server.requests()
+	require.Len(t, times, 2)
+	assert.GreaterOrEqual(t, times[1].Sub(times[0]), time.Second)
+}
+
+func TestRetryAfterLongerThanInterval(t *testing.T) {
+	backoff := []time.Duration{time.Millisecond}
+	collector, server := newRetryAgent(t, agent.Config{ReportRetries: 2, RetryBackoff: backoff, ReportInterval: time.Second},
+		scriptedResponse{status: http.StatusServiceUnavailable, retryAfter: "120"})
+
+	collector.Report(time.Now())
+
+	assert.Len(t, server.requests(), 1)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cooldown := 100 * time.Millisecond
+	collector, server := newRetryAgent(t, agent.Config{BreakerThreshold: 2, BreakerCooldown: cooldown}, unavailable(3)...)
+
+	collector.Report(time.Now())
+	collector.Report(time.Now())
+	assert.Len(t, server.requests(), 2)
+
+	collector.Report(time.Now())
+	assert.Len(t, server.requests(), 2, "the breaker is open")
+
+	time.Sleep(cooldown)
+	collector.Report(time.Now())
+	assert.Len(t, server.requests(), 3, "one report goes through after the cooldown")
+	collector.Report(time.Now())
+	assert.Len(t, server.requests(), 3, "the failed probe opens the breaker again")
+
+	time.Sleep(cooldown)
+	collector.Report(time.Now())
+	collector.Report(time.Now())
+	assert.Len(t, server.requests(), 5, "the successful probe closes the breaker")
+}
+
+func TestCircuitBreakerIgnoresRejected(t *testing.T) {
+	collector, server := newRetryAgent(t, agent.Config{BreakerThreshold: 1, BreakerCooldown: time.Minute})
+	server.script(scriptedResponse{status: http.StatusBadRequest}, scriptedResponse{status: http.StatusBadRequest})
+
+	collector.Report(time.Now())
+	collector.Report(time.Now())
+	collector.Report(time.Now())
+	assert.Len(t, server.requests(), 3)
+}
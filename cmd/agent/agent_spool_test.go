@@ -0,0 +1,179 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/agent"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+type flakyServer struct {
+	addr    string
+	mu      sync.Mutex
+	status  int
+	batches [][]datastorage.Metrics
+}
+
+func (server *flakyServer) setStatus(status int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.status = status
+}
+
+func (server *flakyServer) received() [][]datastorage.Metrics {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.batches
+}
+
+func (server *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.status != http.StatusOK {
+		w.WriteHeader(server.status)
+		return
+	}
+	batch := []datastorage.Metrics{}
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	server.batches = append(server.batches, batch)
+}
+
+// startFlakyServer serves the server until the test ends and returns its address.
+func startFlakyServer(t *testing.T, server *flakyServer) string {
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	server.addr = strings.TrimPrefix(ts.URL, "http://")
+	return server.addr
+}
+
+func newSpoolAgent(t *testing.T, spoolMaxSize int64) (*agent.CollectorAgent, *flakyServer, string) {
+	server := &flakyServer{status: http.StatusServiceUnavailable}
+	startFlakyServer(t, server)
+
+	spoolFile := filepath.Join(t.TempDir(), "spool.jsonl")
+	collector := agent.New(agent.Config{
+		Server:         server.addr,
+		ReportInterval: time.Second,
+		Transport:      agent.TransportHTTP,
+		SpoolFile:      spoolFile,
+		SpoolMaxSize:   spoolMaxSize,
+	})
+	return collector, server, spoolFile
+}
+
+func spooledBatches(t *testing.T, spoolFile string) int {
+	data, err := ioutil.ReadFile(spoolFile)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	require.NoError(t, err)
+	return strings.Count(string(data), "\n")
+}
+
+func findMetric(batch []datastorage.Metrics, id string) (datastorage.Metrics, bool) {
+	for _, metrics := range batch {
+		if metrics.ID == id {
+			return metrics, true
+		}
+	}
+	return datastorage.Metrics{}, false
+}
+
+func TestSpoolReplaysInOrder(t *testing.T) {
+	collector, server, spoolFile := newSpoolAgent(t, 0)
+
+	for i := 0; i < 3; i++ {
+		setStep(int64(i))
+		collector.Collect(time.Now())
+		collector.Report(time.Now())
+	}
+	assert.Equal(t, 3, spooledBatches(t, spoolFile))
+	assert.Empty(t, server.received())
+
+	server.setStatus(http.StatusOK)
+	setStep(3)
+	collector.Collect(time.Now())
+	collector.Report(time.Now())
+
+	batches := server.received()
+	require.Len(t, batches, 4)
+	for i, batch := range batches {
+		metrics, ok := findMetric(batch, "Step")
+		require.True(t, ok)
+		assert.Equal(t, float64(i), metrics.Value)
+	}
+	assert.Equal(t, 0, spooledBatches(t, spoolFile))
+}
+
+func TestSpoolMergesOverLimit(t *testing.T) {
+	collector, server, spoolFile := newSpoolAgent(t, 1)
+
+	for i := 0; i < 3; i++ {
+		setStep(int64(i))
+		collector.Collect(time.Now())
+		collector.Report(time.Now())
+	}
+	assert.Equal(t, 1, spooledBatches(t, spoolFile))
+
+	server.setStatus(http.StatusOK)
+	collector.PollCount++
+	collector.Report(time.Now())
+
+	batches := server.received()
+	require.Len(t, batches, 2)
+	pollCount, ok := findMetric(batches[0], "PollCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), pollCount.Delta)
+	stepValue, ok := findMetric(batches[0], "Step")
+	require.True(t, ok)
+	assert.Equal(t, float64(2), stepValue.Value)
+
+	pollCount, ok = findMetric(batches[1], "PollCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), pollCount.Delta)
+}
+
+func TestSpoolSkipsRejectedBatch(t *testing.T) {
+	collector, server, spoolFile := newSpoolAgent(t, 0)
+
+	server.setStatus(http.StatusBadRequest)
+	collector.Report(time.Now())
+	assert.Equal(t, 0, spooledBatches(t, spoolFile))
+
+	server.setStatus(http.StatusServiceUnavailable)
+	collector.Report(time.Now())
+	assert.Equal(t, 1, spooledBatches(t, spoolFile))
+}
+
+func TestSpoolSurvivesRestart(t *testing.T) {
+	collector, server, spoolFile := newSpoolAgent(t, 0)
+	collector.Report(time.Now())
+	assert.Equal(t, 1, spooledBatches(t, spoolFile))
+
+	server.setStatus(http.StatusOK)
+	restarted := agent.New(agent.Config{
+		Server:         server.addr,
+		ReportInterval: time.Second,
+		Transport:      agent.TransportHTTP,
+		SpoolFile:      spoolFile,
+	})
+	restarted.Report(time.Now())
+
+	assert.Len(t, server.received(), 2)
+	assert.Equal(t, 0, spooledBatches(t, spoolFile))
+}
@@ -26,13 +26,17 @@ func main() {
 	pollInterval := pflag.DurationP("pool-inreval", "p", config.DefaultPollInterval, "")
 	reportInterval := pflag.DurationP("report-interval", "r", config.DefaultReportInterval, "")
 	key := pflag.StringP("key", "k", "", "")
+	transport := pflag.StringP("transport", "t", config.DefaultTransport, "")
+	grpcAddress := pflag.StringP("grpc-address", "g", config.DefaultGRPCServer, "")
+	cryptoKey := pflag.String("crypto-key", config.DefaultCryptoKey, "")
+	spoolFile := pflag.String("spool-file", config.DefaultSpoolFile, "")
 	pflag.Parse()
 
 	v := viper.New()
 	v.AllowEmptyEnv(true)
 	v.AutomaticEnv()
 
-	conf := config.NewAgentConfigWithDefaults(v, *address, *pollInterval, *reportInterval, *key)
+	conf := config.NewAgentConfigWithDefaults(v, *address, *pollInterval, *reportInterval, *key, *transport, *grpcAddress, *cryptoKey, *spoolFile)
 	collector := agent.New(*conf)
 	collector.Run(ctx)
 
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/nikolaevs92/Practicum/internal/encryption"
+)
+
+func main() {
+	bits := pflag.IntP("bits", "b", 4096, "")
+	privateKeyFile := pflag.String("private", "private.pem", "")
+	publicKeyFile := pflag.String("public", "public.pem", "")
+	pflag.Parse()
+
+	privatePEM, publicPEM, err := encryption.GenerateKeys(*bits)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := os.WriteFile(*privateKeyFile, privatePEM, 0600); err != nil {
+		log.Fatalln(err)
+	}
+	if err := os.WriteFile(*publicKeyFile, publicPEM, 0644); err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Keys are written to " + *privateKeyFile + " and " + *publicKeyFile)
+}
@@ -22,16 +22,17 @@ func main() {
 	key := pflag.StringP("key", "k", "", "")
 	dataBaseDSN := pflag.StringP("db-dsn", "d", "", "")
 	dataBaseType := pflag.StringP("db-type", "t", config.DefaultDataBaseType, "")
+	grpcAdress := pflag.StringP("grpc-adress", "g", config.DefaultGRPCServer, "")
+	cryptoKey := pflag.String("crypto-key", config.DefaultCryptoKey, "")
+	trustedSubnet := pflag.String("trusted-subnet", config.DefaultTrustedSubnet, "")
 	pflag.Parse()
 
 	v := viper.New()
 	v.AllowEmptyEnv(true)
 	v.AutomaticEnv()
 
-	cfg := config.NewServerConfigWithDefaults(v, *adress, *storeInterval, *storeFile, *restore, *key, *dataBaseDSN, *dataBaseType)
+	cfg := config.NewServerConfigWithDefaults(v, *adress, *storeInterval, *storeFile, *restore, *key, *dataBaseDSN, *dataBaseType, *grpcAdress, *cryptoKey, *trustedSubnet)
 	log.Println("DSN: " + cfg.DataBaseDSN)
-	log.Println("server: " + cfg.Server)
-	dataServer := server.New(*cfg)
 	cancelChan := make(chan os.Signal, 1)
 
 	signal.Notify(cancelChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
@@ -41,6 +42,14 @@ func main() {
 		<-cancelChan
 		cancel()
 	}()
+
+	if pflag.Arg(0) == "migrate" {
+		migrate(ctx, cfg.StorageConfig, pflag.Arg(1))
+		return
+	}
+
+	log.Println("server: " + cfg.Server)
+	dataServer := server.New(*cfg)
 	dataServer.Run(ctx)
 
 	log.Println("Program end")
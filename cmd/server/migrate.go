@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+func runMigrate(ctx context.Context, cfg datastorage.StorageConfig, command string) error {
+	if cfg.DataBaseDSN == "" {
+		return errors.New("migrate: database dsn is empty, set DATABASE_DSN or -d")
+	}
+	if cfg.DBType == datastorage.BoltDBType {
+		return errors.New("migrate: the bolt storage creates its buckets on start and has no migrations")
+	}
+
+	db, err := sql.Open(cfg.DBType, cfg.DataBaseDSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	migrator := datastorage.NewMigrator(db, cfg.DBType)
+
+	switch command {
+	case "", "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "status":
+	default:
+		return errors.New("migrate: unknown command " + command + ", valid commands: up, down, status")
+	}
+	if err != nil {
+		return err
+	}
+
+	states, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		fmt.Println(state)
+	}
+	return nil
+}
+
+func migrate(ctx context.Context, cfg datastorage.StorageConfig, command string) {
+	if err := runMigrate(ctx, cfg, command); err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestBatchUpdates(t *testing.T) {
+	tests := []struct {
+		testName   string
+		batchMode  string
+		body       string
+		statusCode int
+		response   string
+	}{
+		{
+			testName:   "empty",
+			batchMode:  datastorage.BatchAtomic,
+			body:       `[]`,
+			statusCode: 200,
+			response:   `[]`,
+		},
+		{
+			testName:   "oversized",
+			batchMode:  datastorage.BatchAtomic,
+			body:       `[{"id":"A","type":"gauge","value":1},{"id":"B","type":"gauge","value":1},{"id":"C","type":"gauge","value":1}]`,
+			statusCode: 413,
+			response:   `{"code":413,"message":"DataStorage: batch is too large: 3 metrics, the limit is 2"}`,
+		},
+		{
+			testName:   "atomic",
+			batchMode:  datastorage.BatchAtomic,
+			body:       `[{"id":"A","type":"gauge","value":1},{"id":"B","type":"summary"}]`,
+			statusCode: 422,
+			response: `{"code":422,"message":"DataStorage: wrong metric type: summary","metric":"B","results":[` +
+				`{"id":"A","type":"gauge","status":"skipped"},` +
+				`{"id":"B","type":"summary","status":"failed","error":"DataStorage: wrong metric type: summary"}]}`,
+		},
+		{
+			testName:   "best_effort",
+			batchMode:  datastorage.BatchBestEffort,
+			body:       `[{"id":"A","type":"gauge","value":1},{"id":"B","type":"summary"}]`,
+			statusCode: 200,
+			response: `[{"id":"A","type":"gauge","status":"ok","stored":{"id":"A","type":"gauge","value":1}},` +
+				`{"id":"B","type":"summary","status":"failed","error":"DataStorage: wrong metric type: summary"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			cfg := config.LoadConfig()
+			cfg.Server.StoreFile = "./.data"
+			cfg.Server.Restore = false
+			cfg.Server.BatchMode = tt.batchMode
+			cfg.Server.MaxBatchSize = 2
+			storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+			go storage.RunReciver(ctx)
+
+			ts := httptest.NewServer(server.MakeRouter(storage))
+			defer ts.Close()
+
+			resp, err := http.Post(ts.URL+"/updates/", "application/json", bytes.NewReader([]byte(tt.body)))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			assert.JSONEq(t, tt.response, string(body))
+		})
+	}
+}
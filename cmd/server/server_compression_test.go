@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/compression"
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestCompressedUpdates(t *testing.T) {
+	plaintext := []byte(`[{"id":"PollCount","type":"counter","delta":2}]`)
+	gzipped, err := compression.Compress(compression.Gzip, plaintext)
+	require.NoError(t, err)
+	zstded, err := compression.Compress(compression.Zstd, plaintext)
+	require.NoError(t, err)
+	bomb, err := compression.Compress(compression.Gzip, make([]byte, 1<<20))
+	require.NoError(t, err)
+
+	tests := []struct {
+		testName       string
+		zstd           bool
+		body           []byte
+		encoding       string
+		statusCode     int
+		acceptEncoding string
+	}{
+		{testName: "plain", body: plaintext, statusCode: 200, acceptEncoding: "gzip"},
+		{testName: "gzip", body: gzipped, encoding: "gzip", statusCode: 200, acceptEncoding: "gzip"},
+		{testName: "gzip_upper_case", body: gzipped, encoding: "GZIP", statusCode: 200, acceptEncoding: "gzip"},
+		{testName: "zstd_disabled", body: zstded, encoding: "zstd", statusCode: 415, acceptEncoding: "gzip"},
+		{testName: "zstd", zstd: true, body: zstded, encoding: "zstd", statusCode: 200, acceptEncoding: "gzip, zstd"},
+		{testName: "unknown", zstd: true, body: plaintext, encoding: "br", statusCode: 415, acceptEncoding: "gzip, zstd"},
+		{testName: "broken_gzip", body: plaintext, encoding: "gzip", statusCode: 400, acceptEncoding: "gzip"},
+		{testName: "bomb", body: bomb, encoding: "gzip", statusCode: 413, acceptEncoding: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			cfg := config.LoadConfig()
+			cfg.Server.StoreFile = "./.data"
+			cfg.Server.Restore = false
+			cfg.Server.Zstd = tt.zstd
+			cfg.Server.MaxBodySize = 64 << 10
+			storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+			go storage.RunReciver(ctx)
+
+			opts, err := server.NewRouterOptions(*cfg.Server)
+			require.NoError(t, err)
+			ts := httptest.NewServer(server.MakeRouterWithOptions(storage, opts))
+			defer ts.Close()
+
+			req, err := http.NewRequest("POST", ts.URL+"/updates/", bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Equal(t, tt.acceptEncoding, resp.Header.Get("Accept-Encoding"))
+
+			value, err := storage.GetCounterValue("PollCount")
+			if tt.statusCode == 200 {
+				require.NoError(t, err)
+				assert.Equal(t, int64(2), value)
+			} else {
+				assert.ErrorIs(t, err, datastorage.ErrNotFound)
+			}
+		})
+	}
+}
+
+func TestServerCompressionConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	cfg.Server.Zstd = true
+	cfg.Server.MaxBodySize = 1024
+	dataServer := server.New(*cfg.Server)
+	go dataServer.DataHolder.RunReciver(ctx)
+
+	router, err := dataServer.Router()
+	require.NoError(t, err)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	zstded, err := compression.Compress(compression.Zstd, []byte(`[{"id":"PollCount","type":"counter","delta":2}]`))
+	require.NoError(t, err)
+	oversized, err := compression.Compress(compression.Zstd, make([]byte, 2048))
+	require.NoError(t, err)
+
+	tests := []struct {
+		testName   string
+		body       []byte
+		statusCode int
+	}{
+		{testName: "zstd", body: zstded, statusCode: 200},
+		{testName: "oversized", body: oversized, statusCode: 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, err := http.NewRequest("POST", ts.URL+"/updates/", bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", compression.Zstd)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Equal(t, "gzip, zstd", resp.Header.Get("Accept-Encoding"))
+		})
+	}
+}
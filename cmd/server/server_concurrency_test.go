@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+// TestConcurrentUpdatesAndReads renders the stats pages while the metrics are updated, run it with -race.
+func TestConcurrentUpdatesAndReads(t *testing.T) {
+	for _, engine := range []string{datastorage.EngineReceiver, datastorage.EngineSharded} {
+		t.Run(engine, func(t *testing.T) {
+			testConcurrentUpdatesAndReads(t, engine)
+		})
+	}
+}
+
+func testConcurrentUpdatesAndReads(t *testing.T, engine string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	var storage server.DataBase
+	if engine == datastorage.EngineSharded {
+		storage = datastorage.NewShardedStorage(cfg.Server.StorageConfig)
+	} else {
+		storage = datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	}
+	go storage.RunReciver(ctx)
+
+	ts := httptest.NewServer(server.MakeRouter(storage))
+	defer ts.Close()
+
+	const workers, requests = 4, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < requests; i++ {
+				name := "Gauge" + strconv.Itoa(w*requests+i)
+				resp, err := http.Post(ts.URL+"/update/gauge/"+name+"/1", "text/plain", nil)
+				if assert.NoError(t, err) {
+					resp.Body.Close()
+					assert.Equal(t, 200, resp.StatusCode)
+				}
+				resp, err = http.Post(ts.URL+"/updates/", "application/json",
+					bytes.NewReader([]byte(`[{"id":"PollCount","type":"counter","delta":1}]`)))
+				if assert.NoError(t, err) {
+					resp.Body.Close()
+					assert.Equal(t, 200, resp.StatusCode)
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < requests; i++ {
+				for _, urlPath := range []string{"/", "/metrics"} {
+					resp, err := http.Get(ts.URL + urlPath)
+					if assert.NoError(t, err) {
+						ioutil.ReadAll(resp.Body)
+						resp.Body.Close()
+						assert.Equal(t, 200, resp.StatusCode)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	resp, err := http.Get(ts.URL + "/value/counter/PollCount")
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, strconv.Itoa(workers*requests), string(body))
+	}
+}
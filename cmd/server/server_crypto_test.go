@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/encryption"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestEncryptedUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	privatePEM, publicPEM, err := encryption.GenerateKeys(2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "private.pem"), privatePEM, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "public.pem"), publicPEM, 0644))
+	publicKey, err := encryption.LoadPublicKey(filepath.Join(dir, "public.pem"))
+	require.NoError(t, err)
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	cfg.Server.CryptoKey = filepath.Join(dir, "private.pem")
+	cfg.Server.MaxBodySize = 1024
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	opts, err := server.NewRouterOptions(*cfg.Server)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.MakeRouterWithOptions(storage, opts))
+	defer ts.Close()
+
+	plaintext := []byte(`[{"id":"PollCount","type":"counter","delta":2}]`)
+	encrypted, err := encryption.Encrypt(publicKey, plaintext)
+	require.NoError(t, err)
+	oversized, err := encryption.Encrypt(publicKey, make([]byte, 2048))
+	require.NoError(t, err)
+
+	tests := []struct {
+		testName   string
+		body       []byte
+		scheme     string
+		statusCode int
+	}{
+		{testName: "encrypted", body: encrypted, scheme: encryption.Scheme, statusCode: 200},
+		{testName: "plaintext", body: plaintext, statusCode: 200},
+		{testName: "corrupted", body: encrypted[:len(encrypted)-1], scheme: encryption.Scheme, statusCode: 400},
+		{testName: "unknown_scheme", body: encrypted, scheme: "rot13", statusCode: 400},
+		{testName: "oversized", body: oversized, scheme: encryption.Scheme, statusCode: 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, err := http.NewRequest("POST", ts.URL+"/updates/", bytes.NewReader(tt.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.scheme != "" {
+				req.Header.Set(encryption.HeaderName, tt.scheme)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+		})
+	}
+
+	value, err := storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), value)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestErrorResponces(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	cfg.Server.Key = "secret"
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	ts := httptest.NewServer(server.MakeRouter(storage))
+	defer ts.Close()
+
+	signed := datastorage.Metrics{ID: "HeapAlloc", MType: datastorage.GaugeTypeName, Value: 1.5}
+	signed.Hash, _ = signed.CalcHash("secret")
+	body, err := json.Marshal(&signed)
+	require.NoError(t, err)
+	resp, err := http.Post(ts.URL+"/update/", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+
+	tests := []struct {
+		testName string
+		method   string
+		urlPath  string
+		body     string
+		want     server.ErrorBody
+	}{
+		{
+			testName: "wrong_hash",
+			method:   "POST", urlPath: "/update/", body: `{"id":"HeapAlloc","type":"gauge","value":2,"hash":"00"}`,
+			want: server.ErrorBody{Code: 401, Message: "DataStorage: wrong hash", Metric: "HeapAlloc"},
+		},
+		{
+			testName: "wrong_json_type",
+			method:   "POST", urlPath: "/value/", body: `{"id":"HeapAlloc","type":"summary"}`,
+			want: server.ErrorBody{Code: 422, Message: "DataStorage: wrong metric type: summary", Metric: "HeapAlloc"},
+		},
+		{
+			testName: "broken_json",
+			method:   "POST", urlPath: "/update/", body: `{"id":`,
+			want: server.ErrorBody{Code: 400, Message: "DataStorage: parse error: unexpected end of JSON input"},
+		},
+		{
+			testName: "json_not_found",
+			method:   "POST", urlPath: "/value/", body: `{"id":"Missing","type":"gauge"}`,
+			want: server.ErrorBody{Code: 404, Message: "DataStorage: metric not found", Metric: "Missing"},
+		},
+		{
+			testName: "text_not_found",
+			method:   "GET", urlPath: "/value/counter/Missing",
+			want: server.ErrorBody{Code: 404, Message: "DataStorage: metric not found", Metric: "Missing"},
+		},
+		{
+			testName: "wrong_value",
+			method:   "POST", urlPath: "/update/gauge/HeapAlloc/none",
+			want: server.ErrorBody{Code: 400, Message: `DataStorage: parse error: gauge value "none"`, Metric: "HeapAlloc"},
+		},
+		{
+			testName: "wrong_path_type",
+			method:   "POST", urlPath: "/update/summary/HeapAlloc/1",
+			want: server.ErrorBody{Code: 501, Message: "Wrong metric type", Metric: "HeapAlloc"},
+		},
+		{
+			testName: "unknown_route",
+			method:   "GET", urlPath: "/unknown",
+			want: server.ErrorBody{Code: 404, Message: "route not found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, bytes.NewReader([]byte(tt.body)))
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			respBody, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want.Code, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			errorBody := server.ErrorBody{}
+			require.NoError(t, json.Unmarshal(respBody, &errorBody), string(respBody))
+			assert.Equal(t, tt.want, errorBody)
+		})
+	}
+}
+
+// TestNonFiniteGauge posts NaN to a synchronized storage, it is rejected and the snapshots
+// of the later updates are still written.
+func TestNonFiniteGauge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = filepath.Join(t.TempDir(), "metrics.db")
+	cfg.Server.Restore = false
+	cfg.Server.Store = true
+	cfg.Server.Synchronized = true
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	ts := httptest.NewServer(server.MakeRouter(storage))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		urlPath    string
+		statusCode int
+	}{
+		{urlPath: "/update/gauge/HeapAlloc/NaN", statusCode: 422},
+		{urlPath: "/update/gauge/HeapAlloc/+Inf", statusCode: 422},
+		{urlPath: "/update/gauge/HeapAlloc/1.5", statusCode: 200},
+		{urlPath: "/update/counter/PollCount/1", statusCode: 200},
+	} {
+		resp, err := http.Post(ts.URL+tt.urlPath, "text/plain", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, tt.statusCode, resp.StatusCode, tt.urlPath)
+	}
+
+	value, err := storage.GetGaugeValue("HeapAlloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, value)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	pb "github.com/nikolaevs92/Practicum/internal/proto"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+// newGRPCClient serves the storage over an in-memory listener until the test ends.
+func newGRPCClient(ctx context.Context, t *testing.T, storage server.DataBase) pb.MetricsClient {
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	pb.RegisterMetricsServer(grpcServer, server.NewGRPCServer(storage))
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsClient(conn)
+}
+
+// statsCounter counts the stats reads of a watch.
+type statsCounter struct {
+	server.DataBase
+	reads int32
+}
+
+func (storage *statsCounter) GetStatsFiltered(filter datastorage.StatsFilter) (map[string]float64, map[string]int64, error) {
+	atomic.AddInt32(&storage.reads, 1)
+	return storage.DataBase.GetStatsFiltered(filter)
+}
+
+func TestGRPCServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	client := newGRPCClient(ctx, t, storage)
+
+	_, err := client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{Id: "HeapAlloc", Type: "gauge", Value: 1.5}})
+	require.NoError(t, err)
+	batchResp, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "PollCount", Type: "counter", Delta: 2},
+		{Id: "PollCount", Type: "counter", Delta: 3},
+	}})
+	require.NoError(t, err)
+	require.Len(t, batchResp.Results, 2)
+	assert.Equal(t, datastorage.BatchStatusOK, batchResp.Results[1].Status)
+	assert.Equal(t, int64(5), batchResp.Results[1].Metric.Delta)
+	assert.Equal(t, int64(2), batchResp.Metric.Delta)
+
+	resp, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), resp.Metric.Delta)
+
+	resp, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "HeapAlloc", Type: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, resp.Metric.Value)
+
+	_, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{Id: "ReportLatency", Type: "histogram",
+		Histogram: &pb.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}}})
+	require.NoError(t, err)
+	resp, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "ReportLatency", Type: "histogram"})
+	require.NoError(t, err)
+	require.NotNil(t, resp.Metric.Histogram)
+	assert.Equal(t, []uint64{1, 0}, resp.Metric.Histogram.Counts)
+
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Missing", Type: "gauge"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	watchCtx, watchCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer watchCancel()
+	stream, err := client.WatchMetrics(watchCtx, &pb.WatchMetricsRequest{Prefix: "Heap", IntervalMs: 10})
+	require.NoError(t, err)
+
+	metric, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "HeapAlloc", metric.Id)
+	assert.Equal(t, 1.5, metric.Value)
+
+	_, err = client.UpdateMetric(ctx, &pb.UpdateMetricRequest{Metric: &pb.Metric{Id: "HeapAlloc", Type: "gauge", Value: 2.5}})
+	require.NoError(t, err)
+	metric, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "HeapAlloc", metric.Id)
+	assert.Equal(t, 2.5, metric.Value)
+}
+
+func TestGRPCWatchMinInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	storage := datastorage.NewFileStorage(datastorage.StorageConfig{})
+	go storage.RunReciver(ctx)
+	counter := &statsCounter{DataBase: storage}
+	client := newGRPCClient(ctx, t, counter)
+
+	watchCtx, watchCancel := context.WithTimeout(ctx, 350*time.Millisecond)
+	defer watchCancel()
+	stream, err := client.WatchMetrics(watchCtx, &pb.WatchMetricsRequest{IntervalMs: 1})
+	require.NoError(t, err)
+	// the storage is empty, the stream only ends with the deadline
+	_, err = stream.Recv()
+	assert.Error(t, err)
+
+	reads := atomic.LoadInt32(&counter.reads)
+	assert.GreaterOrEqual(t, reads, int32(2))
+	assert.LessOrEqual(t, reads, int32(5), "a 1ms interval is raised to 100ms")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestHistogramHandlers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	ts := httptest.NewServer(server.MakeRouter(storage))
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, "POST", "/update/histogram/ReportLatency/0.3")
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+
+	resp, _ = testRequest(t, ts, "POST", "/update/histogram/ReportLatency/none")
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+
+	delta := datastorage.NewHistogram(datastorage.DefaultHistogramBounds)
+	delta.Observe(20)
+	resp, metrics := testJSONRequest(t, ts, "POST", "/update/", datastorage.Metrics{ID: "ReportLatency", MType: datastorage.HistogramTypeName, Histogram: &delta})
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, &delta, metrics.Histogram)
+
+	resp, body := testRequest(t, ts, "GET", "/value/histogram/ReportLatency")
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	histogram := datastorage.Histogram{}
+	require.NoError(t, json.Unmarshal([]byte(body), &histogram))
+	assert.Equal(t, uint64(2), histogram.Count)
+	assert.Equal(t, uint64(1), histogram.Counts[len(histogram.Counts)-1])
+
+	resp, metrics = testJSONRequest(t, ts, "POST", "/value/", datastorage.Metrics{ID: "ReportLatency", MType: datastorage.HistogramTypeName})
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	require.NotNil(t, metrics.Histogram)
+	assert.InDelta(t, 20.3, metrics.Histogram.Sum, 1e-9)
+
+	resp, _ = testRequest(t, ts, "GET", "/value/histogram/Unknown")
+	resp.Body.Close()
+	assert.Equal(t, 404, resp.StatusCode)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	r := server.MakeRouter(storage)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, path := range []string{"/update/gauge/HeapAlloc/10", "/update/gauge/HeapAlloc/20", "/update/counter/PollCount/3"} {
+		resp, _ := testRequest(t, ts, "POST", path)
+		resp.Body.Close()
+		require.Equal(t, 200, resp.StatusCode)
+	}
+
+	tests := []struct {
+		testName   string
+		urlPath    string
+		statusCode int
+		values     []float64
+		deltas     []int64
+	}{
+		{
+			testName:   "gauge_history",
+			urlPath:    "/history/gauge/HeapAlloc",
+			statusCode: 200,
+			values:     []float64{10, 20},
+		},
+		{
+			testName:   "counter_history",
+			urlPath:    "/history/counter/PollCount?from=0",
+			statusCode: 200,
+			deltas:     []int64{3},
+		},
+		{
+			testName:   "empty_window",
+			urlPath:    "/history/gauge/HeapAlloc?from=2000-01-01T00:00:00Z&to=2000-01-02T00:00:00Z",
+			statusCode: 200,
+		},
+		{
+			testName:   "wrong_from",
+			urlPath:    "/history/gauge/HeapAlloc?from=yesterday",
+			statusCode: 400,
+		},
+		{
+			testName:   "wrong_type",
+			urlPath:    "/history/histogram/HeapAlloc",
+			statusCode: 501,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			resp, body := testRequest(t, ts, "GET", tt.urlPath)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			if tt.statusCode != 200 {
+				return
+			}
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+			samples := []datastorage.HistorySample{}
+			require.NoError(t, json.Unmarshal([]byte(body), &samples))
+			values := []float64{}
+			deltas := []int64{}
+			for _, sample := range samples {
+				if sample.MType == datastorage.GaugeTypeName {
+					values = append(values, sample.Value)
+				} else {
+					deltas = append(deltas, sample.Delta)
+				}
+			}
+			if tt.values == nil {
+				tt.values = []float64{}
+			}
+			if tt.deltas == nil {
+				tt.deltas = []int64{}
+			}
+			assert.Equal(t, tt.values, values)
+			assert.Equal(t, tt.deltas, deltas)
+		})
+	}
+}
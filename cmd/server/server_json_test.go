@@ -40,7 +40,7 @@ func TestJSONHandler(t *testing.T) {
 					urlPath:    "/update",
 					input:      datastorage.Metrics{},
 					output:     datastorage.Metrics{},
-					statusCode: 404,
+					statusCode: 400,
 				},
 				{
 					urlPath: "/update",
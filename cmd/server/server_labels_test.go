@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestLabeledMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	ts := httptest.NewServer(server.MakeRouter(storage))
+	defer ts.Close()
+
+	resp, _ := testJSONRequest(t, ts, "POST", "/update/", datastorage.Metrics{
+		ID: "cpu_utilization", MType: datastorage.GaugeTypeName, Value: 12.5, Labels: map[string]string{"core": "1"},
+	})
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+
+	for _, path := range []string{
+		"/update/gauge/cpu_utilization/40?label=core=2",
+		"/update/counter/requests/3?label=code=200&label=method=GET",
+	} {
+		resp, _ := testRequest(t, ts, "POST", path)
+		resp.Body.Close()
+		require.Equal(t, 200, resp.StatusCode, path)
+	}
+
+	tests := []struct {
+		testName   string
+		urlPath    string
+		statusCode int
+		body       string
+	}{
+		{testName: "gauge_series", urlPath: "/value/gauge/cpu_utilization?label=core=1", statusCode: 200, body: "12.5"},
+		{testName: "text_update_series", urlPath: "/value/gauge/cpu_utilization?label=core=2", statusCode: 200, body: "40"},
+		{testName: "labels_are_the_key", urlPath: "/value/gauge/cpu_utilization", statusCode: 404,
+			body: `{"code":404,"message":"DataStorage: metric not found","metric":"cpu_utilization"}`},
+		{testName: "counter_series", urlPath: "/value/counter/requests?label=method=GET&label=code=200", statusCode: 200, body: "3"},
+		{testName: "wrong_matcher", urlPath: "/value/gauge/cpu_utilization?label=core", statusCode: 400,
+			body: `{"code":400,"message":"Wrong labels","metric":"cpu_utilization"}`},
+		{testName: "wrong_label_name", urlPath: "/value/gauge/cpu_utilization?label=a-b=1", statusCode: 400,
+			body: `{"code":400,"message":"Wrong labels","metric":"cpu_utilization"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			resp, body := testRequest(t, ts, "GET", tt.urlPath)
+			resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Equal(t, tt.body, body)
+		})
+	}
+
+	resp, metrics := testJSONRequest(t, ts, "POST", "/value/", datastorage.Metrics{
+		ID: "cpu_utilization", MType: datastorage.GaugeTypeName, Labels: map[string]string{"core": "2"},
+	})
+	resp.Body.Close()
+	require.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 40.0, metrics.Value)
+	assert.Equal(t, map[string]string{"core": "2"}, metrics.Labels)
+
+	resp, body := testRequest(t, ts, "GET", "/metrics")
+	resp.Body.Close()
+	assert.Equal(t, "# HELP cpu_utilization gauge metric cpu_utilization\n"+
+		"# TYPE cpu_utilization gauge\n"+
+		"cpu_utilization{core=\"1\"} 12.5\n"+
+		"cpu_utilization{core=\"2\"} 40\n"+
+		"# HELP requests counter metric requests\n"+
+		"# TYPE requests counter\n"+
+		"requests{code=\"200\",method=\"GET\"} 3\n", body)
+}
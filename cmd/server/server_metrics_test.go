@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	r := server.MakeRouter(storage)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, path := range []string{"/update/gauge/HeapAlloc/10.5", "/update/gauge/3cpu.load/0.25", "/update/counter/PollCount/3"} {
+		resp, _ := testRequest(t, ts, "POST", path)
+		resp.Body.Close()
+		require.Equal(t, 200, resp.StatusCode)
+	}
+
+	tests := []struct {
+		testName    string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{
+			testName:    "text_format",
+			contentType: "text/plain; version=0.0.4; charset=utf-8",
+			body: "# HELP HeapAlloc gauge metric HeapAlloc\n" +
+				"# TYPE HeapAlloc gauge\n" +
+				"HeapAlloc 10.5\n" +
+				"# HELP PollCount counter metric PollCount\n" +
+				"# TYPE PollCount counter\n" +
+				"PollCount 3\n" +
+				"# HELP _3cpu_load gauge metric 3cpu.load\n" +
+				"# TYPE _3cpu_load gauge\n" +
+				"_3cpu_load 0.25\n",
+		},
+		{
+			testName:    "openmetrics_format",
+			accept:      "application/openmetrics-text; version=1.0.0, text/plain;q=0.5",
+			contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8",
+			body: "# HELP HeapAlloc gauge metric HeapAlloc\n" +
+				"# TYPE HeapAlloc gauge\n" +
+				"HeapAlloc 10.5\n" +
+				"# HELP PollCount counter metric PollCount\n" +
+				"# TYPE PollCount counter\n" +
+				"PollCount_total 3\n" +
+				"# HELP _3cpu_load gauge metric 3cpu.load\n" +
+				"# TYPE _3cpu_load gauge\n" +
+				"_3cpu_load 0.25\n" +
+				"# EOF\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, err := http.NewRequest("GET", ts.URL+"/metrics", nil)
+			require.NoError(t, err)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, 200, resp.StatusCode)
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, tt.body, string(body))
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+// TestRedisReplicas runs two servers on one Redis, an update sent to one of them is read from the other.
+func TestRedisReplicas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	redisServer := miniredis.RunT(t)
+
+	cfg := config.LoadConfig()
+	cfg.Server.RedisURL = "redis://" + redisServer.Addr()
+	replicas := []*httptest.Server{}
+	for i := 0; i < 2; i++ {
+		storage := datastorage.NewRedisStorage(cfg.Server.StorageConfig)
+		require.NoError(t, storage.Open(ctx))
+		ts := httptest.NewServer(server.MakeRouter(storage))
+		defer ts.Close()
+		replicas = append(replicas, ts)
+	}
+
+	for _, ts := range replicas {
+		resp, err := http.Post(ts.URL+"/update/counter/PollCount/2", "text/plain", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, 200, resp.StatusCode)
+	}
+
+	for _, ts := range replicas {
+		resp, err := http.Get(ts.URL + "/value/counter/PollCount")
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "4", string(body))
+	}
+}
+
+func TestRedisNotOpened(t *testing.T) {
+	cfg := config.LoadConfig()
+	cfg.Server.RedisURL = "mysql://localhost"
+	dataServer := server.New(*cfg.Server)
+	assert.Error(t, dataServer.Open(context.Background()), "a bad url fails the start")
+
+	ts := httptest.NewServer(server.MakeRouter(dataServer.DataHolder))
+	defer ts.Close()
+	tests := []struct {
+		method string
+		url    string
+	}{
+		{method: "POST", url: "/update/counter/PollCount/2"},
+		{method: "GET", url: "/value/counter/PollCount"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.url, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, tt.url)
+	}
+}
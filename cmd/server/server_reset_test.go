@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestResetCounter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	ts := httptest.NewServer(server.MakeRouter(storage))
+	defer ts.Close()
+
+	for _, path := range []string{
+		"/update/counter/PollCount/9223372036854775806",
+		"/update/counter/requests/3?label=code=200",
+	} {
+		resp, _ := testRequest(t, ts, "POST", path)
+		resp.Body.Close()
+		require.Equal(t, 200, resp.StatusCode, path)
+	}
+
+	tests := []struct {
+		testName   string
+		method     string
+		urlPath    string
+		statusCode int
+		body       string
+	}{
+		{testName: "overflow", method: "POST", urlPath: "/update/counter/PollCount/2", statusCode: 422},
+		{testName: "value_kept", method: "GET", urlPath: "/value/counter/PollCount", statusCode: 200, body: "9223372036854775806"},
+		{testName: "reset", method: "POST", urlPath: "/reset/counter/PollCount", statusCode: 200},
+		{testName: "reset_value", method: "GET", urlPath: "/value/counter/PollCount", statusCode: 200, body: "0"},
+		{testName: "negative_delta", method: "POST", urlPath: "/update/counter/PollCount/-5", statusCode: 200},
+		{testName: "negative_value", method: "GET", urlPath: "/value/counter/PollCount", statusCode: 200, body: "-5"},
+		{testName: "reset_series", method: "POST", urlPath: "/reset/counter/requests?label=code=200", statusCode: 200},
+		{testName: "reset_series_value", method: "GET", urlPath: "/value/counter/requests?label=code=200", statusCode: 200, body: "0"},
+		{testName: "reset_unknown", method: "POST", urlPath: "/reset/counter/Unknown", statusCode: 404,
+			body: `{"code":404,"message":"DataStorage: metric not found: counter","metric":"Unknown"}`},
+		{testName: "reset_wrong_labels", method: "POST", urlPath: "/reset/counter/requests?label=code", statusCode: 400,
+			body: `{"code":400,"message":"Wrong labels","metric":"requests"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			resp, body := testRequest(t, ts, tt.method, tt.urlPath)
+			resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			if tt.body != "" {
+				assert.Equal(t, tt.body, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nikolaevs92/Practicum/internal/config"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/server"
+)
+
+func TestTrustedSubnet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := config.LoadConfig()
+	cfg.Server.StoreFile = "./.data"
+	cfg.Server.Restore = false
+	cfg.Server.TrustedSubnet = "192.168.1.0/24, fd00::/8"
+	storage := datastorage.NewFileStorage(cfg.Server.StorageConfig)
+	go storage.RunReciver(ctx)
+
+	opts, err := server.NewRouterOptions(*cfg.Server)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server.MakeRouterWithOptions(storage, opts))
+	defer ts.Close()
+
+	tests := []struct {
+		testName   string
+		method     string
+		urlPath    string
+		realIP     string
+		statusCode int
+	}{
+		{testName: "no_real_ip", method: "POST", urlPath: "/update/gauge/a/1", statusCode: 403},
+		{testName: "ipv4_inside", method: "POST", urlPath: "/update/gauge/a/1", realIP: "192.168.1.17", statusCode: 200},
+		{testName: "ipv4_outside", method: "POST", urlPath: "/update/gauge/a/1", realIP: "192.168.2.17", statusCode: 403},
+		{testName: "ipv6_inside", method: "POST", urlPath: "/update/gauge/a/1", realIP: "fd12::1", statusCode: 200},
+		{testName: "ipv6_bracketed", method: "POST", urlPath: "/update/gauge/a/1", realIP: "[fd12::1]", statusCode: 200},
+		{testName: "ipv6_outside", method: "POST", urlPath: "/update/gauge/a/1", realIP: "2001:db8::1", statusCode: 403},
+		{testName: "garbage", method: "POST", urlPath: "/update/gauge/a/1", realIP: "localhost", statusCode: 403},
+		{testName: "batch_outside", method: "POST", urlPath: "/updates/", realIP: "10.0.0.1", statusCode: 403},
+		{testName: "read_is_open", method: "GET", urlPath: "/value/gauge/a", statusCode: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, nil)
+			require.NoError(t, err)
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+		})
+	}
+
+	_, err = server.ParseSubnets("192.168.1.0/33")
+	assert.Error(t, err)
+}
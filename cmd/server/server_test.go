@@ -114,7 +114,11 @@ func TestStatHandler(t *testing.T) {
 			if !assert.Equal(t, tt.statusCode, resp.StatusCode) {
 				fmt.Println(body)
 			}
-			assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+			if tt.statusCode == http.StatusOK {
+				assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+			} else {
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			}
 		})
 	}
 }
@@ -3,92 +3,208 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
 	"path"
-	"runtime"
 	"sync"
 	"time"
 
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"github.com/nikolaevs92/Practicum/internal/compression"
 	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	"github.com/nikolaevs92/Practicum/internal/encryption"
+	pb "github.com/nikolaevs92/Practicum/internal/proto"
 )
 
 type Config struct {
-	Server         string
-	PollInterval   time.Duration
-	ReportInterval time.Duration
-	ReportRetries  int
-	Key            string
+	Server           string
+	PollInterval     time.Duration
+	ReportInterval   time.Duration
+	ReportRetries    int
+	Key              string
+	Transport        string
+	GRPCServer       string
+	CryptoKey        string
+	SpoolFile        string
+	SpoolMaxSize     int64
+	RetryBackoff     []time.Duration
+	RetryJitter      float64
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	Compression      string
+	CompressMinSize  int
+	Collectors       string
 }
 
 const (
-	gaugeTypeName   string = "gauge"
-	counterTypeName string = "counter"
+	gaugeTypeName     string = "gauge"
+	counterTypeName   string = "counter"
+	histogramTypeName string = "histogram"
+)
+
+// errBreakerOpen is returned for the reports skipped while the breaker is open.
+var errBreakerOpen = errors.New("reports are paused after repeated failures")
+
+const (
+	TransportHTTP string = "http"
+	TransportGRPC string = "grpc"
 )
 
 type CollectorAgent struct {
 	cfg Config
 
-	stats          runtime.MemStats
-	TotalMemory    uint64
-	FreeMemory     uint64
-	CPUutilization map[string]float64
-	PollCount      uint64
-	RandomValue    float64
-	mu             sync.RWMutex
+	sources   []*source
+	PollCount int64
+	// reportedPollCount is the part of PollCount the server took or a report in flight carries.
+	reportedPollCount int64
+	mu                sync.RWMutex
+
+	// reportLatency holds the report durations, in seconds, the server has not received yet.
+	reportLatency datastorage.Histogram
+
+	grpcConn   *grpc.ClientConn
+	grpcClient pb.MetricsClient
+	publicKey  *rsa.PublicKey
+
+	// spool keeps the batches the server did not take, it is nil when SpoolFile is empty.
+	spool   *spool
+	breaker *breaker
+	// zstdAccepted is set while the server lists zstd in Accept-Encoding.
+	zstdAccepted int32
 }
 
 func New(config Config) *CollectorAgent {
 	collector := new(CollectorAgent)
 	collector.cfg = config
-	collector.CPUutilization = make(map[string]float64)
+	sources, err := newSources(config.Collectors)
+	if err != nil {
+		panic(err)
+	}
+	collector.sources = sources
+	collector.reportLatency = datastorage.NewHistogram(datastorage.DefaultHistogramBounds)
+	if config.CryptoKey != "" {
+		publicKey, err := encryption.LoadPublicKey(config.CryptoKey)
+		if err != nil {
+			panic(err)
+		}
+		collector.publicKey = publicKey
+	}
+	collector.breaker = newBreaker(config.BreakerThreshold, config.BreakerCooldown)
+	if config.SpoolFile != "" {
+		collector.spool = newSpool(config.SpoolFile, config.SpoolMaxSize, config.Key)
+	}
+	if config.Transport == TransportGRPC {
+		conn, err := grpc.Dial(config.GRPCServer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Println("grpc connection arent created: " + err.Error())
+		} else {
+			collector.grpcConn = conn
+			collector.grpcClient = pb.NewMetricsClient(conn)
+		}
+	}
 	return collector
 }
 
+// Collect polls the collectors without their own interval and counts the poll.
 func (collector *CollectorAgent) Collect(t time.Time) {
-	collector.mu.Lock()
-	defer collector.mu.Unlock()
-
 	log.Println("Start collect stat")
 
-	runtime.ReadMemStats(&collector.stats)
-
-	v, err := mem.VirtualMemory()
-	if err != nil {
-		collector.TotalMemory = v.Total
-		collector.FreeMemory = v.Free
-	}
-	c, err := cpu.Percent(time.Millisecond, true)
-	if err != nil {
-		for i := 1; i <= runtime.NumCPU(); i++ {
-			collector.CPUutilization[fmt.Sprintf("CPUutilization%d", i)] = c[i]
-		}
-	} else {
-		for i := 1; i <= runtime.NumCPU(); i++ {
-			collector.CPUutilization[fmt.Sprintf("CPUutilization%d", i)] = 0
+	for _, src := range collector.sources {
+		if src.interval == 0 {
+			collector.pollSource(src)
 		}
 	}
 
-	collector.RandomValue = rand.Float64()
+	collector.mu.Lock()
 	collector.PollCount++
+	collector.mu.Unlock()
 
 	log.Println("End collect stat")
 }
 
+// outboundIP returns the local address used to reach the server, no packets are sent.
+func outboundIP(server string) string {
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		log.Println("Local ip arent resolved: " + err.Error())
+		return ""
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func (collector *CollectorAgent) post(url string, contentType string, encoding string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if encoding != compression.Identity {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if realIP := outboundIP(collector.cfg.Server); realIP != "" {
+		req.Header.Set("X-Real-IP", realIP)
+	}
+	if collector.publicKey != nil {
+		req.Header.Set(encryption.HeaderName, encryption.Scheme)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err == nil {
+		collector.learnEncodings(resp.Header.Get("Accept-Encoding"))
+	}
+	return resp, err
+}
+
 func (collector *CollectorAgent) PostWithRetrues(url string, contentType string, body []byte) (*http.Response, error) {
-	resp, err := http.Post(url, contentType, bytes.NewReader(body))
-	for i := 0; i < collector.cfg.ReportRetries && err != nil; i++ {
-		resp, err = http.Post(url, "application/json", bytes.NewReader(body))
+	encoding := collector.bodyEncoding(len(body))
+	resp, err := collector.postEncoded(url, contentType, encoding, body)
+	if err == nil && resp.StatusCode == http.StatusUnsupportedMediaType && encoding == compression.Zstd {
+		log.Println("Server doesnt take zstd anymore, send gzip")
+		discardBody(resp)
+		return collector.postEncoded(url, contentType, compression.Gzip, body)
 	}
 	return resp, err
 }
 
+// postEncoded compresses the body, then encrypts it, and sends it with the retry policy.
+func (collector *CollectorAgent) postEncoded(url string, contentType string, encoding string, body []byte) (*http.Response, error) {
+	body, err := compression.Compress(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+	if collector.publicKey != nil {
+		encrypted, err := encryption.Encrypt(collector.publicKey, body)
+		if err != nil {
+			return nil, err
+		}
+		body = encrypted
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := collector.post(url, contentType, encoding, body)
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		delay, retry := collector.retryDelay(attempt, resp)
+		if !retry {
+			return resp, err
+		}
+		discardBody(resp)
+		log.Println("Post retry in " + delay.String())
+		time.Sleep(delay)
+	}
+}
+
 func (collector *CollectorAgent) PostOneStat(metrics datastorage.Metrics) {
 	log.Println("Post one stat to " + collector.cfg.Server)
 	log.Println(metrics)
@@ -121,7 +237,7 @@ func (collector *CollectorAgent) PostOneGaugeStat(metricName string, metricValue
 	})
 }
 
-func (collector *CollectorAgent) PostOneCounterStat(metricName string, metricValue uint64) {
+func (collector *CollectorAgent) PostOneCounterStat(metricName string, metricValue int64) {
 	collector.PostOneStat(datastorage.Metrics{
 		ID:    metricName,
 		MType: counterTypeName,
@@ -133,211 +249,191 @@ func (collector *CollectorAgent) getMetrcisSlice() []datastorage.Metrics {
 	collector.mu.RLock()
 	defer collector.mu.RUnlock()
 
-	metrics := []datastorage.Metrics{
-		datastorage.Metrics{
-			ID:    "Alloc",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.Alloc),
-		},
-		datastorage.Metrics{
-			ID:    "TotalAlloc",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.TotalAlloc),
-		},
-		datastorage.Metrics{
-			ID:    "Frees",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.Frees),
-		},
-		datastorage.Metrics{
-			ID:    "BuckHashSys",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.BuckHashSys),
-		},
-		datastorage.Metrics{
-			ID:    "GCCPUFraction",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.GCCPUFraction),
-		},
-		datastorage.Metrics{
-			ID:    "GCSys",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.GCSys),
-		},
-		datastorage.Metrics{
-			ID:    "HeapAlloc",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.HeapAlloc),
-		},
-		datastorage.Metrics{
-			ID:    "HeapIdle",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.HeapIdle),
-		},
-		datastorage.Metrics{
-			ID:    "HeapInuse",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.HeapInuse),
-		},
-		datastorage.Metrics{
-			ID:    "HeapObjects",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.HeapObjects),
-		},
-		datastorage.Metrics{
-			ID:    "HeapReleased",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.HeapReleased),
-		},
-		datastorage.Metrics{
-			ID:    "HeapSys",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.HeapSys),
-		},
-		datastorage.Metrics{
-			ID:    "LastGC",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.LastGC),
-		},
-		datastorage.Metrics{
-			ID:    "Lookups",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.Lookups),
-		},
-		datastorage.Metrics{
-			ID:    "MCacheInuse",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.MCacheInuse),
-		},
-		datastorage.Metrics{
-			ID:    "MCacheSys",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.MCacheSys),
-		},
-		datastorage.Metrics{
-			ID:    "MSpanInuse",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.MSpanInuse),
-		},
-		datastorage.Metrics{
-			ID:    "MSpanSys",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.MSpanSys),
-		},
-		datastorage.Metrics{
-			ID:    "Mallocs",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.Mallocs),
-		},
-		datastorage.Metrics{
-			ID:    "NextGC",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.NextGC),
-		},
-		datastorage.Metrics{
-			ID:    "NumForcedGC",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.NumForcedGC),
-		},
-		datastorage.Metrics{
-			ID:    "NumGC",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.NumGC),
-		},
-		datastorage.Metrics{
-			ID:    "OtherSys",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.OtherSys),
-		},
-		datastorage.Metrics{
-			ID:    "PauseTotalNs",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.PauseTotalNs),
-		},
-		datastorage.Metrics{
-			ID:    "StackInuse",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.StackInuse),
-		},
-		datastorage.Metrics{
-			ID:    "StackSys",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.StackSys),
-		},
-		datastorage.Metrics{
-			ID:    "Sys",
-			MType: gaugeTypeName,
-			Value: float64(collector.stats.Sys),
-		},
-
-		datastorage.Metrics{
-			ID:    "RandomValue",
-			MType: gaugeTypeName,
-			Value: float64(collector.RandomValue),
-		},
-
-		datastorage.Metrics{
-			ID:    "FreeMemory",
-			MType: counterTypeName,
-			Delta: collector.FreeMemory,
-		},
-		datastorage.Metrics{
-			ID:    "TotalMemory",
-			MType: counterTypeName,
-			Delta: collector.TotalMemory,
-		},
-		datastorage.Metrics{
-			ID:    "PollCount",
-			MType: counterTypeName,
-			Delta: collector.PollCount,
-		},
-	}
-
-	for i := 1; i <= runtime.NumCPU(); i++ {
-		metricName := fmt.Sprintf("CPUutilization%d", i)
+	metrics := []datastorage.Metrics{}
+	for _, src := range collector.sources {
+		metrics = append(metrics, src.metrics...)
+	}
+	return metrics
+}
 
+func (collector *CollectorAgent) Report(t time.Time) {
+	metrics := collector.getMetrcisSlice()
+	pollCount := collector.takePollCount()
+	metrics = append(metrics, datastorage.Metrics{
+		ID:    "PollCount",
+		MType: counterTypeName,
+		Delta: pollCount,
+	})
+	latency := collector.takeReportLatency()
+	if latency.Count > 0 {
 		metrics = append(metrics, datastorage.Metrics{
-			ID:    metricName,
-			MType: gaugeTypeName,
-			Value: collector.CPUutilization[metricName],
+			ID:        "ReportLatency",
+			MType:     histogramTypeName,
+			Histogram: &latency,
 		})
 	}
 
-	return metrics
+	for i := range metrics {
+		metrics[i].Hash, _ = metrics[i].CalcHash(collector.cfg.Key)
+	}
+
+	start := time.Now()
+	err := collector.deliver(metrics)
+	collector.observeReport(time.Since(start), latency, pollCount, err == nil)
 }
 
-func (collector *CollectorAgent) Report(t time.Time) {
-	metrics := collector.getMetrcisSlice()
+func (collector *CollectorAgent) send(metrics []datastorage.Metrics) error {
+	if collector.cfg.Transport == TransportGRPC {
+		return collector.reportGRPC(metrics)
+	}
+	return collector.reportHTTP(metrics)
+}
+
+// sendAll sends the spooled batches first to keep the order, then the new one.
+func (collector *CollectorAgent) sendAll(metrics []datastorage.Metrics) error {
+	if collector.spool != nil {
+		if err := collector.spool.Replay(collector.send); err != nil {
+			return err
+		}
+	}
+	return collector.send(metrics)
+}
+
+// deliver sends the batch unless the breaker is open, the batch the server can't take now is spooled.
+// A spooled batch counts as delivered.
+func (collector *CollectorAgent) deliver(metrics []datastorage.Metrics) error {
+	err := errBreakerOpen
+	if collector.breaker.Allow(time.Now()) {
+		err = collector.sendAll(metrics)
+		collector.breaker.Done(time.Now(), err != nil && !errors.Is(err, errRejected))
+	}
+	if err == nil || errors.Is(err, errRejected) || collector.spool == nil {
+		return err
+	}
+	if spoolErr := collector.spool.Push(metrics); spoolErr != nil {
+		log.Println("Spool error: " + spoolErr.Error())
+		return err
+	}
+	log.Println("Batch is spooled: " + err.Error())
+	return nil
+}
+
+// takeReportLatency returns the pending report durations and starts a new histogram.
+func (collector *CollectorAgent) takeReportLatency() datastorage.Histogram {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	latency := collector.reportLatency
+	collector.reportLatency = datastorage.NewHistogram(datastorage.DefaultHistogramBounds)
+	return latency
+}
+
+// takePollCount returns the polls since the last report, a report in flight owns its polls
+// so the concurrent one doesn't send them again.
+func (collector *CollectorAgent) takePollCount() int64 {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	delta := collector.PollCount - collector.reportedPollCount
+	collector.reportedPollCount = collector.PollCount
+	return delta
+}
+
+// observeReport counts the report duration; the durations and the polls of a failed report are kept for the next one.
+func (collector *CollectorAgent) observeReport(duration time.Duration, latency datastorage.Histogram, pollCount int64, sent bool) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
 
+	if !sent {
+		collector.reportedPollCount -= pollCount
+	}
+	if !sent && latency.Count > 0 {
+		if err := collector.reportLatency.Merge(latency); err != nil {
+			log.Println(err)
+		}
+	}
+	collector.reportLatency.Observe(duration.Seconds())
+}
+
+func (collector *CollectorAgent) reportGRPC(metrics []datastorage.Metrics) error {
+	log.Println("Send batch stats over grpc to " + collector.cfg.GRPCServer)
+	if collector.grpcClient == nil {
+		log.Println("grpc client arent created")
+		return errors.New("grpc client arent created")
+	}
+
+	req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, metric := range metrics {
+		req.Metrics = append(req.Metrics, pb.FromMetrics(metric))
+	}
+
+	realIP := outboundIP(collector.cfg.GRPCServer)
+	err := collector.updateMetrics(req, realIP)
+	for attempt := 0; attempt < collector.cfg.ReportRetries && retryableCode(status.Code(err)); attempt++ {
+		delay := collector.backoff(attempt)
+		log.Println("Send retry in " + delay.String())
+		time.Sleep(delay)
+		err = collector.updateMetrics(req, realIP)
+	}
+	if err != nil {
+		log.Println("Send error" + err.Error())
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied, codes.ResourceExhausted:
+			return fmt.Errorf("%w: %s", errRejected, err)
+		}
+		return err
+	}
+	log.Println("Send batch stats over grpc: succesed")
+	return nil
+}
+
+func (collector *CollectorAgent) updateMetrics(req *pb.UpdateMetricsRequest, realIP string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.cfg.ReportInterval)
+	defer cancel()
+	if realIP != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-real-ip", realIP)
+	}
+	_, err := collector.grpcClient.UpdateMetrics(ctx, req)
+	return err
+}
+
+func (collector *CollectorAgent) reportHTTP(metrics []datastorage.Metrics) error {
 	log.Println("Post batch stats to " + collector.cfg.Server)
 	log.Println(metrics)
 	url := "http://" + path.Join(collector.cfg.Server, "updates")
 
-	for i := range metrics {
-		metrics[i].Hash, _ = metrics[i].CalcHash(collector.cfg.Key)
-	}
-
 	body, err := json.Marshal(metrics)
 	if err != nil {
 		log.Println("Error while marshal " + err.Error())
-		return
+		return err
 	}
 	resp, err := collector.PostWithRetrues(url, "application/json", body)
 	if err != nil {
 		log.Println("Post error" + err.Error())
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		fmt.Printf(url, " status code ", resp.StatusCode)
+		if !retryableStatus(resp.StatusCode) {
+			return fmt.Errorf("%w: %s status code %d", errRejected, url, resp.StatusCode)
+		}
+		return fmt.Errorf("%s status code %d", url, resp.StatusCode)
 	}
 	log.Println("Post batch stats: succesed")
+	return nil
 }
 
 func (collector *CollectorAgent) Run(end context.Context) error {
 	log.Println("Collector run started")
 
+	for _, src := range collector.sources {
+		if src.interval != 0 {
+			go collector.runSource(end, src)
+		}
+	}
+
 	collectTimer := time.NewTicker(collector.cfg.PollInterval)
 	reportTimer := time.NewTicker(collector.cfg.ReportInterval)
 
@@ -349,6 +445,9 @@ func (collector *CollectorAgent) Run(end context.Context) error {
 			go collector.Report(t)
 		case <-end.Done():
 			log.Println("Collector stoped")
+			if collector.grpcConn != nil {
+				collector.grpcConn.Close()
+			}
 			return nil
 		}
 	}
@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+// Collector is a source of metrics. Collect returns the current values, the agent keeps the last ones
+// and sends them with every report, so a collector returns gauges only: the counters it returns
+// are dropped, their values would be added again on every report.
+type Collector interface {
+	Collect() ([]datastorage.Metrics, error)
+}
+
+// CollectorFactory makes a collector for an agent, every agent gets its own one.
+type CollectorFactory func() Collector
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]CollectorFactory{}
+)
+
+// Register makes the collector available to the agents under the name, it panics on a repeated name.
+// Registered collectors are enabled unless the Collectors config disables them.
+func Register(name string, factory CollectorFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic("agent: Register collector is nil")
+	}
+	if _, ok := registry[name]; ok {
+		panic("agent: Register called twice for collector " + name)
+	}
+	registry[name] = factory
+}
+
+// Collectors returns the registered collector names sorted.
+func Collectors() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CollectorConfig overrides the defaults of a registered collector.
+type CollectorConfig struct {
+	Name     string
+	Interval time.Duration
+	Disabled bool
+}
+
+// ParseCollectors parses a comma separated list like "memory:5s,-random": "-name" disables the collector,
+// "name:interval" polls it with its own interval. Collectors not in the list poll every PollInterval.
+func ParseCollectors(value string) ([]CollectorConfig, error) {
+	configs := []CollectorConfig{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		cfg := CollectorConfig{}
+		if strings.HasPrefix(item, "-") {
+			cfg.Disabled = true
+			item = item[1:]
+		}
+		parts := strings.SplitN(item, ":", 2)
+		cfg.Name = parts[0]
+		if len(parts) == 2 {
+			duration, err := time.ParseDuration(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("collector %s: %w", cfg.Name, err)
+			}
+			if duration <= 0 {
+				return nil, fmt.Errorf("collector %s: interval must be positive", cfg.Name)
+			}
+			cfg.Interval = duration
+		}
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+// source is an enabled collector with its last metrics.
+type source struct {
+	name      string
+	collector Collector
+	// interval is zero for the collectors polled every PollInterval.
+	interval time.Duration
+
+	poll    sync.Mutex
+	metrics []datastorage.Metrics
+}
+
+// newSources makes the enabled collectors in name order.
+func newSources(value string) ([]*source, error) {
+	configs, err := ParseCollectors(value)
+	if err != nil {
+		return nil, err
+	}
+
+	names := Collectors()
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	overrides := map[string]CollectorConfig{}
+	for _, cfg := range configs {
+		if _, ok := registry[cfg.Name]; !ok {
+			return nil, fmt.Errorf("collector %s is not registered", cfg.Name)
+		}
+		overrides[cfg.Name] = cfg
+	}
+
+	sources := []*source{}
+	for _, name := range names {
+		cfg := overrides[name]
+		if cfg.Disabled {
+			continue
+		}
+		sources = append(sources, &source{name: name, collector: registry[name](), interval: cfg.Interval})
+	}
+	return sources, nil
+}
+
+// pollSource keeps the gauges of the collector, the previous ones stay when it fails.
+func (collector *CollectorAgent) pollSource(src *source) {
+	src.poll.Lock()
+	defer src.poll.Unlock()
+
+	collected, err := src.collector.Collect()
+	if err != nil {
+		log.Println("Collector " + src.name + " error: " + err.Error())
+		return
+	}
+	metrics := make([]datastorage.Metrics, 0, len(collected))
+	for _, metric := range collected {
+		if metric.MType != datastorage.GaugeTypeName {
+			log.Println("Collector " + src.name + " error: " + metric.ID + " is a " + metric.MType + ", only gauges are collected")
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	src.metrics = metrics
+}
+
+// runSource polls a collector with its own interval until the end.
+func (collector *CollectorAgent) runSource(end context.Context, src *source) {
+	collector.pollSource(src)
+	ticker := time.NewTicker(src.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			collector.pollSource(src)
+		case <-end.Done():
+			return
+		}
+	}
+}
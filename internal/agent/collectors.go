@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"math/rand"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+const (
+	CollectorRuntime = "runtime"
+	CollectorMemory  = "memory"
+	CollectorCPU     = "cpu"
+	CollectorRandom  = "random"
+)
+
+func init() {
+	Register(CollectorRuntime, func() Collector { return new(runtimeCollector) })
+	Register(CollectorMemory, func() Collector { return memoryCollector{} })
+	Register(CollectorCPU, func() Collector { return cpuCollector{} })
+	Register(CollectorRandom, func() Collector { return randomCollector{} })
+}
+
+func gauge(id string, value float64) datastorage.Metrics {
+	return datastorage.Metrics{ID: id, MType: gaugeTypeName, Value: value}
+}
+
+// runtimeCollector reports the runtime MemStats of the agent.
+type runtimeCollector struct {
+	stats runtime.MemStats
+}
+
+func (c *runtimeCollector) Collect() ([]datastorage.Metrics, error) {
+	runtime.ReadMemStats(&c.stats)
+	return []datastorage.Metrics{
+		gauge("Alloc", float64(c.stats.Alloc)),
+		gauge("TotalAlloc", float64(c.stats.TotalAlloc)),
+		gauge("Frees", float64(c.stats.Frees)),
+		gauge("BuckHashSys", float64(c.stats.BuckHashSys)),
+		gauge("GCCPUFraction", c.stats.GCCPUFraction),
+		gauge("GCSys", float64(c.stats.GCSys)),
+		gauge("HeapAlloc", float64(c.stats.HeapAlloc)),
+		gauge("HeapIdle", float64(c.stats.HeapIdle)),
+		gauge("HeapInuse", float64(c.stats.HeapInuse)),
+		gauge("HeapObjects", float64(c.stats.HeapObjects)),
+		gauge("HeapReleased", float64(c.stats.HeapReleased)),
+		gauge("HeapSys", float64(c.stats.HeapSys)),
+		gauge("LastGC", float64(c.stats.LastGC)),
+		gauge("Lookups", float64(c.stats.Lookups)),
+		gauge("MCacheInuse", float64(c.stats.MCacheInuse)),
+		gauge("MCacheSys", float64(c.stats.MCacheSys)),
+		gauge("MSpanInuse", float64(c.stats.MSpanInuse)),
+		gauge("MSpanSys", float64(c.stats.MSpanSys)),
+		gauge("Mallocs", float64(c.stats.Mallocs)),
+		gauge("NextGC", float64(c.stats.NextGC)),
+		gauge("NumForcedGC", float64(c.stats.NumForcedGC)),
+		gauge("NumGC", float64(c.stats.NumGC)),
+		gauge("OtherSys", float64(c.stats.OtherSys)),
+		gauge("PauseTotalNs", float64(c.stats.PauseTotalNs)),
+		gauge("StackInuse", float64(c.stats.StackInuse)),
+		gauge("StackSys", float64(c.stats.StackSys)),
+		gauge("Sys", float64(c.stats.Sys)),
+	}, nil
+}
+
+// memoryCollector reports the memory of the host.
+type memoryCollector struct{}
+
+func (memoryCollector) Collect() ([]datastorage.Metrics, error) {
+	v, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, err
+	}
+	return []datastorage.Metrics{
+		gauge("FreeMemory", float64(v.Free)),
+		gauge("TotalMemory", float64(v.Total)),
+	}, nil
+}
+
+// cpuCollector reports the utilization of every core labeled by the core number, starting from 1.
+type cpuCollector struct{}
+
+func (cpuCollector) Collect() ([]datastorage.Metrics, error) {
+	c, err := cpu.Percent(time.Millisecond, true)
+	metrics := make([]datastorage.Metrics, 0, runtime.NumCPU())
+	for i := 1; i <= runtime.NumCPU(); i++ {
+		utilization := 0.0
+		if err == nil && i <= len(c) {
+			utilization = c[i-1]
+		}
+		core := strconv.Itoa(i)
+		metrics = append(metrics, datastorage.Metrics{
+			ID:     "cpu_utilization",
+			MType:  gaugeTypeName,
+			Value:  utilization,
+			Labels: map[string]string{"core": core},
+		})
+	}
+	return metrics, nil
+}
+
+type randomCollector struct{}
+
+func (randomCollector) Collect() ([]datastorage.Metrics, error) {
+	return []datastorage.Metrics{gauge("RandomValue", rand.Float64())}, nil
+}
@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"sync/atomic"
+
+	"github.com/nikolaevs92/Practicum/internal/compression"
+)
+
+// bodyEncoding picks the encoding of a body: bodies under CompressMinSize are sent as is,
+// zstd is used only while the server lists it in Accept-Encoding, gzip otherwise.
+func (collector *CollectorAgent) bodyEncoding(size int) string {
+	switch collector.cfg.Compression {
+	case "", "none", compression.Identity:
+		return compression.Identity
+	}
+	if size < collector.cfg.CompressMinSize {
+		return compression.Identity
+	}
+	if collector.cfg.Compression == compression.Zstd && atomic.LoadInt32(&collector.zstdAccepted) == 1 {
+		return compression.Zstd
+	}
+	return compression.Gzip
+}
+
+// learnEncodings remembers whether the server takes zstd from the Accept-Encoding of its response.
+func (collector *CollectorAgent) learnEncodings(accepted string) {
+	var zstdAccepted int32
+	if compression.Accepts(accepted, compression.Zstd) {
+		zstdAccepted = 1
+	}
+	atomic.StoreInt32(&collector.zstdAccepted, zstdAccepted)
+}
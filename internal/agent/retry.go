@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// retryableStatus tells the responses worth sending the request again: the server is down or overloaded.
+func retryableStatus(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+}
+
+func retryableCode(code codes.Code) bool {
+	return code == codes.Unavailable || code == codes.DeadlineExceeded
+}
+
+// retryAfter parses the Retry-After header, in seconds or as a date, zero means there is no hint.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// backoff returns the pause before the retry after the attempt, the last step of the schedule repeats.
+// The pause is spread by the jitter share in both directions so the agents don't come back together.
+func (collector *CollectorAgent) backoff(attempt int) time.Duration {
+	schedule := collector.cfg.RetryBackoff
+	if len(schedule) == 0 {
+		return 0
+	}
+	if attempt >= len(schedule) {
+		attempt = len(schedule) - 1
+	}
+	delay := schedule[attempt]
+	if jitter := collector.cfg.RetryJitter; jitter > 0 {
+		delay += time.Duration(float64(delay) * jitter * (2*rand.Float64() - 1))
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// retryDelay returns the pause before the next attempt and whether to make one.
+// A Retry-After longer than the report interval stops the retries, the next report tries again.
+func (collector *CollectorAgent) retryDelay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if attempt >= collector.cfg.ReportRetries {
+		return 0, false
+	}
+	if after := retryAfter(resp, time.Now()); after > 0 {
+		if collector.cfg.ReportInterval > 0 && after > collector.cfg.ReportInterval {
+			log.Println("Retry-After " + after.String() + " is longer than the report interval, stop retries")
+			return 0, false
+		}
+		return after, true
+	}
+	return collector.backoff(attempt), true
+}
+
+func discardBody(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// breaker pauses the reports after threshold failed ones in a row for the cooldown,
+// then lets one report through: a success closes it, a failure opens it again.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether the report may be sent now.
+func (b *breaker) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.threshold <= 0 || !now.Before(b.openUntil)
+}
+
+// Done counts the result of the report sent after Allow.
+func (b *breaker) Done(now time.Time, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		log.Println("Reports are paused for " + b.cooldown.String() + " after " + strconv.Itoa(b.failures) + " failures")
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+const DefaultSpoolMaxSize = 10 << 20
+
+// errRejected marks a report the server answered and refused, sending it again won't help.
+var errRejected = errors.New("report is rejected")
+
+// spool keeps the batches the server didn't take in a file of json lines, the oldest first.
+// When the file grows over maxSize the batches are merged into one: the last value of every
+// gauge, the sum of the counter deltas and the merged histograms, so the spool is bounded by
+// the series count even when one batch alone is over the limit.
+type spool struct {
+	path    string
+	maxSize int64
+	key     string
+	mu      sync.Mutex
+}
+
+func newSpool(path string, maxSize int64, key string) *spool {
+	if maxSize <= 0 {
+		maxSize = DefaultSpoolMaxSize
+	}
+	return &spool{path: path, maxSize: maxSize, key: key}
+}
+
+// load reads the queued batches, reading stops at a broken line like the one a crash in
+// the middle of save leaves behind.
+func (s *spool) load() ([][]datastorage.Metrics, error) {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	batches := [][]datastorage.Metrics{}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			batch := []datastorage.Metrics{}
+			if jsonErr := json.Unmarshal(line, &batch); jsonErr != nil {
+				log.Println("Spool: stop on broken batch: " + jsonErr.Error())
+				return batches, nil
+			}
+			batches = append(batches, batch)
+		}
+		if err == io.EOF {
+			return batches, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func encodeBatches(batches [][]datastorage.Metrics) ([]byte, error) {
+	var payload bytes.Buffer
+	for _, batch := range batches {
+		line, err := json.Marshal(batch)
+		if err != nil {
+			return nil, err
+		}
+		payload.Write(line)
+		payload.WriteByte('\n')
+	}
+	return payload.Bytes(), nil
+}
+
+// save replaces the spool with the batches through a synced temp file, no batches remove it.
+func (s *spool) save(batches [][]datastorage.Metrics) error {
+	if len(batches) == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	payload, err := encodeBatches(batches)
+	if err != nil {
+		return err
+	}
+	if int64(len(payload)) > s.maxSize && len(batches) > 1 {
+		log.Println("Spool: " + strconv.Itoa(len(batches)) + " batches are over the size limit, merge them")
+		batches = [][]datastorage.Metrics{mergeBatches(batches, s.key)}
+		if payload, err = encodeBatches(batches); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(payload); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Push queues the batch after the others.
+func (s *spool) Push(batch []datastorage.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batches, err := s.load()
+	if err != nil {
+		return err
+	}
+	return s.save(append(batches, batch))
+}
+
+// Replay sends the queued batches in order and stops on the first one the server can't take now,
+// a rejected batch is dropped. The sent batches leave the spool.
+func (s *spool) Replay(send func([]datastorage.Metrics) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batches, err := s.load()
+	if err != nil || len(batches) == 0 {
+		return err
+	}
+	log.Println("Spool: replay " + strconv.Itoa(len(batches)) + " batches")
+	for i, batch := range batches {
+		if err := send(batch); err != nil {
+			if errors.Is(err, errRejected) {
+				log.Println("Spool: drop rejected batch: " + err.Error())
+				continue
+			}
+			if saveErr := s.save(batches[i:]); saveErr != nil {
+				log.Println("Spool: save error: " + saveErr.Error())
+			}
+			return err
+		}
+	}
+	return s.save(nil)
+}
+
+// mergeBatches folds the batches into one with a metric per series, the hashes are calculated again.
+func mergeBatches(batches [][]datastorage.Metrics, key string) []datastorage.Metrics {
+	merged := []datastorage.Metrics{}
+	index := map[string]int{}
+	for _, batch := range batches {
+		for _, metrics := range batch {
+			seriesKey := metrics.MType + ":" + metrics.SeriesKey()
+			i, ok := index[seriesKey]
+			if !ok {
+				index[seriesKey] = len(merged)
+				if metrics.Histogram != nil {
+					histogram := metrics.Histogram.Copy()
+					metrics.Histogram = &histogram
+				}
+				merged = append(merged, metrics)
+				continue
+			}
+			switch metrics.MType {
+			case counterTypeName:
+				if metrics.Delta > 0 && merged[i].Delta > math.MaxInt64-metrics.Delta {
+					log.Println("Spool: counter " + metrics.ID + " is saturated")
+					merged[i].Delta = math.MaxInt64
+				} else {
+					merged[i].Delta += metrics.Delta
+				}
+			case histogramTypeName:
+				if metrics.Histogram == nil || merged[i].Histogram == nil {
+					continue
+				}
+				if err := merged[i].Histogram.Merge(*metrics.Histogram); err != nil {
+					log.Println("Spool: histogram " + metrics.ID + ": " + err.Error())
+				}
+			default:
+				merged[i].Value = metrics.Value
+			}
+		}
+	}
+	for i := range merged {
+		merged[i].Hash, _ = merged[i].CalcHash(key)
+	}
+	return merged
+}
@@ -0,0 +1,117 @@
+// Package compression implements the request body encodings shared by agent and server.
+//
+// The agent names the encoding in Content-Encoding, the server lists the encodings it takes
+// in the Accept-Encoding header of its responses, so zstd is sent only to a server that asked for it.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Identity = "identity"
+	Gzip     = "gzip"
+	Zstd     = "zstd"
+
+	// DefaultMaxSize bounds a decompressed body.
+	DefaultMaxSize = 10 << 20
+	// zstdMaxWindow bounds the memory a zstd frame header can ask for.
+	zstdMaxWindow = 8 << 20
+)
+
+var (
+	ErrUnsupported = errors.New("compression: unsupported encoding")
+	ErrTooLarge    = errors.New("compression: decompressed body is too large")
+)
+
+// Compress encodes the data, Identity and an empty encoding return it as is.
+func Compress(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	switch encoding {
+	case "", Identity:
+		return data, nil
+	case Gzip:
+		gz, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := gz.Write(data); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	case Zstd:
+		zw, err := zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decodes the body and stops with ErrTooLarge once it grows over maxSize bytes,
+// so a small bomb can't take the memory.
+func Decompress(encoding string, body io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	var reader io.Reader
+	switch encoding {
+	case "", Identity:
+		reader = body
+	case Gzip:
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	case Zstd:
+		zr, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return nil, ErrUnsupported
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, ErrTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// Accepts reports whether the Accept-Encoding value lists the encoding.
+func Accepts(header string, encoding string) bool {
+	for _, item := range strings.Split(header, ",") {
+		name := strings.TrimSpace(strings.SplitN(item, ";", 2)[0])
+		if strings.EqualFold(name, encoding) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package compression
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressDecompress(t *testing.T) {
+	plaintext := bytes.Repeat([]byte(`{"id":"PollCount","type":"counter","delta":1}`), 1000)
+	for _, encoding := range []string{"", Identity, Gzip, Zstd} {
+		t.Run("encoding_"+encoding, func(t *testing.T) {
+			compressed, err := Compress(encoding, plaintext)
+			require.NoError(t, err)
+			if encoding == Gzip || encoding == Zstd {
+				assert.Less(t, len(compressed), len(plaintext))
+			}
+
+			decompressed, err := Decompress(encoding, bytes.NewReader(compressed), 0)
+			require.NoError(t, err)
+			assert.Equal(t, plaintext, decompressed)
+		})
+	}
+}
+
+func TestDecompressBomb(t *testing.T) {
+	plaintext := make([]byte, 1<<20)
+	for _, encoding := range []string{Identity, Gzip, Zstd} {
+		t.Run(encoding, func(t *testing.T) {
+			compressed, err := Compress(encoding, plaintext)
+			require.NoError(t, err)
+
+			_, err = Decompress(encoding, bytes.NewReader(compressed), 1024)
+			assert.ErrorIs(t, err, ErrTooLarge)
+
+			decompressed, err := Decompress(encoding, bytes.NewReader(compressed), int64(len(plaintext)))
+			require.NoError(t, err)
+			assert.Len(t, decompressed, len(plaintext))
+		})
+	}
+}
+
+func TestDecompressErrors(t *testing.T) {
+	_, err := Decompress("br", bytes.NewReader([]byte("body")), 0)
+	assert.ErrorIs(t, err, ErrUnsupported)
+	_, err = Compress("br", []byte("body"))
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = Decompress(Gzip, bytes.NewReader([]byte("not gzip")), 0)
+	assert.Error(t, err)
+	_, err = Decompress(Zstd, bytes.NewReader([]byte("not zstd")), 0)
+	assert.Error(t, err)
+}
+
+func TestAccepts(t *testing.T) {
+	assert.True(t, Accepts("gzip, zstd", Zstd))
+	assert.True(t, Accepts("GZIP;q=0.5", Gzip))
+	assert.False(t, Accepts("gzip", Zstd))
+	assert.False(t, Accepts("", Gzip))
+}
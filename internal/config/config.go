@@ -6,33 +6,79 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/nikolaevs92/Practicum/internal/agent"
+	"github.com/nikolaevs92/Practicum/internal/compression"
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
 	"github.com/nikolaevs92/Practicum/internal/server"
 )
 
 const (
-	DefaultPollInterval   = time.Second * 2
-	DefaultReportRetries  = 2
-	DefaultReportInterval = time.Second * 10
-	DefaultStoreInterval  = time.Second * 300
-	DefaultStoreFile      = "/tmp/devops-metrics-db.json"
-	DefaultRestore        = true
-	DefaultServer         = "127.0.0.1:8080"
-	DefaultKey            = ""
-	DefaultDataBaseDSN    = ""
-	DefaultDataBaseType   = "postgres"
+	DefaultPollInterval     = time.Second * 2
+	DefaultReportRetries    = 3
+	DefaultReportInterval   = time.Second * 10
+	DefaultStoreInterval    = time.Second * 300
+	DefaultStoreFile        = "/tmp/devops-metrics-db.json"
+	DefaultRestore          = true
+	DefaultServer           = "127.0.0.1:8080"
+	DefaultKey              = ""
+	DefaultDataBaseDSN      = ""
+	DefaultDataBaseType     = "postgres"
+	DefaultHistoryLimit     = datastorage.DefaultHistoryLimit
+	DefaultGRPCServer       = ""
+	DefaultTransport        = agent.TransportHTTP
+	DefaultCryptoKey        = ""
+	DefaultTrustedSubnet    = ""
+	DefaultStoreRetention   = datastorage.DefaultStoreRetention
+	DefaultStoreFormat      = datastorage.DefaultStoreFormat
+	DefaultBatchMode        = datastorage.DefaultBatchMode
+	DefaultMaxBatchSize     = datastorage.DefaultMaxBatchSize
+	DefaultStorageEngine    = datastorage.DefaultEngine
+	DefaultRedisURL         = ""
+	DefaultSpoolFile        = "/tmp/devops-metrics-agent-spool.jsonl"
+	DefaultSpoolMaxSize     = agent.DefaultSpoolMaxSize
+	DefaultRetryBackoff     = "1s,3s,5s"
+	DefaultRetryJitter      = 0.2
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = time.Second * 30
+	DefaultMaxBodySize      = compression.DefaultMaxSize
+	DefaultZstd             = false
+	DefaultCompression      = compression.Gzip
+	DefaultCompressMinSize  = 1024
+	DefaultCollectors       = ""
 )
 
 const (
-	envPollInterval   = "POLL_INTERVAL"
-	envReportInterval = "REPORT_INTERVAL"
-	envStoreInterval  = "STORE_INTERVAL"
-	envStoreFile      = "STORE_FILE"
-	envRestore        = "RESTORE"
-	envReportRetries  = "REPORT_RETRIES"
-	envServer         = "ADDRESS"
-	envKey            = "KEY"
-	envDataBaseDSN    = "DATABASE_DSN"
-	envDataBaseType   = "DATABASE_TYPE"
+	envPollInterval     = "POLL_INTERVAL"
+	envReportInterval   = "REPORT_INTERVAL"
+	envStoreInterval    = "STORE_INTERVAL"
+	envStoreFile        = "STORE_FILE"
+	envRestore          = "RESTORE"
+	envReportRetries    = "REPORT_RETRIES"
+	envServer           = "ADDRESS"
+	envKey              = "KEY"
+	envDataBaseDSN      = "DATABASE_DSN"
+	envDataBaseType     = "DATABASE_TYPE"
+	envHistoryLimit     = "HISTORY_LIMIT"
+	envGRPCServer       = "GRPC_ADDRESS"
+	envTransport        = "TRANSPORT"
+	envCryptoKey        = "CRYPTO_KEY"
+	envTrustedSubnet    = "TRUSTED_SUBNET"
+	envStoreRetention   = "STORE_RETENTION"
+	envStoreFormat      = "STORE_FORMAT"
+	envBatchMode        = "BATCH_MODE"
+	envMaxBatchSize     = "MAX_BATCH_SIZE"
+	envStorageEngine    = "STORAGE_ENGINE"
+	envRedisURL         = "REDIS_URL"
+	envSpoolFile        = "SPOOL_FILE"
+	envSpoolMaxSize     = "SPOOL_MAX_SIZE"
+	envRetryBackoff     = "RETRY_BACKOFF"
+	envRetryJitter      = "RETRY_JITTER"
+	envBreakerThreshold = "BREAKER_THRESHOLD"
+	envBreakerCooldown  = "BREAKER_COOLDOWN"
+	envMaxBodySize      = "MAX_BODY_SIZE"
+	envZstd             = "ZSTD"
+	envCompression      = "COMPRESSION"
+	envCompressMinSize  = "COMPRESS_MIN_SIZE"
+	envCollectors       = "COLLECTORS"
 )
 
 type Config struct {
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"log"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -8,34 +10,101 @@ import (
 	"github.com/nikolaevs92/Practicum/internal/agent"
 )
 
+// parseDurations reads a comma separated list like "1s,3s,5s", a broken list falls back to the default one.
+func parseDurations(value string, defaultValue string) []time.Duration {
+	durations := []time.Duration{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(item)
+		if err != nil {
+			log.Println("Bad duration " + item + ", use " + defaultValue)
+			return parseDurations(defaultValue, defaultValue)
+		}
+		durations = append(durations, duration)
+	}
+	return durations
+}
+
 func NewAgentConfig(v *viper.Viper) *agent.Config {
 	v.SetDefault(envPollInterval, DefaultPollInterval)
 	v.SetDefault(envReportInterval, DefaultReportInterval)
 	v.SetDefault(envReportRetries, DefaultReportRetries)
 	v.SetDefault(envServer, DefaultServer)
 	v.SetDefault(envKey, DefaultKey)
+	v.SetDefault(envTransport, DefaultTransport)
+	v.SetDefault(envGRPCServer, DefaultGRPCServer)
+	v.SetDefault(envCryptoKey, DefaultCryptoKey)
+	v.SetDefault(envSpoolFile, DefaultSpoolFile)
+	v.SetDefault(envSpoolMaxSize, DefaultSpoolMaxSize)
+	v.SetDefault(envRetryBackoff, DefaultRetryBackoff)
+	v.SetDefault(envRetryJitter, DefaultRetryJitter)
+	v.SetDefault(envBreakerThreshold, DefaultBreakerThreshold)
+	v.SetDefault(envBreakerCooldown, DefaultBreakerCooldown)
+	v.SetDefault(envCompression, DefaultCompression)
+	v.SetDefault(envCompressMinSize, DefaultCompressMinSize)
+	v.SetDefault(envCollectors, DefaultCollectors)
 
 	return &agent.Config{
-		PollInterval:   v.GetDuration(envPollInterval),
-		ReportInterval: v.GetDuration(envReportInterval),
-		ReportRetries:  v.GetInt(envReportRetries),
-		Server:         v.GetString(envServer),
-		Key:            v.GetString(envKey),
+		PollInterval:     v.GetDuration(envPollInterval),
+		ReportInterval:   v.GetDuration(envReportInterval),
+		ReportRetries:    v.GetInt(envReportRetries),
+		Server:           v.GetString(envServer),
+		Key:              v.GetString(envKey),
+		Transport:        v.GetString(envTransport),
+		GRPCServer:       v.GetString(envGRPCServer),
+		CryptoKey:        v.GetString(envCryptoKey),
+		SpoolFile:        v.GetString(envSpoolFile),
+		SpoolMaxSize:     v.GetInt64(envSpoolMaxSize),
+		RetryBackoff:     parseDurations(v.GetString(envRetryBackoff), DefaultRetryBackoff),
+		RetryJitter:      v.GetFloat64(envRetryJitter),
+		BreakerThreshold: v.GetInt(envBreakerThreshold),
+		BreakerCooldown:  v.GetDuration(envBreakerCooldown),
+		Compression:      v.GetString(envCompression),
+		CompressMinSize:  v.GetInt(envCompressMinSize),
+		Collectors:       v.GetString(envCollectors),
 	}
 }
 
-func NewAgentConfigWithDefaults(v *viper.Viper, server string, pollInterval time.Duration, reportInterval time.Duration, key string) *agent.Config {
+func NewAgentConfigWithDefaults(
+	v *viper.Viper, server string, pollInterval time.Duration, reportInterval time.Duration, key string, transport string, grpcServer string, cryptoKey string,
+	spoolFile string) *agent.Config {
 	v.SetDefault(envPollInterval, pollInterval)
-	v.SetDefault(envReportInterval, pollInterval)
+	v.SetDefault(envReportInterval, reportInterval)
 	v.SetDefault(envReportRetries, DefaultReportRetries)
 	v.SetDefault(envServer, server)
 	v.SetDefault(envKey, key)
+	v.SetDefault(envTransport, transport)
+	v.SetDefault(envGRPCServer, grpcServer)
+	v.SetDefault(envCryptoKey, cryptoKey)
+	v.SetDefault(envSpoolFile, spoolFile)
+	v.SetDefault(envSpoolMaxSize, DefaultSpoolMaxSize)
+	v.SetDefault(envRetryBackoff, DefaultRetryBackoff)
+	v.SetDefault(envRetryJitter, DefaultRetryJitter)
+	v.SetDefault(envBreakerThreshold, DefaultBreakerThreshold)
+	v.SetDefault(envBreakerCooldown, DefaultBreakerCooldown)
+	v.SetDefault(envCompression, DefaultCompression)
+	v.SetDefault(envCompressMinSize, DefaultCompressMinSize)
+	v.SetDefault(envCollectors, DefaultCollectors)
 
 	return &agent.Config{
-		PollInterval:   v.GetDuration(envPollInterval),
-		ReportInterval: v.GetDuration(envReportInterval),
-		ReportRetries:  v.GetInt(envReportRetries),
-		Server:         v.GetString(envServer),
-		Key:            v.GetString(envKey),
+		PollInterval:     v.GetDuration(envPollInterval),
+		ReportInterval:   v.GetDuration(envReportInterval),
+		ReportRetries:    v.GetInt(envReportRetries),
+		Server:           v.GetString(envServer),
+		Key:              v.GetString(envKey),
+		Transport:        v.GetString(envTransport),
+		GRPCServer:       v.GetString(envGRPCServer),
+		CryptoKey:        v.GetString(envCryptoKey),
+		SpoolFile:        v.GetString(envSpoolFile),
+		SpoolMaxSize:     v.GetInt64(envSpoolMaxSize),
+		RetryBackoff:     parseDurations(v.GetString(envRetryBackoff), DefaultRetryBackoff),
+		RetryJitter:      v.GetFloat64(envRetryJitter),
+		BreakerThreshold: v.GetInt(envBreakerThreshold),
+		BreakerCooldown:  v.GetDuration(envBreakerCooldown),
+		Compression:      v.GetString(envCompression),
+		CompressMinSize:  v.GetInt(envCompressMinSize),
+		Collectors:       v.GetString(envCollectors),
 	}
 }
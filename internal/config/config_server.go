@@ -17,24 +17,49 @@ func NewServerConfig(v *viper.Viper) *server.Config {
 	v.SetDefault(envKey, DefaultKey)
 	v.SetDefault(envDataBaseDSN, DefaultDataBaseDSN)
 	v.SetDefault(envDataBaseType, DefaultDataBaseType)
+	v.SetDefault(envHistoryLimit, DefaultHistoryLimit)
+	v.SetDefault(envStoreRetention, DefaultStoreRetention)
+	v.SetDefault(envStoreFormat, DefaultStoreFormat)
+	v.SetDefault(envBatchMode, DefaultBatchMode)
+	v.SetDefault(envMaxBatchSize, DefaultMaxBatchSize)
+	v.SetDefault(envStorageEngine, DefaultStorageEngine)
+	v.SetDefault(envRedisURL, DefaultRedisURL)
+	v.SetDefault(envGRPCServer, DefaultGRPCServer)
+	v.SetDefault(envCryptoKey, DefaultCryptoKey)
+	v.SetDefault(envTrustedSubnet, DefaultTrustedSubnet)
+	v.SetDefault(envMaxBodySize, DefaultMaxBodySize)
+	v.SetDefault(envZstd, DefaultZstd)
 
 	return &server.Config{
-		Server: v.GetString(envServer),
+		Server:        v.GetString(envServer),
+		GRPCServer:    v.GetString(envGRPCServer),
+		CryptoKey:     v.GetString(envCryptoKey),
+		TrustedSubnet: v.GetString(envTrustedSubnet),
+		MaxBodySize:   v.GetInt64(envMaxBodySize),
+		Zstd:          v.GetBool(envZstd),
 		StorageConfig: datastorage.StorageConfig{
-			StoreInterval: v.GetDuration(envStoreInterval),
-			StoreFile:     v.GetString(envStoreFile),
-			Restore:       v.GetBool(envRestore),
-			Store:         v.GetString(envStoreFile) != "",
-			Synchronized:  v.GetDuration(envStoreInterval) == time.Duration(0),
-			Key:           v.GetString(envKey),
-			DataBaseDSN:   v.GetString(envDataBaseDSN),
-			DBType:        v.GetString(envDataBaseType),
+			StoreInterval:  v.GetDuration(envStoreInterval),
+			StoreFile:      v.GetString(envStoreFile),
+			Restore:        v.GetBool(envRestore),
+			Store:          v.GetString(envStoreFile) != "",
+			Synchronized:   v.GetDuration(envStoreInterval) == time.Duration(0),
+			Key:            v.GetString(envKey),
+			DataBaseDSN:    v.GetString(envDataBaseDSN),
+			DBType:         v.GetString(envDataBaseType),
+			RedisURL:       v.GetString(envRedisURL),
+			HistoryLimit:   v.GetInt(envHistoryLimit),
+			StoreRetention: v.GetInt(envStoreRetention),
+			StoreFormat:    v.GetString(envStoreFormat),
+			BatchMode:      v.GetString(envBatchMode),
+			MaxBatchSize:   v.GetInt(envMaxBatchSize),
+			Engine:         v.GetString(envStorageEngine),
 		},
 	}
 }
 
 func NewServerConfigWithDefaults(
-	v *viper.Viper, adress string, stroreInterval time.Duration, storeFile string, restore bool, key string, dataBaseDSN string, dataBaseType string) *server.Config {
+	v *viper.Viper, adress string, stroreInterval time.Duration, storeFile string, restore bool, key string, dataBaseDSN string, dataBaseType string,
+	grpcAdress string, cryptoKey string, trustedSubnet string) *server.Config {
 
 	v.SetDefault(envServer, adress)
 	v.SetDefault(envStoreInterval, stroreInterval)
@@ -43,18 +68,42 @@ func NewServerConfigWithDefaults(
 	v.SetDefault(envKey, key)
 	v.SetDefault(envDataBaseDSN, dataBaseDSN)
 	v.SetDefault(envDataBaseType, dataBaseType)
+	v.SetDefault(envHistoryLimit, DefaultHistoryLimit)
+	v.SetDefault(envStoreRetention, DefaultStoreRetention)
+	v.SetDefault(envStoreFormat, DefaultStoreFormat)
+	v.SetDefault(envBatchMode, DefaultBatchMode)
+	v.SetDefault(envMaxBatchSize, DefaultMaxBatchSize)
+	v.SetDefault(envStorageEngine, DefaultStorageEngine)
+	v.SetDefault(envRedisURL, DefaultRedisURL)
+	v.SetDefault(envGRPCServer, grpcAdress)
+	v.SetDefault(envCryptoKey, cryptoKey)
+	v.SetDefault(envTrustedSubnet, trustedSubnet)
+	v.SetDefault(envMaxBodySize, DefaultMaxBodySize)
+	v.SetDefault(envZstd, DefaultZstd)
 
 	return &server.Config{
-		Server: v.GetString(envServer),
+		Server:        v.GetString(envServer),
+		GRPCServer:    v.GetString(envGRPCServer),
+		CryptoKey:     v.GetString(envCryptoKey),
+		TrustedSubnet: v.GetString(envTrustedSubnet),
+		MaxBodySize:   v.GetInt64(envMaxBodySize),
+		Zstd:          v.GetBool(envZstd),
 		StorageConfig: datastorage.StorageConfig{
-			StoreInterval: v.GetDuration(envStoreInterval),
-			StoreFile:     v.GetString(envStoreFile),
-			Restore:       v.GetBool(envRestore),
-			Store:         v.GetString(envStoreFile) != "",
-			Synchronized:  v.GetDuration(envStoreInterval) == time.Duration(0),
-			Key:           v.GetString(envKey),
-			DataBaseDSN:   v.GetString(envDataBaseDSN),
-			DBType:        v.GetString(envDataBaseType),
+			StoreInterval:  v.GetDuration(envStoreInterval),
+			StoreFile:      v.GetString(envStoreFile),
+			Restore:        v.GetBool(envRestore),
+			Store:          v.GetString(envStoreFile) != "",
+			Synchronized:   v.GetDuration(envStoreInterval) == time.Duration(0),
+			Key:            v.GetString(envKey),
+			DataBaseDSN:    v.GetString(envDataBaseDSN),
+			DBType:         v.GetString(envDataBaseType),
+			RedisURL:       v.GetString(envRedisURL),
+			HistoryLimit:   v.GetInt(envHistoryLimit),
+			StoreRetention: v.GetInt(envStoreRetention),
+			StoreFormat:    v.GetString(envStoreFormat),
+			BatchMode:      v.GetString(envBatchMode),
+			MaxBatchSize:   v.GetInt(envMaxBatchSize),
+			Engine:         v.GetString(envStorageEngine),
 		},
 	}
 }
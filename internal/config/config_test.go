@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -15,3 +16,23 @@ func TestCollector(t *testing.T) {
 	assert.Equal(t, cfg.Agent.Server, DefaultServer)
 	assert.Equal(t, cfg.Server.Server, DefaultServer)
 }
+
+func TestAgentRetryConfig(t *testing.T) {
+	cfg := LoadConfig()
+
+	assert.Equal(t, []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}, cfg.Agent.RetryBackoff)
+	assert.Equal(t, DefaultReportRetries, cfg.Agent.ReportRetries)
+	assert.Equal(t, DefaultBreakerThreshold, cfg.Agent.BreakerThreshold)
+	assert.Equal(t, DefaultBreakerCooldown, cfg.Agent.BreakerCooldown)
+
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, time.Second}, parseDurations("100ms, 1s", DefaultRetryBackoff))
+	assert.Equal(t, cfg.Agent.RetryBackoff, parseDurations("1s,soon", DefaultRetryBackoff))
+}
+
+func TestAgentConfigWithDefaults(t *testing.T) {
+	cfg := NewAgentConfigWithDefaults(
+		viper.New(), DefaultServer, time.Second, 7*time.Second, "", DefaultTransport, "", "", "")
+
+	assert.Equal(t, time.Second, cfg.PollInterval)
+	assert.Equal(t, 7*time.Second, cfg.ReportInterval)
+}
@@ -0,0 +1,209 @@
+package datastorage
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+)
+
+// Batch modes: an atomic batch applies every metric or none of them, a best-effort one
+// applies the valid metrics and reports the others.
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best-effort"
+
+	DefaultBatchMode    = BatchAtomic
+	DefaultMaxBatchSize = 10000
+)
+
+// Statuses of a metric in the batch results.
+const (
+	BatchStatusOK      = "ok"
+	BatchStatusFailed  = "failed"
+	BatchStatusSkipped = "skipped"
+)
+
+var ErrBatchTooLarge = errors.New("DataStorage: batch is too large")
+
+// BatchResult is the outcome of one metric of a batch, Stored is the metric after the update.
+type BatchResult struct {
+	ID     string            `json:"id"`
+	MType  string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Status string            `json:"status"`
+	Error  string            `json:"error,omitempty"`
+	Stored *Metrics          `json:"stored,omitempty"`
+}
+
+// BatchError is the error of an atomic batch, Results tell which metric failed it.
+type BatchError struct {
+	Err     error
+	Results []BatchResult
+}
+
+func (err *BatchError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *BatchError) Unwrap() error {
+	return err.Err
+}
+
+// newBatchResults starts every metric as skipped, the update sets the real status.
+func newBatchResults(metricsArray []Metrics) []BatchResult {
+	results := make([]BatchResult, 0, len(metricsArray))
+	for _, metrics := range metricsArray {
+		results = append(results, BatchResult{ID: metrics.ID, MType: metrics.MType, Labels: metrics.Labels, Status: BatchStatusSkipped})
+	}
+	return results
+}
+
+func (result *BatchResult) fail(err error) {
+	result.Status = BatchStatusFailed
+	result.Stored = nil
+	var metricErr *MetricError
+	if errors.As(err, &metricErr) {
+		result.Error = metricErr.Err.Error()
+	} else {
+		result.Error = err.Error()
+	}
+}
+
+func (result *BatchResult) store(metrics Metrics) {
+	result.Status = BatchStatusOK
+	result.Stored = &metrics
+}
+
+// skipApplied marks the applied metrics of a rolled back batch as skipped.
+func skipApplied(results []BatchResult) {
+	for i := range results {
+		if results[i].Status == BatchStatusOK {
+			results[i].Status = BatchStatusSkipped
+			results[i].Stored = nil
+		}
+	}
+}
+
+// batchState is the stored data a batch is staged against.
+type batchState interface {
+	counterValue(name string) int64
+	histogramValue(name string) (Histogram, bool)
+}
+
+// stageBatch returns the records of the metrics which can be applied to state and the
+// results of the batch. The error is a BatchError of the first failed metric of an atomic batch.
+func (cfg StorageConfig) stageBatch(metricsArray []Metrics, atomic bool, state batchState) ([]walRecord, []BatchResult, error) {
+	results := newBatchResults(metricsArray)
+	counters := map[string]int64{}
+	histograms := map[string]Histogram{}
+	records := make([]walRecord, 0, len(metricsArray))
+	for i, metrics := range metricsArray {
+		record, stored, err := cfg.stageMetrics(metrics, state, counters, histograms)
+		if err != nil {
+			log.Println("Metric didnt update: " + metrics.String() + ". Error: " + err.Error())
+			results[i].fail(err)
+			if atomic {
+				skipApplied(results)
+				return nil, results, &BatchError{Err: err, Results: results}
+			}
+			continue
+		}
+		records = append(records, record)
+		results[i].store(stored)
+	}
+	return records, results, nil
+}
+
+// stageMetrics returns the log record of the metric and its value after the update,
+// counters and histograms hold the values staged by the earlier metrics of the batch.
+func (cfg StorageConfig) stageMetrics(metrics Metrics, state batchState, counters map[string]int64, histograms map[string]Histogram) (walRecord, Metrics, error) {
+	if err := cfg.validateMetrics(metrics); err != nil {
+		return walRecord{}, Metrics{}, err
+	}
+	name := metrics.SeriesKey()
+	record := walRecord{MType: metrics.MType, Name: name}
+	stored := Metrics{ID: metrics.ID, MType: metrics.MType, Labels: metrics.Labels}
+	switch metrics.MType {
+	case GaugeTypeName:
+		record.Value = metrics.Value
+		stored.Value = metrics.Value
+	case CounterTypeName:
+		value, ok := counters[name]
+		if !ok {
+			value = state.counterValue(name)
+		}
+		value, err := addCounter(value, metrics.Delta)
+		if err != nil {
+			return walRecord{}, Metrics{}, newMetricError(name, err)
+		}
+		counters[name] = value
+		record.Delta = metrics.Delta
+		stored.Delta = value
+	case HistogramTypeName:
+		var base *Histogram
+		if value, ok := histograms[name]; ok {
+			base = &value
+		} else if value, ok := state.histogramValue(name); ok {
+			base = &value
+		}
+		merged, err := mergeHistogramUpdate(base, metrics.Histogram, 0)
+		if err != nil {
+			return walRecord{}, Metrics{}, newMetricError(name, err)
+		}
+		histograms[name] = merged
+		record.Histogram = &merged
+		copied := merged.Copy()
+		stored.Histogram = &copied
+	}
+	stored.Hash, _ = stored.CalcHash(cfg.Key)
+	return record, stored, nil
+}
+
+// parseBatch reads a batch and checks its size, an empty batch is valid.
+func (cfg StorageConfig) parseBatch(jsonDump []byte) ([]Metrics, error) {
+	metricsArray := []Metrics{}
+	if err := json.Unmarshal(jsonDump, &metricsArray); err != nil {
+		return nil, wrapError(ErrParse, err.Error())
+	}
+	if cfg.MaxBatchSize > 0 && len(metricsArray) > cfg.MaxBatchSize {
+		return nil, wrapError(ErrBatchTooLarge, strconv.Itoa(len(metricsArray))+" metrics, the limit is "+strconv.Itoa(cfg.MaxBatchSize))
+	}
+	return metricsArray, nil
+}
+
+func (cfg StorageConfig) atomicBatch() bool {
+	return cfg.BatchMode != BatchBestEffort
+}
+
+// validateMetrics checks what can be checked without the stored data: the hash, the labels,
+// the type and the presence of the value.
+func (cfg StorageConfig) validateMetrics(metrics Metrics) error {
+	if metrics.ID == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+	metricsHash, _ := metrics.CalcHash(cfg.Key)
+	if cfg.Key != "" && metricsHash != metrics.Hash {
+		return newMetricError(metrics.SeriesKey(), ErrBadSignature)
+	}
+	if err := ValidateLabels(metrics.Labels); err != nil {
+		return newMetricError(metrics.SeriesKey(), err)
+	}
+	switch metrics.MType {
+	case GaugeTypeName:
+		if err := checkFinite(metrics.Value); err != nil {
+			return newMetricError(metrics.SeriesKey(), err)
+		}
+	case CounterTypeName:
+	case HistogramTypeName:
+		if metrics.Histogram == nil {
+			return newMetricError(metrics.SeriesKey(), wrapError(ErrParse, "histogram should be not empty"))
+		}
+		if err := metrics.Histogram.Validate(); err != nil {
+			return newMetricError(metrics.SeriesKey(), err)
+		}
+	default:
+		return newMetricError(metrics.SeriesKey(), wrapError(ErrBadType, metrics.MType))
+	}
+	return nil
+}
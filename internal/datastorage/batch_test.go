@@ -0,0 +1,152 @@
+package datastorage
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchStatuses(results []BatchResult) []string {
+	statuses := []string{}
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	return statuses
+}
+
+func TestBatchEmptyAndOversized(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{MaxBatchSize: 2}) {
+		t.Run(name, func(t *testing.T) {
+			body, err := storage.GetJSONArray([]byte(`[]`))
+			require.NoError(t, err)
+			assert.JSONEq(t, `[]`, string(body))
+
+			_, err = storage.GetJSONArray([]byte(`[{"id":"A","type":"gauge","value":1},{"id":"B","type":"gauge","value":1},{"id":"C","type":"gauge","value":1}]`))
+			assert.ErrorIs(t, err, ErrBatchTooLarge)
+			_, err = storage.GetGaugeValue("A")
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			_, err = storage.GetJSONArray([]byte(`{"id":"A"}`))
+			assert.ErrorIs(t, err, ErrParse)
+		})
+	}
+}
+
+func TestBatchAtomic(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{BatchMode: BatchAtomic}) {
+		t.Run(name, func(t *testing.T) {
+			body, err := storage.GetJSONArray([]byte(`[{"id":"HeapAlloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":2}]`))
+			require.NoError(t, err)
+			results := []BatchResult{}
+			require.NoError(t, json.Unmarshal(body, &results))
+			assert.Equal(t, []string{BatchStatusOK, BatchStatusOK}, batchStatuses(results))
+			require.NotNil(t, results[1].Stored)
+			assert.Equal(t, int64(2), results[1].Stored.Delta)
+
+			_, err = storage.GetJSONArray([]byte(`[{"id":"HeapAlloc","type":"gauge","value":3},{"id":"PollCount","type":"summary"}]`))
+			var batchErr *BatchError
+			require.True(t, errors.As(err, &batchErr))
+			assert.ErrorIs(t, err, ErrBadType)
+			assert.Equal(t, []string{BatchStatusSkipped, BatchStatusFailed}, batchStatuses(batchErr.Results))
+			assert.Equal(t, "DataStorage: wrong metric type: summary", batchErr.Results[1].Error)
+
+			value, err := storage.GetGaugeValue("HeapAlloc")
+			require.NoError(t, err)
+			assert.Equal(t, 1.5, value)
+		})
+	}
+}
+
+func TestBatchBestEffort(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{BatchMode: BatchBestEffort}) {
+		t.Run(name, func(t *testing.T) {
+			body, err := storage.GetJSONArray([]byte(`[` +
+				`{"id":"PollCount","type":"counter","delta":` + strconv.FormatInt(math.MaxInt64, 10) + `},` +
+				`{"id":"PollCount","type":"counter","delta":1},` +
+				`{"id":"Broken","type":"summary"},` +
+				`{"id":"HeapAlloc","type":"gauge","value":2.5}]`))
+			require.NoError(t, err)
+			results := []BatchResult{}
+			require.NoError(t, json.Unmarshal(body, &results))
+			assert.Equal(t, []string{BatchStatusOK, BatchStatusFailed, BatchStatusFailed, BatchStatusOK}, batchStatuses(results))
+			assert.Equal(t, "DataStorage: invalid value: counter overflow", results[1].Error)
+			require.NotNil(t, results[3].Stored)
+			assert.Equal(t, 2.5, results[3].Stored.Value)
+
+			value, err := storage.GetCounterValue("PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(math.MaxInt64), value)
+		})
+	}
+}
+
+func TestBatchAtomicRollback(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{BatchMode: BatchAtomic}) {
+		t.Run(name, func(t *testing.T) {
+			_, err := storage.GetJSONArray([]byte(`[` +
+				`{"id":"HeapAlloc","type":"gauge","value":1},` +
+				`{"id":"PollCount","type":"counter","delta":` + strconv.FormatInt(math.MaxInt64, 10) + `},` +
+				`{"id":"PollCount","type":"counter","delta":1}]`))
+			var batchErr *BatchError
+			require.True(t, errors.As(err, &batchErr))
+			assert.ErrorIs(t, err, ErrCounterOverflow)
+			assert.Equal(t, []string{BatchStatusSkipped, BatchStatusSkipped, BatchStatusFailed}, batchStatuses(batchErr.Results))
+
+			_, err = storage.GetGaugeValue("HeapAlloc")
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = storage.GetCounterValue("PollCount")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestBatchStagesRepeatedMetrics(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{BatchMode: BatchAtomic}) {
+		t.Run(name, func(t *testing.T) {
+			body, err := storage.GetJSONArray([]byte(`[` +
+				`{"id":"PollCount","type":"counter","delta":2},` +
+				`{"id":"Latency","type":"histogram","histogram":{"bounds":[1],"counts":[1,0],"sum":0.5,"count":1}},` +
+				`{"id":"PollCount","type":"counter","delta":3},` +
+				`{"id":"Latency","type":"histogram","histogram":{"bounds":[1],"counts":[0,1],"sum":2,"count":1}}]`))
+			require.NoError(t, err)
+			results := []BatchResult{}
+			require.NoError(t, json.Unmarshal(body, &results))
+			assert.Equal(t, int64(2), results[0].Stored.Delta)
+			assert.Equal(t, int64(5), results[2].Stored.Delta)
+			assert.Equal(t, uint64(1), results[1].Stored.Histogram.Count)
+			assert.Equal(t, uint64(2), results[3].Stored.Histogram.Count)
+		})
+	}
+}
+
+func TestFileBatchWAL(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.db")
+
+	storage, cancel := newWALTestStorage(t, storeFile)
+	_, err := storage.GetJSONArray([]byte(`[{"id":"HeapAlloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":2}]`))
+	require.NoError(t, err)
+	cancel()
+
+	// a crash in the middle of the next batch leaves only its first record
+	file, err := os.OpenFile(storeFile+".wal", os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"seq":3,"type":"gauge","id":"HeapAlloc","value":7,"ts":"2022-01-01T00:00:00Z","batch":2}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	restored, cancel := newWALTestStorage(t, storeFile)
+	defer cancel()
+	gaugeValue, err := restored.GetGaugeValue("HeapAlloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, gaugeValue)
+	counterValue, err := restored.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), counterValue)
+}
@@ -0,0 +1,108 @@
+package datastorage
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// Benchmarks of the in-memory engines, run them with -cpu to see how the engines scale:
+//
+//	go test -run ^$ -bench . -cpu 1,4,16 ./internal/datastorage
+type benchStorage interface {
+	GetUpdate(string, string, string) error
+	GetGaugeValue(string) (float64, error)
+	GetStats() (map[string]float64, map[string]int64, error)
+}
+
+const benchMetrics = 1000
+
+var benchEngines = []string{EngineReceiver, EngineSharded}
+
+func newBenchStorage(b *testing.B, engine string) benchStorage {
+	b.Helper()
+	// the storages log, which breaks the lines of the results
+	log.SetOutput(ioutil.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	cfg := StorageConfig{HistoryLimit: 10}
+	var storage benchStorage
+	if engine == EngineSharded {
+		storage = NewShardedStorage(cfg)
+	} else {
+		fileStorage := NewFileStorage(cfg)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			fileStorage.RunReciver(ctx)
+			close(done)
+		}()
+		b.Cleanup(func() {
+			cancel()
+			<-done
+		})
+		storage = fileStorage
+	}
+	for i := 0; i < benchMetrics; i++ {
+		if err := storage.GetUpdate(GaugeTypeName, "Gauge"+strconv.Itoa(i), "1"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return storage
+}
+
+func BenchmarkStorageUpdate(b *testing.B) {
+	for _, engine := range benchEngines {
+		b.Run(engine, func(b *testing.B) {
+			storage := newBenchStorage(b, engine)
+			var next int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&next, 1)
+					name := "Gauge" + strconv.FormatInt(i%benchMetrics, 10)
+					if err := storage.GetUpdate(GaugeTypeName, name, "2"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkStorageRead(b *testing.B) {
+	for _, engine := range benchEngines {
+		b.Run(engine, func(b *testing.B) {
+			storage := newBenchStorage(b, engine)
+			var next int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&next, 1)
+					if _, err := storage.GetGaugeValue("Gauge" + strconv.FormatInt(i%benchMetrics, 10)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkStorageGetStats(b *testing.B) {
+	for _, engine := range benchEngines {
+		b.Run(engine, func(b *testing.B) {
+			storage := newBenchStorage(b, engine)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, _, err := storage.GetStats(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
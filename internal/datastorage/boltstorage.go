@@ -0,0 +1,520 @@
+package datastorage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltDBType is the DBType of BoltStorage, its DataBaseDSN is the path of the database file.
+const BoltDBType = "bolt"
+
+// Buckets of BoltStorage: a bucket of values per metric type, and the history bucket which
+// holds a bucket of samples per metric keyed by their sequence number.
+var (
+	boltGaugeBucket     = []byte(GaugeTypeName)
+	boltCounterBucket   = []byte(CounterTypeName)
+	boltHistogramBucket = []byte(HistogramTypeName)
+	boltHistoryBucket   = []byte("history")
+)
+
+// errBoltNotOpened is returned by a storage whose database wasn't opened by Open.
+var errBoltNotOpened = wrapError(ErrUnavailable, "bolt database is not opened")
+
+// BoltStorage keeps the metrics in an embedded bbolt database. Every update is a transaction
+// which is synced to the disk before it is acknowledged, so nothing is lost between snapshots.
+type BoltStorage struct {
+	cfg StorageConfig
+	ctx context.Context
+	DB  *bolt.DB
+}
+
+func NewBoltStorage(cfg StorageConfig) *BoltStorage {
+	dataStorage := new(BoltStorage)
+	dataStorage.cfg = cfg
+	return dataStorage
+}
+
+func (storage *BoltStorage) Init() {
+}
+
+func (storage *BoltStorage) RunReciver(end context.Context) {
+	if storage.DB == nil {
+		if err := storage.Open(end); err != nil {
+			return
+		}
+	}
+	defer storage.DB.Close()
+	<-storage.ctx.Done()
+}
+
+// Open opens the database file and creates the buckets; the server calls it before the handlers
+// start and RunReciver calls it when nobody did.
+func (storage *BoltStorage) Open(ctx context.Context) error {
+	storage.ctx = ctx
+
+	db, err := bolt.Open(storage.cfg.DataBaseDSN, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Println("bolt arent opened")
+		log.Println(err)
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltGaugeBucket, boltCounterBucket, boltHistogramBucket, boltHistoryBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("bolt buckets arent created")
+		log.Println(err)
+		db.Close()
+		return err
+	}
+	storage.DB = db
+	return nil
+}
+
+func (storage *BoltStorage) Ping() bool {
+	return storage.DB != nil
+}
+
+// boltTx is the state of a batch in a write transaction.
+type boltTx struct {
+	tx           *bolt.Tx
+	historyLimit int
+}
+
+func (state boltTx) counterValue(name string) int64 {
+	value, _ := decodeBoltInt(state.tx.Bucket(boltCounterBucket).Get([]byte(name)))
+	return value
+}
+
+func (state boltTx) histogramValue(name string) (Histogram, bool) {
+	payload := state.tx.Bucket(boltHistogramBucket).Get([]byte(name))
+	if payload == nil {
+		return Histogram{}, false
+	}
+	histogram := Histogram{}
+	if err := json.Unmarshal(payload, &histogram); err != nil {
+		return Histogram{}, false
+	}
+	return histogram, true
+}
+
+// apply writes the record, the counter delta is checked by the caller.
+func (state boltTx) apply(record walRecord) error {
+	name := []byte(record.Name)
+	switch record.MType {
+	case GaugeTypeName:
+		if err := state.tx.Bucket(boltGaugeBucket).Put(name, encodeBoltFloat(record.Value)); err != nil {
+			return err
+		}
+		return state.appendHistory(HistorySample{ID: record.Name, MType: GaugeTypeName, Value: record.Value, Timestamp: record.Timestamp})
+	case CounterTypeName:
+		value := int64(0)
+		if !record.Reset {
+			value = state.counterValue(record.Name) + record.Delta
+		}
+		if err := state.tx.Bucket(boltCounterBucket).Put(name, encodeBoltInt(value)); err != nil {
+			return err
+		}
+		return state.appendHistory(HistorySample{ID: record.Name, MType: CounterTypeName, Delta: value, Timestamp: record.Timestamp})
+	case HistogramTypeName:
+		payload, err := json.Marshal(record.Histogram)
+		if err != nil {
+			return err
+		}
+		return state.tx.Bucket(boltHistogramBucket).Put(name, payload)
+	}
+	return nil
+}
+
+// appendHistory adds the sample under the next sequence of the metric bucket and drops the
+// samples which fall out of the limit.
+func (state boltTx) appendHistory(sample HistorySample) error {
+	bucket, err := state.tx.Bucket(boltHistoryBucket).CreateBucketIfNotExists([]byte(historyKey(sample.MType, sample.ID)))
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(encodeBoltSeq(seq), payload); err != nil {
+		return err
+	}
+	if state.historyLimit <= 0 || seq <= uint64(state.historyLimit) {
+		return nil
+	}
+	expired := [][]byte{}
+	cursor := bucket.Cursor()
+	for key, _ := cursor.First(); key != nil && binary.BigEndian.Uint64(key) <= seq-uint64(state.historyLimit); key, _ = cursor.Next() {
+		expired = append(expired, key)
+	}
+	for _, key := range expired {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeBoltSeq(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+func encodeBoltInt(value int64) []byte {
+	return encodeBoltSeq(uint64(value))
+}
+
+func decodeBoltInt(payload []byte) (int64, bool) {
+	if len(payload) != 8 {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(payload)), true
+}
+
+func encodeBoltFloat(value float64) []byte {
+	return encodeBoltSeq(math.Float64bits(value))
+}
+
+func decodeBoltFloat(payload []byte) (float64, bool) {
+	if len(payload) != 8 {
+		return 0, false
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(payload)), true
+}
+
+// update stages the records in a write transaction and applies them, an error of stage
+// rolls the transaction back.
+func (storage *BoltStorage) update(stage func(state boltTx) ([]walRecord, error)) error {
+	if storage.DB == nil {
+		return errBoltNotOpened
+	}
+	err := storage.DB.Update(func(tx *bolt.Tx) error {
+		state := boltTx{tx: tx, historyLimit: storage.cfg.HistoryLimit}
+		records, err := stage(state)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, record := range records {
+			record.Timestamp = now
+			if err := state.apply(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return storageError(err)
+}
+
+func (storage *BoltStorage) GetUpdate(metricType string, metricName string, metricValue string) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+
+	switch metricType {
+	case GaugeTypeName:
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "gauge value "+strconv.Quote(metricValue)))
+		}
+		if err := checkFinite(value); err != nil {
+			return newMetricError(metricName, err)
+		}
+		return storage.update(func(state boltTx) ([]walRecord, error) {
+			return []walRecord{{MType: GaugeTypeName, Name: metricName, Value: value}}, nil
+		})
+
+	case CounterTypeName:
+		value, err := strconv.ParseInt(metricValue, 10, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "counter value "+strconv.Quote(metricValue)))
+		}
+		return storage.updateCounter(metricName, value, false)
+
+	case HistogramTypeName:
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "histogram value "+strconv.Quote(metricValue)))
+		}
+		return storage.updateHistogram(metricName, nil, value)
+
+	default:
+		return newMetricError(metricName, wrapError(ErrBadType, metricType))
+	}
+}
+
+func (storage *BoltStorage) updateCounter(metricName string, delta int64, reset bool) error {
+	return storage.update(func(state boltTx) ([]walRecord, error) {
+		value, ok := decodeBoltInt(state.tx.Bucket(boltCounterBucket).Get([]byte(metricName)))
+		if reset && !ok {
+			return nil, newMetricError(metricName, ErrCounterNotFound)
+		}
+		if _, err := addCounter(value, delta); err != nil {
+			return nil, newMetricError(metricName, err)
+		}
+		return []walRecord{{MType: CounterTypeName, Name: metricName, Delta: delta, Reset: reset}}, nil
+	})
+}
+
+func (storage *BoltStorage) updateHistogram(metricName string, delta *Histogram, observation float64) error {
+	return storage.update(func(state boltTx) ([]walRecord, error) {
+		var stored *Histogram
+		if value, ok := state.histogramValue(metricName); ok {
+			stored = &value
+		}
+		merged, err := mergeHistogramUpdate(stored, delta, observation)
+		if err != nil {
+			return nil, newMetricError(metricName, err)
+		}
+		return []walRecord{{MType: HistogramTypeName, Name: metricName, Histogram: &merged}}, nil
+	})
+}
+
+// ResetCounter sets an existing counter to zero.
+func (storage *BoltStorage) ResetCounter(metricName string) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+	return storage.updateCounter(metricName, 0, true)
+}
+
+// UpdateHistogram merges the observations of histogram into the stored one.
+func (storage *BoltStorage) UpdateHistogram(metricName string, histogram Histogram) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+	return storage.updateHistogram(metricName, &histogram, 0)
+}
+
+func (storage *BoltStorage) GetJSONUpdate(jsonDump []byte) error {
+	metrics := Metrics{}
+	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
+		log.Println(err)
+		return wrapError(ErrParse, err.Error())
+	}
+
+	metricsHash, _ := metrics.CalcHash(storage.cfg.Key)
+	if storage.cfg.Key != "" && metricsHash != metrics.Hash {
+		log.Println("Wrong hash, " + metricsHash + " " + metrics.Hash)
+		return newMetricError(metrics.SeriesKey(), ErrBadSignature)
+	}
+	if err := ValidateLabels(metrics.Labels); err != nil {
+		return err
+	}
+
+	if metrics.MType == HistogramTypeName {
+		if metrics.Histogram == nil {
+			return newMetricError(metrics.SeriesKey(), wrapError(ErrParse, "histogram should be not empty"))
+		}
+		return storage.UpdateHistogram(metrics.SeriesKey(), *metrics.Histogram)
+	}
+	return storage.GetUpdate(metrics.MType, metrics.SeriesKey(), metrics.GetStrValue())
+}
+
+// GetJSONArray applies a batch in one transaction and returns the result of every metric,
+// a failed atomic batch rolls the transaction back.
+func (storage *BoltStorage) GetJSONArray(jsonDump []byte) ([]byte, error) {
+	metricsArray, err := storage.cfg.parseBatch(jsonDump)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var results []BatchResult
+	err = storage.update(func(state boltTx) ([]walRecord, error) {
+		records, batchResults, err := storage.cfg.stageBatch(metricsArray, storage.cfg.atomicBatch(), state)
+		results = batchResults
+		return records, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(results)
+}
+
+func (storage *BoltStorage) GetJSONValue(jsonDump []byte) ([]byte, error) {
+	metrics := Metrics{}
+	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
+		log.Println(err)
+		return jsonDump, wrapError(ErrParse, err.Error())
+	}
+
+	switch metrics.MType {
+	case GaugeTypeName:
+		value, err := storage.GetGaugeValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Value = value
+		metrics.Delta = 0
+
+	case CounterTypeName:
+		value, err := storage.GetCounterValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Delta = value
+		metrics.Value = 0
+
+	case HistogramTypeName:
+		value, err := storage.GetHistogramValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Histogram = &value
+		metrics.Delta = 0
+		metrics.Value = 0
+	default:
+		return jsonDump, newMetricError(metrics.SeriesKey(), wrapError(ErrBadType, metrics.MType))
+	}
+
+	metrics.Hash, _ = metrics.CalcHash(storage.cfg.Key)
+	return metrics.MarshalJSON()
+}
+
+// get copies the stored value, the slices of bolt are valid only inside the transaction.
+func (storage *BoltStorage) get(bucket []byte, metricName string) ([]byte, error) {
+	if metricName == "" {
+		return nil, wrapError(ErrParse, "metricName should be not empty")
+	}
+	if storage.DB == nil {
+		return nil, newMetricError(metricName, errBoltNotOpened)
+	}
+	var value []byte
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		if stored := tx.Bucket(bucket).Get([]byte(metricName)); stored != nil {
+			value = append([]byte{}, stored...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, newMetricError(metricName, storageError(err))
+	}
+	if value == nil {
+		return nil, newMetricError(metricName, ErrNotFound)
+	}
+	return value, nil
+}
+
+func (storage *BoltStorage) GetGaugeValue(metricName string) (float64, error) {
+	payload, err := storage.get(boltGaugeBucket, metricName)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := decodeBoltFloat(payload)
+	if !ok {
+		return 0, newMetricError(metricName, wrapError(ErrUnavailable, "broken gauge value"))
+	}
+	return value, nil
+}
+
+func (storage *BoltStorage) GetCounterValue(metricName string) (int64, error) {
+	payload, err := storage.get(boltCounterBucket, metricName)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := decodeBoltInt(payload)
+	if !ok {
+		return 0, newMetricError(metricName, wrapError(ErrUnavailable, "broken counter value"))
+	}
+	return value, nil
+}
+
+func (storage *BoltStorage) GetHistogramValue(metricName string) (Histogram, error) {
+	payload, err := storage.get(boltHistogramBucket, metricName)
+	if err != nil {
+		return Histogram{}, err
+	}
+	histogram := Histogram{}
+	if err := json.Unmarshal(payload, &histogram); err != nil {
+		return Histogram{}, newMetricError(metricName, storageError(err))
+	}
+	return histogram, nil
+}
+
+func (storage *BoltStorage) GetStats() (map[string]float64, map[string]int64, error) {
+	if storage.DB == nil {
+		return nil, nil, errBoltNotOpened
+	}
+	gaugeData := map[string]float64{}
+	counterData := map[string]int64{}
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltGaugeBucket).ForEach(func(key []byte, payload []byte) error {
+			if value, ok := decodeBoltFloat(payload); ok {
+				gaugeData[string(key)] = value
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(boltCounterBucket).ForEach(func(key []byte, payload []byte) error {
+			if value, ok := decodeBoltInt(payload); ok {
+				counterData[string(key)] = value
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, storageError(err)
+	}
+	return gaugeData, counterData, nil
+}
+
+func (storage *BoltStorage) GetStatsFiltered(filter StatsFilter) (map[string]float64, map[string]int64, error) {
+	gaugeData, counterData, err := storage.GetStats()
+	if err != nil {
+		return nil, nil, err
+	}
+	gaugeData, counterData = filterStats(gaugeData, counterData, filter)
+	return gaugeData, counterData, nil
+}
+
+func (storage *BoltStorage) GetHistory(metricType string, metricName string, from time.Time, to time.Time) ([]HistorySample, error) {
+	if metricName == "" {
+		return nil, wrapError(ErrParse, "metricName should be not empty")
+	}
+	if metricType != GaugeTypeName && metricType != CounterTypeName {
+		return nil, newMetricError(metricName, wrapError(ErrBadType, metricType+" has no history"))
+	}
+	if storage.DB == nil {
+		return nil, newMetricError(metricName, errBoltNotOpened)
+	}
+	history := []HistorySample{}
+	err := storage.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltHistoryBucket).Bucket([]byte(historyKey(metricType, metricName)))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(key []byte, payload []byte) error {
+			sample := HistorySample{}
+			if err := json.Unmarshal(payload, &sample); err != nil {
+				return err
+			}
+			history = append(history, sample)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, newMetricError(metricName, storageError(err))
+	}
+	return filterHistory(history, from, to), nil
+}
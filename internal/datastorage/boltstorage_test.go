@@ -0,0 +1,61 @@
+package datastorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltStorageRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := StorageConfig{DBType: BoltDBType, DataBaseDSN: filepath.Join(t.TempDir(), "metrics.bolt"), HistoryLimit: 2}
+
+	storage := NewBoltStorage(cfg)
+	require.NoError(t, storage.Open(ctx))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	_, err := storage.GetJSONArray([]byte(`[{"id":"HeapAlloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":3}]`))
+	require.NoError(t, err)
+	require.NoError(t, storage.GetUpdate(HistogramTypeName, "Latency", "0.5"))
+	// every update is committed on its own, there is nothing to flush on close
+	require.NoError(t, storage.DB.Close())
+
+	restored := NewBoltStorage(cfg)
+	require.NoError(t, restored.Open(ctx))
+	defer restored.DB.Close()
+
+	gaugeValue, err := restored.GetGaugeValue("HeapAlloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, gaugeValue)
+	counterValue, err := restored.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counterValue)
+	histogram, err := restored.GetHistogramValue("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), histogram.Count)
+
+	// the sequence of the history bucket goes on after the restart
+	require.NoError(t, restored.GetUpdate(CounterTypeName, "PollCount", "1"))
+	samples, err := restored.GetHistory(CounterTypeName, "PollCount", time.Unix(0, 0), time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, []int64{5, 6}, []int64{samples[0].Delta, samples[1].Delta})
+}
+
+func TestBoltStorageNotOpened(t *testing.T) {
+	cfg := StorageConfig{DBType: BoltDBType, DataBaseDSN: filepath.Join(t.TempDir(), "missing", "metrics.bolt")}
+	storage := NewBoltStorage(cfg)
+	assert.Error(t, storage.Open(context.Background()))
+
+	assert.ErrorIs(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"), ErrUnavailable)
+	_, err := storage.GetCounterValue("PollCount")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	_, _, err = storage.GetStats()
+	assert.ErrorIs(t, err, ErrUnavailable)
+	_, err = storage.GetHistory(CounterTypeName, "PollCount", time.Unix(0, 0), time.Now())
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
@@ -0,0 +1,205 @@
+package datastorage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	JSONStoreFormat = "json"
+	GobStoreFormat  = "gob"
+
+	DefaultStoreFormat = JSONStoreFormat
+)
+
+// SnapshotCodec turns the stored data into a snapshot payload and back, the snapshot header
+// in front of the payload is written and checked for every codec.
+type SnapshotCodec interface {
+	Encode(data *StoredData) ([]byte, error)
+	Decode(payload []byte, data *StoredData) error
+}
+
+var SnapshotCodecs = map[string]SnapshotCodec{
+	JSONStoreFormat: jsonSnapshotCodec{},
+	GobStoreFormat:  gobSnapshotCodec{},
+}
+
+// GetSnapshotCodec returns the codec for the format, an empty format is the default one.
+func GetSnapshotCodec(format string) (SnapshotCodec, error) {
+	if format == "" {
+		format = DefaultStoreFormat
+	}
+	codec, ok := SnapshotCodecs[format]
+	if !ok {
+		return nil, errors.New("DataStorage: unknown store format " + format + ", valid values: " + JSONStoreFormat + ", " + GobStoreFormat)
+	}
+	return codec, nil
+}
+
+// DetectStoreFormat guesses the format of a snapshot payload: a JSON snapshot is an array.
+func DetectStoreFormat(payload []byte) string {
+	if trimmed := bytes.TrimSpace(payload); len(trimmed) > 0 && trimmed[0] == '[' {
+		return JSONStoreFormat
+	}
+	return GobStoreFormat
+}
+
+type gobSnapshotCodec struct{}
+
+func (gobSnapshotCodec) Encode(data *StoredData) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(data); err != nil {
+		return nil, err
+	}
+	return payload.Bytes(), nil
+}
+
+func (gobSnapshotCodec) Decode(payload []byte, data *StoredData) error {
+	err := gob.NewDecoder(bytes.NewReader(payload)).Decode(data)
+	if err == nil {
+		return nil
+	}
+	// snapshots written before the counters became signed fail on the type of the counter maps
+	legacy := legacyStoredData{}
+	if legacyErr := gob.NewDecoder(bytes.NewReader(payload)).Decode(&legacy); legacyErr != nil {
+		return err
+	}
+	return legacy.convert(data)
+}
+
+// legacyStoredData is StoredData with unsigned counters, as gob snapshots kept them.
+type legacyStoredData struct {
+	GaugeData     map[string]float64
+	CounterData   map[string]uint64
+	HistogramData map[string]Histogram
+	History       map[string][]legacyHistorySample
+	WALSeq        uint64
+}
+
+type legacyHistorySample struct {
+	ID        string
+	MType     string
+	Delta     uint64
+	Value     float64
+	Timestamp time.Time
+}
+
+func (legacy *legacyStoredData) convert(data *StoredData) error {
+	*data = StoredData{GaugeData: legacy.GaugeData, HistogramData: legacy.HistogramData, WALSeq: legacy.WALSeq}
+	data.initMaps()
+	for name, value := range legacy.CounterData {
+		if value > math.MaxInt64 {
+			return ErrCounterOverflow
+		}
+		data.CounterData[name] = int64(value)
+	}
+	for key, legacySamples := range legacy.History {
+		samples := make([]HistorySample, 0, len(legacySamples))
+		for _, sample := range legacySamples {
+			if sample.Delta > math.MaxInt64 {
+				return ErrCounterOverflow
+			}
+			samples = append(samples, HistorySample{ID: sample.ID, MType: sample.MType, Delta: int64(sample.Delta), Value: sample.Value, Timestamp: sample.Timestamp})
+		}
+		data.History[key] = samples
+	}
+	return nil
+}
+
+// snapshotMetric is a Metrics element with the state the file storage keeps next to the value.
+// Seq is StoredData.WALSeq, only the first element carries it.
+type snapshotMetric struct {
+	ID        string            `json:"id"`
+	MType     string            `json:"type"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Delta     *int64            `json:"delta,omitempty"`
+	Value     *float64          `json:"value,omitempty"`
+	Histogram *Histogram        `json:"histogram,omitempty"`
+	Seq       uint64            `json:"seq,omitempty"`
+	History   []HistorySample   `json:"history,omitempty"`
+}
+
+// jsonSnapshotCodec writes an array of Metrics sorted by id and type, so the payload after the
+// header line can be read back as []Metrics; the extra fields are ignored by such readers.
+type jsonSnapshotCodec struct{}
+
+func (jsonSnapshotCodec) Encode(data *StoredData) ([]byte, error) {
+	metrics := make([]snapshotMetric, 0, len(data.GaugeData)+len(data.CounterData)+len(data.HistogramData))
+	for name, value := range data.GaugeData {
+		value := value
+		id, labels := ParseSeriesKey(name)
+		key := historyKey(GaugeTypeName, name)
+		metrics = append(metrics, snapshotMetric{ID: id, MType: GaugeTypeName, Labels: labels, Value: &value, History: data.History[key]})
+	}
+	for name, value := range data.CounterData {
+		value := value
+		id, labels := ParseSeriesKey(name)
+		key := historyKey(CounterTypeName, name)
+		metrics = append(metrics, snapshotMetric{ID: id, MType: CounterTypeName, Labels: labels, Delta: &value, History: data.History[key]})
+	}
+	for name, value := range data.HistogramData {
+		value := value
+		id, labels := ParseSeriesKey(name)
+		metrics = append(metrics, snapshotMetric{ID: id, MType: HistogramTypeName, Labels: labels, Histogram: &value})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ID != metrics[j].ID {
+			return metrics[i].ID < metrics[j].ID
+		}
+		if metrics[i].MType != metrics[j].MType {
+			return metrics[i].MType < metrics[j].MType
+		}
+		return SeriesKey("", metrics[i].Labels) < SeriesKey("", metrics[j].Labels)
+	})
+	if len(metrics) > 0 {
+		metrics[0].Seq = data.WALSeq
+	}
+	return json.MarshalIndent(metrics, "", "  ")
+}
+
+func (jsonSnapshotCodec) Decode(payload []byte, data *StoredData) error {
+	metrics := []snapshotMetric{}
+	if err := json.Unmarshal(payload, &metrics); err != nil {
+		return err
+	}
+
+	*data = StoredData{}
+	data.initMaps()
+	for _, metric := range metrics {
+		key := SeriesKey(metric.ID, metric.Labels)
+		switch metric.MType {
+		case GaugeTypeName:
+			if metric.Value == nil {
+				return errors.New("DataStorage: snapshot: gauge " + metric.ID + " without value")
+			}
+			data.GaugeData[key] = *metric.Value
+		case CounterTypeName:
+			if metric.Delta == nil {
+				return errors.New("DataStorage: snapshot: counter " + metric.ID + " without delta")
+			}
+			data.CounterData[key] = *metric.Delta
+		case HistogramTypeName:
+			if metric.Histogram == nil {
+				return errors.New("DataStorage: snapshot: histogram " + metric.ID + " without value")
+			}
+			if err := metric.Histogram.Validate(); err != nil {
+				return err
+			}
+			data.HistogramData[key] = *metric.Histogram
+		default:
+			return errors.New("DataStorage: snapshot: invalid metricType " + metric.MType)
+		}
+		if len(metric.History) > 0 {
+			data.History[historyKey(metric.MType, key)] = metric.History
+		}
+		if metric.Seq > data.WALSeq {
+			data.WALSeq = metric.Seq
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package datastorage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCodecTestStorage(storeFile string, format string) *FileStorage {
+	return NewFileStorage(StorageConfig{
+		StoreFile:     storeFile,
+		StoreInterval: time.Hour,
+		Store:         true,
+		Restore:       true,
+		StoreFormat:   format,
+	})
+}
+
+func TestJSONSnapshotIsMetricsArray(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.json")
+	storage := newCodecTestStorage(storeFile, "")
+	storage.Data.GaugeData["HeapAlloc"] = 0
+	storage.Data.CounterData["PollCount"] = 7
+	storage.Data.recordHistory(HistorySample{ID: "PollCount", MType: CounterTypeName, Delta: 7}, storage.cfg.HistoryLimit)
+	storage.Data.WALSeq = 3
+	require.NoError(t, storage.StoreData(time.Now()))
+
+	data, err := os.ReadFile(storeFile)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), snapshotMagic+" "), "the json snapshot is checksummed too")
+	payload, err := decodeSnapshot(data)
+	require.NoError(t, err)
+	metrics := []Metrics{}
+	require.NoError(t, json.Unmarshal(payload, &metrics))
+	assert.Equal(t, []Metrics{
+		{ID: "HeapAlloc", MType: GaugeTypeName},
+		{ID: "PollCount", MType: CounterTypeName, Delta: 7},
+	}, metrics)
+
+	restored := newCodecTestStorage(storeFile, JSONStoreFormat)
+	assert.Equal(t, map[string]float64{"HeapAlloc": 0}, restored.Data.GaugeData)
+	assert.Equal(t, map[string]int64{"PollCount": 7}, restored.Data.CounterData)
+	assert.Len(t, restored.Data.History[historyKey(CounterTypeName, "PollCount")], 1)
+	assert.Equal(t, uint64(3), restored.Data.WALSeq)
+}
+
+func TestSnapshotFormatDetection(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.json")
+	storage := newCodecTestStorage(storeFile, GobStoreFormat)
+	storage.Data.GaugeData["HeapAlloc"] = 1.5
+	require.NoError(t, storage.StoreData(time.Now()))
+
+	data, err := os.ReadFile(storeFile)
+	require.NoError(t, err)
+	payload, err := decodeSnapshot(data)
+	require.NoError(t, err)
+	assert.Error(t, json.Unmarshal(payload, &[]Metrics{}))
+
+	// a gob snapshot is restored by a json configured storage and the other way around
+	restored := newCodecTestStorage(storeFile, JSONStoreFormat)
+	assert.Equal(t, 1.5, restored.Data.GaugeData["HeapAlloc"])
+	restored.Data.GaugeData["HeapAlloc"] = 2.5
+	require.NoError(t, restored.StoreData(time.Now()))
+
+	restored = newCodecTestStorage(storeFile, GobStoreFormat)
+	assert.Equal(t, 2.5, restored.Data.GaugeData["HeapAlloc"])
+}
+
+func TestUnknownStoreFormat(t *testing.T) {
+	_, err := GetSnapshotCodec("xml")
+	assert.Error(t, err)
+	assert.Panics(t, func() {
+		newCodecTestStorage(filepath.Join(t.TempDir(), "metrics.json"), "xml")
+	})
+}
+
+func TestJSONSnapshotCorruption(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.json")
+	storage := newSnapshotTestStorage(storeFile)
+	storage.Data.GaugeData["HeapAlloc"] = 1
+	require.NoError(t, storage.StoreData(time.Now()))
+	storage.Data.GaugeData["HeapAlloc"] = 2
+	require.NoError(t, storage.StoreData(time.Now()))
+
+	// the newest snapshot is still valid json, only the checksum catches the changed value
+	data, err := os.ReadFile(storeFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(storeFile, []byte(strings.Replace(string(data), `"value": 2`, `"value": 3`, 1)), 0644))
+
+	restored := newSnapshotTestStorage(storeFile)
+	assert.Equal(t, float64(1), restored.Data.GaugeData["HeapAlloc"])
+}
+
+func TestRestoreLegacyJSONSnapshot(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(storeFile, []byte(`[{"id":"HeapAlloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":4}]`), 0644))
+
+	restored := newCodecTestStorage(storeFile, JSONStoreFormat)
+	assert.Equal(t, 1.5, restored.Data.GaugeData["HeapAlloc"])
+	assert.Equal(t, int64(4), restored.Data.CounterData["PollCount"])
+}
@@ -10,18 +10,30 @@ type StorageConfig struct {
 	StoreFile     string
 	DataBaseDSN   string
 	DBType        string
-	Restore       bool
-	Store         bool
-	Synchronized  bool
-	Key           string
+	// RedisURL selects RedisStorage, like redis://localhost:6379/0.
+	RedisURL     string
+	Restore      bool
+	Store        bool
+	Synchronized bool
+	Key          string
+	HistoryLimit int
+	// StoreRetention is how many snapshots are kept, the current one included.
+	StoreRetention int
+	// StoreFormat is the snapshot codec name, see SnapshotCodecs.
+	StoreFormat string
+	// BatchMode is BatchAtomic or BatchBestEffort, MaxBatchSize limits the metrics of a batch.
+	BatchMode    string
+	MaxBatchSize int
+	// Engine is EngineReceiver for FileStorage or EngineSharded for ShardedStorage.
+	Engine string
 }
 
 func (cfg StorageConfig) String() string {
 	if cfg.Store {
 		return fmt.Sprintf(
-			"Store:%t Restore:%t StoreInterval:%ds StoreFile:%s",
-			cfg.Store, cfg.Restore, int(cfg.StoreInterval.Seconds()), cfg.StoreFile)
+			"Store:%t Restore:%t StoreInterval:%ds StoreFile:%s StoreFormat:%s Engine:%s",
+			cfg.Store, cfg.Restore, int(cfg.StoreInterval.Seconds()), cfg.StoreFile, cfg.StoreFormat, cfg.Engine)
 	} else {
-		return fmt.Sprintf("Store:%t Restore:%t", cfg.Store, cfg.Restore)
+		return fmt.Sprintf("Store:%t Restore:%t Engine:%s", cfg.Store, cfg.Restore, cfg.Engine)
 	}
 }
@@ -0,0 +1,253 @@
+package datastorage
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceStorage is the DataBase of the server without Init and RunReciver.
+type conformanceStorage interface {
+	GetUpdate(string, string, string) error
+	GetGaugeValue(string) (float64, error)
+	GetCounterValue(string) (int64, error)
+	GetHistogramValue(string) (Histogram, error)
+	ResetCounter(string) error
+	GetStats() (map[string]float64, map[string]int64, error)
+	GetStatsFiltered(StatsFilter) (map[string]float64, map[string]int64, error)
+	GetJSONUpdate([]byte) error
+	GetJSONArray([]byte) ([]byte, error)
+	GetJSONValue([]byte) ([]byte, error)
+	GetHistory(string, string, time.Time, time.Time) ([]HistorySample, error)
+	Ping() bool
+}
+
+// newConformanceStorages returns an empty storage of every backend, every test of the suite
+// runs against each of them and expects the behavior of FileStorage.
+func newConformanceStorages(t *testing.T, cfg StorageConfig) map[string]conformanceStorage {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	fileStorage := NewFileStorage(cfg)
+	go fileStorage.RunReciver(ctx)
+
+	sqlCfg := cfg
+	sqlCfg.DBType = "sqlite3"
+	sqlCfg.DataBaseDSN = filepath.Join(t.TempDir(), "metrics.db")
+	sqlStorage := NewSQLStorage(sqlCfg)
+	require.NoError(t, sqlStorage.Open(ctx))
+	t.Cleanup(func() { sqlStorage.DB.Close() })
+
+	boltCfg := cfg
+	boltCfg.DBType = BoltDBType
+	boltCfg.DataBaseDSN = filepath.Join(t.TempDir(), "metrics.bolt")
+	boltStorage := NewBoltStorage(boltCfg)
+	require.NoError(t, boltStorage.Open(ctx))
+	t.Cleanup(func() { boltStorage.DB.Close() })
+
+	return map[string]conformanceStorage{
+		"file":    fileStorage,
+		"sql":     sqlStorage,
+		"sharded": NewShardedStorage(cfg),
+		"redis":   newTestRedisStorage(t, miniredis.RunT(t), cfg),
+		"bolt":    boltStorage,
+	}
+}
+
+func TestConformanceValues(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{}) {
+		t.Run(name, func(t *testing.T) {
+			assert.True(t, storage.Ping())
+
+			require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1.5"))
+			require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "-2.25"))
+			require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+			require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+			require.NoError(t, storage.GetUpdate(HistogramTypeName, "Latency", "0.5"))
+			require.NoError(t, storage.GetJSONUpdate([]byte(`{"id":"Latency","type":"histogram","histogram":`+
+				`{"bounds":[0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10],"counts":[0,0,0,0,0,0,0,0,0,0,0,2],"sum":30,"count":2}}`)))
+			require.NoError(t, storage.GetJSONUpdate([]byte(`{"id":"Requests","type":"counter","delta":4,"labels":{"code":"200"}}`)))
+
+			gaugeValue, err := storage.GetGaugeValue("HeapAlloc")
+			require.NoError(t, err)
+			assert.Equal(t, -2.25, gaugeValue)
+			counterValue, err := storage.GetCounterValue("PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), counterValue)
+			histogram, err := storage.GetHistogramValue("Latency")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(3), histogram.Count)
+			assert.Equal(t, 30.5, histogram.Sum)
+
+			body, err := storage.GetJSONValue([]byte(`{"id":"Requests","type":"counter","labels":{"code":"200"}}`))
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"id":"Requests","type":"counter","delta":4,"labels":{"code":"200"}}`, string(body))
+			body, err = storage.GetJSONValue([]byte(`{"id":"HeapAlloc","type":"gauge"}`))
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"id":"HeapAlloc","type":"gauge","value":-2.25}`, string(body))
+		})
+	}
+}
+
+func TestConformanceErrors(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{Key: "secret"}) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", strconv.FormatInt(math.MaxInt64-1, 10)))
+
+			tests := []struct {
+				testName string
+				err      error
+				want     error
+			}{
+				{"empty_name", storage.GetUpdate(GaugeTypeName, "", "1"), ErrParse},
+				{"wrong_type", storage.GetUpdate("summary", "HeapAlloc", "1"), ErrBadType},
+				{"wrong_gauge", storage.GetUpdate(GaugeTypeName, "HeapAlloc", "none"), ErrParse},
+				{"nan_gauge", storage.GetUpdate(GaugeTypeName, "HeapAlloc", "NaN"), ErrNotFinite},
+				{"inf_gauge", storage.GetUpdate(GaugeTypeName, "HeapAlloc", "-Inf"), ErrNotFinite},
+				{"wrong_counter", storage.GetUpdate(CounterTypeName, "PollCount", "1.5"), ErrParse},
+				{"overflow", storage.GetUpdate(CounterTypeName, "PollCount", "2"), ErrCounterOverflow},
+				{"reset_missing", storage.ResetCounter("Missing"), ErrCounterNotFound},
+				{"broken_json", storage.GetJSONUpdate([]byte(`{"id":`)), ErrParse},
+				{"wrong_hash", storage.GetJSONUpdate([]byte(`{"id":"HeapAlloc","type":"gauge","value":1,"hash":"00"}`)), ErrBadSignature},
+				{"wrong_histogram", storage.GetUpdate(HistogramTypeName, "Latency", "none"), ErrParse},
+			}
+			for _, tt := range tests {
+				assert.ErrorIs(t, tt.err, tt.want, tt.testName)
+			}
+
+			_, err := storage.GetGaugeValue("HeapAlloc")
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = storage.GetCounterValue("Missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = storage.GetHistogramValue("Missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = storage.GetJSONValue([]byte(`{"id":"Missing","type":"gauge"}`))
+			assert.ErrorIs(t, err, ErrNotFound)
+			_, err = storage.GetJSONValue([]byte(`{"id":"HeapAlloc","type":"summary"}`))
+			assert.ErrorIs(t, err, ErrBadType)
+			_, err = storage.GetHistory(HistogramTypeName, "Latency", time.Unix(0, 0), time.Now())
+			assert.ErrorIs(t, err, ErrBadType)
+
+			// the failed updates change nothing
+			counterValue, err := storage.GetCounterValue("PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(math.MaxInt64-1), counterValue)
+		})
+	}
+}
+
+func TestConformanceResetCounter(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{}) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "7"))
+			require.NoError(t, storage.ResetCounter("PollCount"))
+			counterValue, err := storage.GetCounterValue("PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(0), counterValue)
+
+			require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+			counterValue, err = storage.GetCounterValue("PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), counterValue)
+		})
+	}
+}
+
+func TestConformanceHistory(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{}) {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now().Add(-time.Second)
+			require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1"))
+			require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "2"))
+			require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+			require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+
+			samples, err := storage.GetHistory(GaugeTypeName, "HeapAlloc", start, time.Now().Add(time.Second))
+			require.NoError(t, err)
+			require.Len(t, samples, 2)
+			assert.Equal(t, []float64{1, 2}, []float64{samples[0].Value, samples[1].Value})
+
+			samples, err = storage.GetHistory(CounterTypeName, "PollCount", start, time.Now().Add(time.Second))
+			require.NoError(t, err)
+			require.Len(t, samples, 2)
+			assert.Equal(t, []int64{2, 5}, []int64{samples[0].Delta, samples[1].Delta})
+
+			samples, err = storage.GetHistory(CounterTypeName, "PollCount", time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))
+			require.NoError(t, err)
+			assert.Empty(t, samples)
+			samples, err = storage.GetHistory(CounterTypeName, "Missing", start, time.Now().Add(time.Second))
+			require.NoError(t, err)
+			assert.Empty(t, samples)
+		})
+	}
+}
+
+func TestConformanceHistoryLimit(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{HistoryLimit: 2}) {
+		t.Run(name, func(t *testing.T) {
+			for i := 1; i <= 4; i++ {
+				require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "1"))
+			}
+			samples, err := storage.GetHistory(CounterTypeName, "PollCount", time.Unix(0, 0), time.Now().Add(time.Second))
+			require.NoError(t, err)
+			require.Len(t, samples, 2)
+			assert.Equal(t, []int64{3, 4}, []int64{samples[0].Delta, samples[1].Delta})
+		})
+	}
+}
+
+func TestConformanceStats(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{}) {
+		t.Run(name, func(t *testing.T) {
+			gaugeData, counterData, err := storage.GetStats()
+			require.NoError(t, err)
+			assert.Empty(t, gaugeData)
+			assert.Empty(t, counterData)
+
+			require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1"))
+			require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapIdle", "2"))
+			require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+			require.NoError(t, storage.GetUpdate(HistogramTypeName, "Latency", "0.5"))
+			require.NoError(t, storage.GetJSONUpdate([]byte(`{"id":"Requests","type":"counter","delta":1,"labels":{"code":"200"}}`)))
+			require.NoError(t, storage.GetJSONUpdate([]byte(`{"id":"Requests","type":"counter","delta":2,"labels":{"code":"500"}}`)))
+
+			gaugeData, counterData, err = storage.GetStats()
+			require.NoError(t, err)
+			assert.Equal(t, map[string]float64{"HeapAlloc": 1, "HeapIdle": 2}, gaugeData)
+			assert.Equal(t, map[string]int64{"PollCount": 3, `Requests{code="200"}`: 1, `Requests{code="500"}`: 2}, counterData)
+
+			// the returned maps are copies
+			gaugeData["HeapAlloc"] = 10
+			gaugeValue, err := storage.GetGaugeValue("HeapAlloc")
+			require.NoError(t, err)
+			assert.Equal(t, 1.0, gaugeValue)
+
+			gaugeData, counterData, err = storage.GetStatsFiltered(StatsFilter{Prefix: "Heap", Offset: 1})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]float64{"HeapIdle": 2}, gaugeData)
+			assert.Empty(t, counterData)
+
+			gaugeData, counterData, err = storage.GetStatsFiltered(StatsFilter{Prefix: "heap"})
+			require.NoError(t, err)
+			assert.Empty(t, gaugeData, "the prefix is case-sensitive")
+			assert.Empty(t, counterData)
+
+			gaugeData, counterData, err = storage.GetStatsFiltered(StatsFilter{Labels: map[string]string{"code": "500"}})
+			require.NoError(t, err)
+			assert.Empty(t, gaugeData)
+			assert.Equal(t, map[string]int64{`Requests{code="500"}`: 2}, counterData)
+
+			gaugeData, counterData, err = storage.GetStatsFiltered(StatsFilter{Offset: 1, Limit: 2})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]float64{"HeapIdle": 2}, gaugeData)
+			assert.Equal(t, map[string]int64{"PollCount": 3}, counterData)
+		})
+	}
+}
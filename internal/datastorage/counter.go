@@ -0,0 +1,19 @@
+package datastorage
+
+import (
+	"math"
+)
+
+// Counters are int64: an update adds a delta, negative ones included, and a reset sets the
+// counter to zero. An update that does not fit into int64 is rejected, the value is kept.
+var (
+	ErrCounterOverflow = wrapError(ErrInvalidValue, "counter overflow")
+	ErrCounterNotFound = wrapError(ErrNotFound, "counter")
+)
+
+func addCounter(value int64, delta int64) (int64, error) {
+	if (delta > 0 && value > math.MaxInt64-delta) || (delta < 0 && value < math.MinInt64-delta) {
+		return value, ErrCounterOverflow
+	}
+	return value + delta, nil
+}
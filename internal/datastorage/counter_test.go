@@ -0,0 +1,86 @@
+package datastorage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddCounter(t *testing.T) {
+	tests := []struct {
+		testName string
+		value    int64
+		delta    int64
+		result   int64
+		err      error
+	}{
+		{testName: "positive", value: 2, delta: 3, result: 5},
+		{testName: "negative", value: 2, delta: -5, result: -3},
+		{testName: "max", value: math.MaxInt64 - 1, delta: 1, result: math.MaxInt64},
+		{testName: "overflow", value: math.MaxInt64, delta: 1, result: math.MaxInt64, err: ErrCounterOverflow},
+		{testName: "min", value: math.MinInt64 + 1, delta: -1, result: math.MinInt64},
+		{testName: "underflow", value: math.MinInt64, delta: -1, result: math.MinInt64, err: ErrCounterOverflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			result, err := addCounter(tt.value, tt.delta)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.result, result)
+		})
+	}
+}
+
+func TestFileStorageCounterOverflowAndReset(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.db")
+
+	storage, cancel := newWALTestStorage(t, storeFile)
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", strconv.FormatInt(math.MaxInt64-1, 10)))
+	assert.ErrorIs(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"), ErrCounterOverflow)
+	assert.Error(t, storage.GetUpdate(CounterTypeName, "PollCount", "18446744073709551615"))
+	counterValue, err := storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64-1), counterValue)
+
+	assert.ErrorIs(t, storage.ResetCounter("Unknown"), ErrCounterNotFound)
+	require.NoError(t, storage.ResetCounter("PollCount"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "-3"))
+	cancel()
+
+	// the reset is in the write-ahead log, so the restart does not bring the old value back
+	restored, cancel := newWALTestStorage(t, storeFile)
+	defer cancel()
+	counterValue, err = restored.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-3), counterValue)
+}
+
+func TestGobSnapshotWithUnsignedCounters(t *testing.T) {
+	legacy := legacyStoredData{
+		GaugeData:   map[string]float64{"HeapAlloc": 1.5},
+		CounterData: map[string]uint64{"PollCount": 4},
+		History: map[string][]legacyHistorySample{
+			historyKey(CounterTypeName, "PollCount"): {{ID: "PollCount", MType: CounterTypeName, Delta: 4, Timestamp: time.Unix(1, 0)}},
+		},
+		WALSeq: 2,
+	}
+	var payload bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&payload).Encode(legacy))
+
+	data := StoredData{}
+	require.NoError(t, gobSnapshotCodec{}.Decode(payload.Bytes(), &data))
+	assert.Equal(t, map[string]int64{"PollCount": 4}, data.CounterData)
+	assert.Equal(t, int64(4), data.History[historyKey(CounterTypeName, "PollCount")][0].Delta)
+	assert.Equal(t, uint64(2), data.WALSeq)
+
+	legacy.CounterData["PollCount"] = math.MaxUint64
+	payload.Reset()
+	require.NoError(t, gob.NewEncoder(&payload).Encode(legacy))
+	assert.ErrorIs(t, gobSnapshotCodec{}.Decode(payload.Bytes(), &data), ErrCounterOverflow)
+}
@@ -2,19 +2,17 @@ package datastorage
 
 import (
 	"context"
-	"encoding/gob"
 	"encoding/json"
-	"errors"
-	"io"
 	"log"
-	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
 const (
-	GaugeTypeName   string = "gauge"
-	CounterTypeName string = "counter"
+	GaugeTypeName     string = "gauge"
+	CounterTypeName   string = "counter"
+	HistogramTypeName string = "histogram"
 )
 
 type GaugeDataUpdate struct {
@@ -23,10 +21,34 @@ type GaugeDataUpdate struct {
 	Responce chan bool
 }
 
+// CounterDataUpdate adds Value to the counter or, with Reset, sets it to zero.
+// The responce is nil, ErrCounterOverflow, ErrCounterNotFound for a reset or another error.
 type CounterDataUpdate struct {
 	Name     string
-	Value    uint64
-	Responce chan bool
+	Value    int64
+	Reset    bool
+	Responce chan error
+}
+
+// HistogramDataUpdate merges Value into the stored histogram, a nil Value is a single Observation.
+type HistogramDataUpdate struct {
+	Name        string
+	Value       *Histogram
+	Observation float64
+	Responce    chan error
+}
+
+// BatchDataUpdate applies Metrics under a single receive, an atomic batch is rolled back whole
+// on the first failed metric.
+type BatchDataUpdate struct {
+	Metrics  []Metrics
+	Atomic   bool
+	Responce chan BatchDataResponce
+}
+
+type BatchDataResponce struct {
+	Results []BatchResult
+	Err     error
 }
 
 type GasugeDataResponce struct {
@@ -35,7 +57,12 @@ type GasugeDataResponce struct {
 }
 
 type CounterDataResponce struct {
-	Value   uint64
+	Value   int64
+	Success bool
+}
+
+type HistogramDataResponce struct {
+	Value   Histogram
 	Success bool
 }
 
@@ -49,32 +76,119 @@ type CounterDataRequest struct {
 	Responce chan CounterDataResponce
 }
 
+type HistogramDataRequest struct {
+	Name     string
+	Responce chan HistogramDataResponce
+}
+
 type CollectedDataRequest struct {
 	Responce chan CollectedDataResponce
 }
 
 type CollectedDataResponce struct {
 	GaugeData   map[string]float64
-	CounterData map[string]uint64
+	CounterData map[string]int64
 	Success     bool
 }
 
 type StoredData struct {
-	GaugeData   map[string]float64
-	CounterData map[string]uint64
+	GaugeData     map[string]float64
+	CounterData   map[string]int64
+	HistogramData map[string]Histogram
+	History       map[string][]HistorySample
+	// WALSeq is the last write-ahead log record applied to the data.
+	WALSeq uint64
 
 	storedTS time.Time
 }
 
-type FileStorage struct {
-	Data               StoredData
-	GaugeUpdateChan    chan GaugeDataUpdate
-	CounterUpdateChan  chan CounterDataUpdate
-	GaugeRequestChan   chan GaugeDataRequest
-	CounterRequestChan chan CounterDataRequest
-	RequestChan        chan CollectedDataRequest
+func (data *StoredData) initMaps() {
+	if data.GaugeData == nil {
+		data.GaugeData = map[string]float64{}
+	}
+	if data.CounterData == nil {
+		data.CounterData = map[string]int64{}
+	}
+	if data.HistogramData == nil {
+		data.HistogramData = map[string]Histogram{}
+	}
+	if data.History == nil {
+		data.History = map[string][]HistorySample{}
+	}
+}
 
-	cfg StorageConfig
+func (data *StoredData) applyRecord(record walRecord, historyLimit int) {
+	switch record.MType {
+	case GaugeTypeName:
+		data.GaugeData[record.Name] = record.Value
+		data.recordHistory(HistorySample{ID: record.Name, MType: GaugeTypeName, Value: record.Value, Timestamp: record.Timestamp}, historyLimit)
+	case CounterTypeName:
+		value := int64(0)
+		if !record.Reset {
+			var err error
+			if value, err = addCounter(data.CounterData[record.Name], record.Delta); err != nil {
+				log.Println("DataStorage: counter " + record.Name + ": " + err.Error())
+				break
+			}
+		}
+		data.CounterData[record.Name] = value
+		data.recordHistory(HistorySample{ID: record.Name, MType: CounterTypeName, Delta: data.CounterData[record.Name], Timestamp: record.Timestamp}, historyLimit)
+	case HistogramTypeName:
+		if record.Histogram != nil {
+			data.HistogramData[record.Name] = record.Histogram.Copy()
+		}
+	}
+	if record.Seq > data.WALSeq {
+		data.WALSeq = record.Seq
+	}
+}
+
+func (data *StoredData) recordHistory(sample HistorySample, historyLimit int) {
+	if sample.Timestamp.IsZero() {
+		sample.Timestamp = time.Now()
+	}
+	key := historyKey(sample.MType, sample.ID)
+	data.History[key] = appendHistory(data.History[key], sample, historyLimit)
+}
+
+func (data *StoredData) counterValue(name string) int64 {
+	return data.CounterData[name]
+}
+
+func (data *StoredData) histogramValue(name string) (Histogram, bool) {
+	value, ok := data.HistogramData[name]
+	return value, ok
+}
+
+// copyStats returns copies of the gauges and counters, the receiver keeps changing the
+// stored maps while the caller reads the copies.
+func (data *StoredData) copyStats() (map[string]float64, map[string]int64) {
+	gaugeData := make(map[string]float64, len(data.GaugeData))
+	for name, value := range data.GaugeData {
+		gaugeData[name] = value
+	}
+	counterData := make(map[string]int64, len(data.CounterData))
+	for name, value := range data.CounterData {
+		counterData[name] = value
+	}
+	return gaugeData, counterData
+}
+
+type FileStorage struct {
+	Data                 StoredData
+	GaugeUpdateChan      chan GaugeDataUpdate
+	CounterUpdateChan    chan CounterDataUpdate
+	HistogramUpdateChan  chan HistogramDataUpdate
+	BatchUpdateChan      chan BatchDataUpdate
+	GaugeRequestChan     chan GaugeDataRequest
+	CounterRequestChan   chan CounterDataRequest
+	HistogramRequestChan chan HistogramDataRequest
+	RequestChan          chan CollectedDataRequest
+	HistoryRequestChan   chan HistoryDataRequest
+
+	cfg     StorageConfig
+	wal     *writeAheadLog
+	storeMu sync.Mutex
 }
 
 func (storage *FileStorage) Ping() bool {
@@ -84,54 +198,222 @@ func (storage *FileStorage) Ping() bool {
 func (storage *FileStorage) Init() {
 	storage.GaugeUpdateChan = make(chan GaugeDataUpdate, 1024)
 	storage.CounterUpdateChan = make(chan CounterDataUpdate, 1024)
+	storage.HistogramUpdateChan = make(chan HistogramDataUpdate, 1024)
+	storage.BatchUpdateChan = make(chan BatchDataUpdate, 1024)
 	storage.GaugeRequestChan = make(chan GaugeDataRequest, 1024)
 	storage.CounterRequestChan = make(chan CounterDataRequest, 1024)
+	storage.HistogramRequestChan = make(chan HistogramDataRequest, 1024)
 	storage.RequestChan = make(chan CollectedDataRequest, 1024)
+	storage.HistoryRequestChan = make(chan HistoryDataRequest, 1024)
 }
 
 func (storage *FileStorage) RestoreData() error {
 	if !(storage.cfg.Restore && storage.cfg.Store) {
 		log.Println("No data restoring")
-		storage.Data.GaugeData = map[string]float64{}
-		storage.Data.CounterData = map[string]uint64{}
+		storage.Data.initMaps()
 		return nil
 	}
 	log.Println("Start restore data from: " + storage.cfg.StoreFile)
 
-	file, err := os.OpenFile(storage.cfg.StoreFile, os.O_RDONLY|os.O_CREATE, 0777)
+	data, err := storage.cfg.readStoredData()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	storage.Data = data
 
-	decoder := gob.NewDecoder(file)
-	err = decoder.Decode(&storage.Data)
-	switch err {
-	case io.EOF:
-		storage.Data.GaugeData = map[string]float64{}
-		storage.Data.CounterData = map[string]uint64{}
-	default:
-		return err
+	if storage.walEnabled() {
+		if err := storage.replayWAL(); err != nil {
+			return err
+		}
 	}
 
 	log.Println("Restore data: succesed")
 	return nil
 }
 
-func (storage *FileStorage) StoreData(t time.Time) error {
-	if !storage.cfg.Store {
-		return nil
+func (storage *FileStorage) walEnabled() bool {
+	return storage.cfg.Store && !storage.cfg.Synchronized
+}
+
+func (storage *FileStorage) walPath() string {
+	return storage.cfg.StoreFile + ".wal"
+}
+
+// replayWAL applies the records written after the snapshot that was just restored.
+func (storage *FileStorage) replayWAL() error {
+	records, err := readWAL(storage.walPath())
+	if err != nil {
+		return err
+	}
+	replayed := 0
+	for _, record := range records {
+		if record.Seq <= storage.Data.WALSeq {
+			continue
+		}
+		storage.applyRecord(record)
+		replayed++
 	}
+	log.Printf("WAL: replayed %d of %d records\n", replayed, len(records))
+	return nil
+}
 
-	file, err := os.OpenFile(storage.cfg.StoreFile, os.O_WRONLY|os.O_CREATE, 0777)
+// openWAL starts a fresh log on top of the restored data: whatever the old log held is
+// snapshotted first, so a torn tail never sits in front of new records.
+func (storage *FileStorage) openWAL() error {
+	if !storage.walEnabled() {
+		return nil
+	}
+	if err := storage.StoreData(time.Now()); err != nil {
+		return err
+	}
+	wal, err := openWAL(storage.walPath())
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	if err := wal.Truncate(); err != nil {
+		wal.Close()
+		return err
+	}
+	storage.wal = wal
+	return nil
+}
+
+func (storage *FileStorage) applyRecord(record walRecord) {
+	storage.Data.applyRecord(record, storage.cfg.HistoryLimit)
+}
+
+// logAndApply writes the update to the log before it becomes visible.
+func (storage *FileStorage) logAndApply(record walRecord) bool {
+	record.Seq = storage.Data.WALSeq + 1
+	record.Timestamp = time.Now()
+	if storage.wal != nil {
+		if err := storage.wal.Append(record); err != nil {
+			log.Println("WAL: append error: " + err.Error())
+			return false
+		}
+	}
+	storage.applyRecord(record)
+	return true
+}
+
+// updateCounter checks the update against the current value, so the log holds no update
+// which would overflow on replay.
+func (storage *FileStorage) updateCounter(update CounterDataUpdate) error {
+	value, ok := storage.Data.CounterData[update.Name]
+	if update.Reset && !ok {
+		return newMetricError(update.Name, ErrCounterNotFound)
+	}
+	if _, err := addCounter(value, update.Value); err != nil {
+		return newMetricError(update.Name, err)
+	}
+	if !storage.logAndApply(walRecord{MType: CounterTypeName, Name: update.Name, Delta: update.Value, Reset: update.Reset}) {
+		return newMetricError(update.Name, ErrUnavailable)
+	}
+	if err := storage.storeSynchronized(); err != nil {
+		return newMetricError(update.Name, err)
+	}
+	return nil
+}
+
+// updateHistogram logs the merged histogram rather than the delta, so the replay just sets it.
+func (storage *FileStorage) updateHistogram(update HistogramDataUpdate) error {
+	var stored *Histogram
+	if value, ok := storage.Data.HistogramData[update.Name]; ok {
+		stored = &value
+	}
+	merged, err := mergeHistogramUpdate(stored, update.Value, update.Observation)
+	if err != nil {
+		log.Println("DataStorage: histogram " + update.Name + ": " + err.Error())
+		return newMetricError(update.Name, err)
+	}
+	if !storage.logAndApply(walRecord{MType: HistogramTypeName, Name: update.Name, Histogram: &merged}) {
+		return newMetricError(update.Name, ErrUnavailable)
+	}
+	if err := storage.storeSynchronized(); err != nil {
+		return newMetricError(update.Name, err)
+	}
+	return nil
+}
+
+// updateBatch stages the whole batch against the current data before anything is logged,
+// so a failed atomic batch leaves neither the data nor the log changed.
+func (storage *FileStorage) updateBatch(update BatchDataUpdate) BatchDataResponce {
+	records, results, err := storage.cfg.stageBatch(update.Metrics, update.Atomic, &storage.Data)
+	if err != nil {
+		return BatchDataResponce{results, err}
+	}
+
+	if !storage.logAndApplyBatch(records) || storage.storeSynchronized() != nil {
+		if update.Atomic {
+			skipApplied(results)
+			return BatchDataResponce{results, &BatchError{Err: ErrUnavailable, Results: results}}
+		}
+		for i := range results {
+			if results[i].Status == BatchStatusOK {
+				results[i].fail(ErrUnavailable)
+			}
+		}
+	}
+	return BatchDataResponce{results, nil}
+}
+
+// logAndApplyBatch writes the records to the log as one batch before any of them becomes visible.
+func (storage *FileStorage) logAndApplyBatch(records []walRecord) bool {
+	if len(records) == 0 {
+		return true
+	}
+	now := time.Now()
+	for i := range records {
+		records[i].Seq = storage.Data.WALSeq + uint64(i) + 1
+		records[i].Timestamp = now
+	}
+	records[0].Batch = len(records)
+	if storage.wal != nil {
+		if err := storage.wal.AppendBatch(records); err != nil {
+			log.Println("WAL: append error: " + err.Error())
+			return false
+		}
+	}
+	for _, record := range records {
+		storage.applyRecord(record)
+	}
+	return true
+}
+
+// storeSynchronized writes the snapshot after every update in the synchronized mode. It runs in
+// the receiver, so the data isn't changed while it is encoded.
+func (storage *FileStorage) storeSynchronized() error {
+	if !storage.cfg.Synchronized {
+		return nil
+	}
+	if err := storage.StoreData(time.Now()); err != nil {
+		log.Println("Store data error: " + err.Error())
+		return storageError(err)
+	}
+	return nil
+}
+
+func (storage *FileStorage) storeAndCompact(t time.Time) {
+	if err := storage.StoreData(t); err != nil {
+		log.Println("Store data error: " + err.Error())
+		return
+	}
+	if storage.wal != nil {
+		if err := storage.wal.Truncate(); err != nil {
+			log.Println("WAL: truncate error: " + err.Error())
+		}
+	}
+}
+
+func (storage *FileStorage) StoreData(t time.Time) error {
+	if !storage.cfg.Store {
+		return nil
+	}
+	storage.storeMu.Lock()
+	defer storage.storeMu.Unlock()
 
 	storage.Data.storedTS = t
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(&storage.Data); err != io.EOF && err != nil {
+	if err := storage.cfg.writeStoredData(&storage.Data); err != nil {
 		return err
 	}
 
@@ -145,9 +427,15 @@ func NewFileStorage(cfg StorageConfig) *FileStorage {
 	dataStorage := new(FileStorage)
 	dataStorage.Init()
 	dataStorage.cfg = cfg
+	if _, err := GetSnapshotCodec(cfg.StoreFormat); err != nil {
+		panic(err)
+	}
 	if err := dataStorage.RestoreData(); err != nil {
 		panic(err)
 	}
+	if err := dataStorage.openWAL(); err != nil {
+		panic(err)
+	}
 	return dataStorage
 }
 
@@ -164,23 +452,35 @@ func (storage *FileStorage) RunReciver(end context.Context) {
 	for {
 		select {
 		case update := <-storage.GaugeUpdateChan:
-			storage.Data.GaugeData[update.Name] = update.Value
-			update.Responce <- true
+			update.Responce <- storage.logAndApply(walRecord{MType: GaugeTypeName, Name: update.Name, Value: update.Value}) && storage.storeSynchronized() == nil
 		case update := <-storage.CounterUpdateChan:
-			storage.Data.CounterData[update.Name] += update.Value
-			update.Responce <- true
+			update.Responce <- storage.updateCounter(update)
+		case update := <-storage.HistogramUpdateChan:
+			update.Responce <- storage.updateHistogram(update)
+		case update := <-storage.BatchUpdateChan:
+			update.Responce <- storage.updateBatch(update)
 		case request := <-storage.GaugeRequestChan:
 			value, ok := storage.Data.GaugeData[request.Name]
 			request.Responce <- GasugeDataResponce{value, ok}
 		case request := <-storage.CounterRequestChan:
 			value, ok := storage.Data.CounterData[request.Name]
 			request.Responce <- CounterDataResponce{value, ok}
+		case request := <-storage.HistogramRequestChan:
+			value, ok := storage.Data.HistogramData[request.Name]
+			request.Responce <- HistogramDataResponce{value.Copy(), ok}
 		case request := <-storage.RequestChan:
-			request.Responce <- CollectedDataResponce{storage.Data.GaugeData, storage.Data.CounterData, true}
+			gaugeData, counterData := storage.Data.copyStats()
+			request.Responce <- CollectedDataResponce{gaugeData, counterData, true}
+		case request := <-storage.HistoryRequestChan:
+			history := storage.Data.History[historyKey(request.MType, request.Name)]
+			request.Responce <- HistoryDataResponce{filterHistory(history, request.From, request.To), true}
 		case t := <-storeTimer.C:
-			_ = storage.StoreData(t)
+			storage.storeAndCompact(t)
 		case <-end.Done():
 			log.Println("End Reciver")
+			if storage.wal != nil {
+				storage.wal.Close()
+			}
 			return
 		}
 	}
@@ -188,40 +488,83 @@ func (storage *FileStorage) RunReciver(end context.Context) {
 
 func (storage *FileStorage) GetUpdate(metricType string, metricName string, metricValue string) error {
 	if metricName == "" {
-		return errors.New("DataStorage: GetUpdate: metricName should be not empty")
+		return wrapError(ErrParse, "metricName should be not empty")
 	}
 
-	responceChan := make(chan bool, 1)
-
 	switch metricType {
 	case GaugeTypeName:
 		value, err := strconv.ParseFloat(metricValue, 64)
 		if err != nil {
-			return errors.New("DataStorage: GetUpdate: error whith parsing gauge metricValue: ") // + err.GetString())
+			return newMetricError(metricName, wrapError(ErrParse, "gauge value "+strconv.Quote(metricValue)))
+		}
+		if err := checkFinite(value); err != nil {
+			return newMetricError(metricName, err)
 		}
+		responceChan := make(chan bool, 1)
 		storage.GaugeUpdateChan <- GaugeDataUpdate{metricName, value, responceChan}
+		if success := <-responceChan; !success {
+			return newMetricError(metricName, ErrUnavailable)
+		}
 
 	case CounterTypeName:
-		value, err := strconv.ParseUint(metricValue, 10, 64)
+		value, err := strconv.ParseInt(metricValue, 10, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "counter value "+strconv.Quote(metricValue)))
+		}
+		return storage.sendCounterUpdate(CounterDataUpdate{Name: metricName, Value: value})
+
+	case HistogramTypeName:
+		value, err := strconv.ParseFloat(metricValue, 64)
 		if err != nil {
-			return errors.New("DataStorage: GetUpdate: error whith parsing counter metricValue: ") // + err.GetString())
+			return newMetricError(metricName, wrapError(ErrParse, "histogram value "+strconv.Quote(metricValue)))
 		}
-		storage.CounterUpdateChan <- CounterDataUpdate{metricName, value, responceChan}
+		return storage.sendHistogramUpdate(HistogramDataUpdate{Name: metricName, Observation: value})
 
 	default:
-		return errors.New(
-			"DataStorage: GetUpdate: invalid metricType value, valid values: " + GaugeTypeName + ", " + CounterTypeName)
+		return newMetricError(metricName, wrapError(ErrBadType, metricType))
 	}
 
-	success := <-responceChan
-	if !success {
-		return errors.New("DataStorage: GetUpdate: some error")
+	return nil
+}
+
+// ResetCounter sets an existing counter to zero.
+func (storage *FileStorage) ResetCounter(metricName string) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
 	}
-	if storage.cfg.Synchronized {
-		storage.StoreData(time.Now())
+	return storage.sendCounterUpdate(CounterDataUpdate{Name: metricName, Reset: true})
+}
+
+func (storage *FileStorage) sendCounterUpdate(update CounterDataUpdate) error {
+	update.Responce = make(chan error, 1)
+	storage.CounterUpdateChan <- update
+
+	return <-update.Responce
+}
+
+// UpdateHistogram merges the observations of histogram into the stored one.
+func (storage *FileStorage) UpdateHistogram(metricName string, histogram Histogram) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
 	}
+	return storage.sendHistogramUpdate(HistogramDataUpdate{Name: metricName, Value: &histogram})
+}
 
-	return nil
+func (storage *FileStorage) sendHistogramUpdate(update HistogramDataUpdate) error {
+	update.Responce = make(chan error, 1)
+	storage.HistogramUpdateChan <- update
+
+	return <-update.Responce
+}
+
+func (storage *FileStorage) updateMetrics(metrics Metrics) error {
+	if metrics.MType == HistogramTypeName {
+		if metrics.Histogram == nil {
+			return newMetricError(metrics.SeriesKey(), wrapError(ErrParse, "histogram should be not empty"))
+		}
+		return storage.UpdateHistogram(metrics.SeriesKey(), *metrics.Histogram)
+	}
+	return storage.GetUpdate(metrics.MType, metrics.SeriesKey(), metrics.GetStrValue())
 }
 
 func (storage *FileStorage) GetJSONUpdate(jsonDump []byte) error {
@@ -230,53 +573,51 @@ func (storage *FileStorage) GetJSONUpdate(jsonDump []byte) error {
 	log.Println(string(jsonDump))
 	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
 		log.Println(err)
-		return err
+		return wrapError(ErrParse, err.Error())
 	}
 	log.Println("StartUpdate" + metrics.String())
 
 	metricsHash, _ := metrics.CalcHash(storage.cfg.Key)
 	if storage.cfg.Key != "" && metricsHash != metrics.Hash {
 		log.Println("Wrong hash, " + metricsHash + " " + metrics.Hash)
-		return errors.New("wrong hash")
+		return newMetricError(metrics.SeriesKey(), ErrBadSignature)
+	}
+	if err := ValidateLabels(metrics.Labels); err != nil {
+		return err
 	}
 	log.Println("StartUpdate" + metrics.String())
 
-	return storage.GetUpdate(metrics.MType, metrics.ID, metrics.GetStrValue())
+	return storage.updateMetrics(metrics)
 }
 
+// GetJSONArray applies a batch in a single receive and returns the result of every metric.
 func (storage *FileStorage) GetJSONArray(jsonDump []byte) ([]byte, error) {
-	metricsArray := []Metrics{}
-
 	log.Println(string(jsonDump))
-	if err := json.Unmarshal(jsonDump, &metricsArray); err != nil {
+	metricsArray, err := storage.cfg.parseBatch(jsonDump)
+	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
-	for _, el := range metricsArray {
-		metricsHash, _ := el.CalcHash(storage.cfg.Key)
-		if storage.cfg.Key != "" && metricsHash != el.Hash {
-			log.Println("Wrong hash, " + metricsHash + " " + el.Hash)
-			return nil, errors.New("wrong hash")
-		}
-	}
-
-	for _, el := range metricsArray {
-		_ = storage.GetUpdate(el.MType, el.ID, el.GetStrValue())
+	responceChan := make(chan BatchDataResponce, 1)
+	storage.BatchUpdateChan <- BatchDataUpdate{metricsArray, storage.cfg.atomicBatch(), responceChan}
+	responce := <-responceChan
+	if responce.Err != nil {
+		return nil, responce.Err
 	}
-	return metricsArray[0].MarshalJSON()
+	return json.Marshal(responce.Results)
 }
 
 func (storage *FileStorage) GetJSONValue(jsonDump []byte) ([]byte, error) {
 	metrics := Metrics{}
 	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
 		log.Println(err)
-		return jsonDump, err
+		return jsonDump, wrapError(ErrParse, err.Error())
 	}
 
 	switch metrics.MType {
 	case GaugeTypeName:
-		value, err := storage.GetGaugeValue(metrics.ID)
+		value, err := storage.GetGaugeValue(metrics.SeriesKey())
 		if err != nil {
 			return jsonDump, err
 		}
@@ -284,27 +625,36 @@ func (storage *FileStorage) GetJSONValue(jsonDump []byte) ([]byte, error) {
 		metrics.Delta = 0
 
 	case CounterTypeName:
-		value, err := storage.GetCounterValue(metrics.ID)
+		value, err := storage.GetCounterValue(metrics.SeriesKey())
 		if err != nil {
 			return jsonDump, err
 		}
 		metrics.Delta = value
 		metrics.Value = 0
+
+	case HistogramTypeName:
+		value, err := storage.GetHistogramValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Histogram = &value
+		metrics.Delta = 0
+		metrics.Value = 0
 	default:
-		return jsonDump, errors.New("Wrong MType: " + metrics.MType)
+		return jsonDump, newMetricError(metrics.SeriesKey(), wrapError(ErrBadType, metrics.MType))
 	}
 
 	metrics.Hash, _ = metrics.CalcHash(storage.cfg.Key)
 	res, err := metrics.MarshalJSON()
 	if err != nil {
-		return jsonDump, errors.New("error on encoding json")
+		return jsonDump, err
 	}
 	return res, nil
 }
 
 func (storage *FileStorage) GetGaugeValue(metricName string) (float64, error) {
 	if metricName == "" {
-		return 0, errors.New("DataStorage: GetGaugeValue: metricName should be not empty")
+		return 0, wrapError(ErrParse, "metricName should be not empty")
 	}
 	responceChan := make(chan GasugeDataResponce, 1)
 	storage.GaugeRequestChan <- GaugeDataRequest{metricName, responceChan}
@@ -313,13 +663,13 @@ func (storage *FileStorage) GetGaugeValue(metricName string) (float64, error) {
 	if responce.Success {
 		return responce.Value, nil
 	} else {
-		return 0, errors.New("DataStorage: GetGaugeValue: some error")
+		return 0, newMetricError(metricName, ErrNotFound)
 	}
 }
 
-func (storage *FileStorage) GetCounterValue(metricName string) (uint64, error) {
+func (storage *FileStorage) GetCounterValue(metricName string) (int64, error) {
 	if metricName == "" {
-		return 0, errors.New("DataStorage: GetCounterValue: metricName should be not empty")
+		return 0, wrapError(ErrParse, "metricName should be not empty")
 	}
 	responceChan := make(chan CounterDataResponce, 1)
 	storage.CounterRequestChan <- CounterDataRequest{metricName, responceChan}
@@ -328,11 +678,26 @@ func (storage *FileStorage) GetCounterValue(metricName string) (uint64, error) {
 	if responce.Success {
 		return responce.Value, nil
 	} else {
-		return 0, errors.New("DataStorage: GetCounterValue: some error")
+		return 0, newMetricError(metricName, ErrNotFound)
+	}
+}
+
+func (storage *FileStorage) GetHistogramValue(metricName string) (Histogram, error) {
+	if metricName == "" {
+		return Histogram{}, wrapError(ErrParse, "metricName should be not empty")
+	}
+	responceChan := make(chan HistogramDataResponce, 1)
+	storage.HistogramRequestChan <- HistogramDataRequest{metricName, responceChan}
+
+	responce := <-responceChan
+	if responce.Success {
+		return responce.Value, nil
+	} else {
+		return Histogram{}, newMetricError(metricName, ErrNotFound)
 	}
 }
 
-func (storage *FileStorage) GetStats() (map[string]float64, map[string]uint64, error) {
+func (storage *FileStorage) GetStats() (map[string]float64, map[string]int64, error) {
 	responceChan := make(chan CollectedDataResponce, 1)
 	storage.RequestChan <- CollectedDataRequest{responceChan}
 	responce := <-responceChan
@@ -340,6 +705,33 @@ func (storage *FileStorage) GetStats() (map[string]float64, map[string]uint64, e
 	if responce.Success {
 		return responce.GaugeData, responce.CounterData, nil
 	} else {
-		return nil, nil, errors.New("DataStorage: GetStats: some error")
+		return nil, nil, ErrUnavailable
+	}
+}
+
+func (storage *FileStorage) GetHistory(metricType string, metricName string, from time.Time, to time.Time) ([]HistorySample, error) {
+	if metricName == "" {
+		return nil, wrapError(ErrParse, "metricName should be not empty")
+	}
+	if metricType != GaugeTypeName && metricType != CounterTypeName {
+		return nil, newMetricError(metricName, wrapError(ErrBadType, metricType+" has no history"))
+	}
+	responceChan := make(chan HistoryDataResponce, 1)
+	storage.HistoryRequestChan <- HistoryDataRequest{metricType, metricName, from, to, responceChan}
+
+	responce := <-responceChan
+	if responce.Success {
+		return responce.Samples, nil
+	} else {
+		return nil, newMetricError(metricName, ErrUnavailable)
+	}
+}
+
+func (storage *FileStorage) GetStatsFiltered(filter StatsFilter) (map[string]float64, map[string]int64, error) {
+	gaugeData, counterData, err := storage.GetStats()
+	if err != nil {
+		return nil, nil, err
 	}
+	gaugeData, counterData = filterStats(gaugeData, counterData, filter)
+	return gaugeData, counterData, nil
 }
@@ -2,7 +2,10 @@ package datastorage
 
 import (
 	"context"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -41,3 +44,161 @@ func TestGetUpdateErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestFileStorageHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := NewFileStorage(StorageConfig{HistoryLimit: 3})
+	go storage.RunReciver(ctx)
+
+	start := time.Now()
+	assert.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1.5"))
+	assert.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "2.5"))
+	assert.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	assert.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+
+	samples, err := storage.GetHistory(GaugeTypeName, "HeapAlloc", start, time.Now())
+	assert.NoError(t, err)
+	if assert.Len(t, samples, 2) {
+		assert.Equal(t, 1.5, samples[0].Value)
+		assert.Equal(t, 2.5, samples[1].Value)
+		assert.False(t, samples[1].Timestamp.Before(samples[0].Timestamp))
+	}
+
+	samples, err = storage.GetHistory(CounterTypeName, "PollCount", start, time.Now())
+	assert.NoError(t, err)
+	if assert.Len(t, samples, 2) {
+		assert.Equal(t, int64(2), samples[0].Delta)
+		assert.Equal(t, int64(5), samples[1].Delta)
+	}
+
+	samples, err = storage.GetHistory(GaugeTypeName, "HeapAlloc", time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", strconv.Itoa(i)))
+	}
+	samples, err = storage.GetHistory(GaugeTypeName, "HeapAlloc", start, time.Now())
+	assert.NoError(t, err)
+	if assert.Len(t, samples, 3) {
+		assert.Equal(t, float64(4), samples[2].Value)
+	}
+
+	_, err = storage.GetHistory("histogram", "HeapAlloc", start, time.Now())
+	assert.Error(t, err)
+}
+
+func TestFileStorageGetStatsFiltered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := NewFileStorage(StorageConfig{})
+	go storage.RunReciver(ctx)
+
+	assert.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1"))
+	assert.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapIdle", "2"))
+	assert.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+
+	gaugeData, counterData, err := storage.GetStatsFiltered(StatsFilter{Prefix: "Heap", Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"HeapIdle": 2}, gaugeData)
+	assert.Empty(t, counterData)
+
+	gaugeData, counterData, err = storage.GetStatsFiltered(StatsFilter{Offset: 1, Limit: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"HeapIdle": 2}, gaugeData)
+	assert.Equal(t, map[string]int64{"PollCount": 3}, counterData)
+}
+
+func TestFileStorageGetStatsIsSnapshot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := NewFileStorage(StorageConfig{})
+	go storage.RunReciver(ctx)
+
+	assert.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1"))
+	gaugeData, counterData, err := storage.GetStats()
+	assert.NoError(t, err)
+
+	gaugeData["HeapAlloc"] = 5
+	counterData["PollCount"] = 5
+	assert.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapIdle", "2"))
+	assert.Equal(t, map[string]float64{"HeapAlloc": 5}, gaugeData)
+
+	value, err := storage.GetGaugeValue("HeapAlloc")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, value)
+	_, err = storage.GetCounterValue("PollCount")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// TestFileStorageConcurrentGetStats reads the stats while other goroutines update them,
+// run it with -race. Every batch sets Left and Right to the same value, so a snapshot
+// which holds a half applied batch differs in them.
+func TestFileStorageConcurrentGetStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := NewFileStorage(StorageConfig{})
+	go storage.RunReciver(ctx)
+
+	const writers, updates = 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				value := strconv.Itoa(w*updates + i)
+				_, err := storage.GetJSONArray([]byte(`[{"id":"Left","type":"gauge","value":` + value + `},{"id":"Right","type":"gauge","value":` + value + `}]`))
+				assert.NoError(t, err)
+				assert.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "1"))
+				assert.NoError(t, storage.GetUpdate(GaugeTypeName, "Gauge"+strconv.Itoa(i), value))
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	readErrs := make(chan string, writers)
+	for r := 0; r < writers; r++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					readErrs <- ""
+					return
+				default:
+				}
+				gaugeData, counterData, err := storage.GetStats()
+				if err != nil {
+					readErrs <- err.Error()
+					return
+				}
+				if gaugeData["Left"] != gaugeData["Right"] {
+					readErrs <- "half applied batch: " + strconv.FormatFloat(gaugeData["Left"], 'f', -1, 64) + " " + strconv.FormatFloat(gaugeData["Right"], 'f', -1, 64)
+					return
+				}
+				// iterating the maps is what races with the receiver when they are not copies
+				for name := range gaugeData {
+					gaugeData[name]++
+				}
+				for name := range counterData {
+					counterData[name]++
+				}
+				if _, _, err := storage.GetStatsFiltered(StatsFilter{Prefix: "Gauge", Limit: 10}); err != nil {
+					readErrs <- err.Error()
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	for r := 0; r < writers; r++ {
+		assert.Empty(t, <-readErrs)
+	}
+
+	counterValue, err := storage.GetCounterValue("PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(writers*updates), counterValue)
+}
@@ -0,0 +1,62 @@
+package datastorage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// The storage errors wrap one of these, so the callers check them with errors.Is.
+var (
+	ErrNotFound     = errors.New("DataStorage: metric not found")
+	ErrBadType      = errors.New("DataStorage: wrong metric type")
+	ErrParse        = errors.New("DataStorage: parse error")
+	ErrBadSignature = errors.New("DataStorage: wrong hash")
+	ErrInvalidValue = errors.New("DataStorage: invalid value")
+	ErrUnavailable  = errors.New("DataStorage: storage unavailable")
+)
+
+// MetricError is an error about one metric, Metric is its series key.
+type MetricError struct {
+	Metric string
+	Err    error
+}
+
+func (err *MetricError) Error() string {
+	return err.Err.Error() + ": " + err.Metric
+}
+
+func (err *MetricError) Unwrap() error {
+	return err.Err
+}
+
+func newMetricError(metric string, err error) error {
+	return &MetricError{Metric: metric, Err: err}
+}
+
+// wrapError adds the details to the sentinel, like "DataStorage: parse error: <details>".
+func wrapError(sentinel error, details string) error {
+	return fmt.Errorf("%w: %s", sentinel, details)
+}
+
+// storageError returns the storage errors as is and marks any other failure, like a lost
+// database connection, as ErrUnavailable.
+func storageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, sentinel := range []error{ErrNotFound, ErrBadType, ErrParse, ErrBadSignature, ErrInvalidValue, ErrUnavailable, ErrBatchTooLarge} {
+		if errors.Is(err, sentinel) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
+
+// notFoundError turns sql.ErrNoRows into ErrNotFound.
+func notFoundError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return storageError(err)
+}
@@ -0,0 +1,40 @@
+package datastorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fileStorage := NewFileStorage(StorageConfig{})
+	go fileStorage.RunReciver(ctx)
+
+	for name, storage := range map[string]interface {
+		GetUpdate(string, string, string) error
+		GetGaugeValue(string) (float64, error)
+		GetJSONValue([]byte) ([]byte, error)
+	}{"file": fileStorage, "sql": newTestSQLStorage(t)} {
+		t.Run(name, func(t *testing.T) {
+			_, err := storage.GetGaugeValue("Missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+			var metricErr *MetricError
+			if assert.True(t, errors.As(err, &metricErr)) {
+				assert.Equal(t, "Missing", metricErr.Metric)
+			}
+
+			assert.ErrorIs(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "none"), ErrParse)
+			assert.ErrorIs(t, storage.GetUpdate("summary", "HeapAlloc", "1"), ErrBadType)
+			assert.ErrorIs(t, storage.GetUpdate(GaugeTypeName, "", "1"), ErrParse)
+			_, err = storage.GetJSONValue([]byte(`{"id":`))
+			assert.ErrorIs(t, err, ErrParse)
+		})
+	}
+
+	assert.ErrorIs(t, storageError(errors.New("connection refused")), ErrUnavailable)
+	assert.Equal(t, ErrCounterOverflow, storageError(ErrCounterOverflow))
+}
@@ -0,0 +1,16 @@
+package datastorage
+
+import (
+	"math"
+)
+
+// Gauges are float64, but NaN and the infinities are rejected: neither the JSON snapshot nor
+// the write-ahead log can encode them, so a stored one would fail every later write.
+var ErrNotFinite = wrapError(ErrInvalidValue, "value should be finite")
+
+func checkFinite(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return ErrNotFinite
+	}
+	return nil
+}
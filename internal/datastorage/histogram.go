@@ -0,0 +1,137 @@
+package datastorage
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultHistogramBounds are the bucket upper bounds, in seconds, a histogram gets on its first
+// single value update.
+var DefaultHistogramBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in buckets: Counts[i] is the number of values <= Bounds[i]
+// and greater than the previous bound, the last count is the +Inf bucket.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// hashBuckets writes the bounds and the bucket counts for the metric hash as "bounds|counts",
+// every list comma separated, the bounds in the shortest exact form.
+func (histogram Histogram) hashBuckets() string {
+	bounds := make([]string, len(histogram.Bounds))
+	for i, bound := range histogram.Bounds {
+		bounds[i] = strconv.FormatFloat(bound, 'g', -1, 64)
+	}
+	counts := make([]string, len(histogram.Counts))
+	for i, count := range histogram.Counts {
+		counts[i] = strconv.FormatUint(count, 10)
+	}
+	return strings.Join(bounds, ",") + "|" + strings.Join(counts, ",")
+}
+
+func NewHistogram(bounds []float64) Histogram {
+	return Histogram{
+		Bounds: append([]float64{}, bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (histogram *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(histogram.Bounds, value)
+	histogram.Counts[i]++
+	histogram.Sum += value
+	histogram.Count++
+}
+
+func (histogram *Histogram) Validate() error {
+	if len(histogram.Counts) != len(histogram.Bounds)+1 {
+		return wrapError(ErrInvalidValue, "histogram counts should have one bucket more than bounds")
+	}
+	for i, bound := range histogram.Bounds {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return wrapError(ErrInvalidValue, "histogram bounds should be finite")
+		}
+		if i > 0 && bound <= histogram.Bounds[i-1] {
+			return wrapError(ErrInvalidValue, "histogram bounds should be increasing")
+		}
+	}
+	var count uint64
+	for _, bucketCount := range histogram.Counts {
+		count += bucketCount
+	}
+	if count != histogram.Count {
+		return wrapError(ErrInvalidValue, "histogram count should be the sum of the bucket counts")
+	}
+	if math.IsNaN(histogram.Sum) || math.IsInf(histogram.Sum, 0) {
+		return wrapError(ErrInvalidValue, "histogram sum should be finite")
+	}
+	return nil
+}
+
+func (histogram *Histogram) sameBounds(other Histogram) bool {
+	if len(histogram.Bounds) != len(other.Bounds) {
+		return false
+	}
+	for i := range histogram.Bounds {
+		if histogram.Bounds[i] != other.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge adds the observations of other, both histograms should have the same bounds.
+func (histogram *Histogram) Merge(other Histogram) error {
+	if !histogram.sameBounds(other) {
+		return wrapError(ErrInvalidValue, "histogram bounds mismatch")
+	}
+	for i := range histogram.Counts {
+		histogram.Counts[i] += other.Counts[i]
+	}
+	histogram.Sum += other.Sum
+	histogram.Count += other.Count
+	return nil
+}
+
+func (histogram Histogram) Copy() Histogram {
+	histogram.Bounds = append([]float64{}, histogram.Bounds...)
+	histogram.Counts = append([]uint64{}, histogram.Counts...)
+	return histogram
+}
+
+// mergeHistogramUpdate returns the stored histogram with the update applied. A nil delta is a
+// single observation, counted with the stored bounds or DefaultHistogramBounds for a new histogram.
+// A non-finite observation or merged sum is rejected, it couldn't be encoded in JSON.
+func mergeHistogramUpdate(stored *Histogram, delta *Histogram, observation float64) (Histogram, error) {
+	if delta == nil {
+		if math.IsNaN(observation) || math.IsInf(observation, 0) {
+			return Histogram{}, wrapError(ErrInvalidValue, "histogram observation should be finite")
+		}
+		bounds := DefaultHistogramBounds
+		if stored != nil {
+			bounds = stored.Bounds
+		}
+		single := NewHistogram(bounds)
+		single.Observe(observation)
+		delta = &single
+	}
+	if err := delta.Validate(); err != nil {
+		return Histogram{}, err
+	}
+	if stored == nil {
+		return delta.Copy(), nil
+	}
+	merged := stored.Copy()
+	if err := merged.Merge(*delta); err != nil {
+		return Histogram{}, err
+	}
+	if math.IsInf(merged.Sum, 0) {
+		return Histogram{}, wrapError(ErrInvalidValue, "histogram sum should be finite")
+	}
+	return merged, nil
+}
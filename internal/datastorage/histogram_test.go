@@ -0,0 +1,145 @@
+package datastorage
+
+import (
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramObserveAndMerge(t *testing.T) {
+	histogram := NewHistogram([]float64{1, 2})
+	for _, value := range []float64{0.5, 1, 1.5, 3} {
+		histogram.Observe(value)
+	}
+	assert.Equal(t, []uint64{2, 1, 1}, histogram.Counts)
+	assert.Equal(t, uint64(4), histogram.Count)
+	assert.Equal(t, 6.0, histogram.Sum)
+	require.NoError(t, histogram.Validate())
+
+	other := NewHistogram([]float64{1, 2})
+	other.Observe(10)
+	require.NoError(t, histogram.Merge(other))
+	assert.Equal(t, []uint64{2, 1, 2}, histogram.Counts)
+	assert.Equal(t, uint64(5), histogram.Count)
+
+	assert.Error(t, histogram.Merge(NewHistogram([]float64{1, 3})))
+}
+
+func TestHistogramValidate(t *testing.T) {
+	tests := []struct {
+		testName  string
+		histogram Histogram
+	}{
+		{testName: "counts_length", histogram: Histogram{Bounds: []float64{1}, Counts: []uint64{1}, Count: 1}},
+		{testName: "unsorted_bounds", histogram: Histogram{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}}},
+		{testName: "duplicate_bounds", histogram: Histogram{Bounds: []float64{1, 1}, Counts: []uint64{0, 0, 0}}},
+		{testName: "wrong_count", histogram: Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 3}},
+		{testName: "nan_sum", histogram: Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Count: 1, Sum: math.NaN()}},
+		{testName: "inf_sum", histogram: Histogram{Bounds: []float64{1}, Counts: []uint64{0, 1}, Count: 1, Sum: math.Inf(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			assert.ErrorIs(t, tt.histogram.Validate(), ErrInvalidValue)
+		})
+	}
+}
+
+func TestHistogramNonFinite(t *testing.T) {
+	for name, storage := range newConformanceStorages(t, StorageConfig{}) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, storage.GetUpdate(HistogramTypeName, "Latency", "0.5"))
+			for _, value := range []string{"NaN", "+Inf", "-Inf"} {
+				assert.ErrorIs(t, storage.GetUpdate(HistogramTypeName, "Latency", value), ErrInvalidValue, value)
+			}
+			huge := NewHistogram(DefaultHistogramBounds)
+			huge.Observe(math.MaxFloat64)
+			body, err := json.Marshal(Metrics{ID: "Latency", MType: HistogramTypeName, Histogram: &huge})
+			require.NoError(t, err)
+			require.NoError(t, storage.GetJSONUpdate(body))
+			assert.ErrorIs(t, storage.GetJSONUpdate(body), ErrInvalidValue, "the merged sum overflows")
+
+			require.NoError(t, storage.GetUpdate(HistogramTypeName, "Latency", "1.5"))
+			histogram, err := storage.GetHistogramValue("Latency")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(3), histogram.Count)
+			assert.Equal(t, 2+math.MaxFloat64, histogram.Sum)
+		})
+	}
+}
+
+func TestHistogramHashCoversBuckets(t *testing.T) {
+	histogram := NewHistogram([]float64{1, 2})
+	histogram.Observe(0.5)
+	histogram.Observe(1.5)
+	signed := Metrics{ID: "ReportLatency", MType: HistogramTypeName, Histogram: &histogram}
+	var err error
+	signed.Hash, err = signed.CalcHash("secret")
+	require.NoError(t, err)
+
+	movedCount := histogram.Copy()
+	movedCount.Counts = []uint64{0, 2, 0}
+	movedBound := histogram.Copy()
+	movedBound.Bounds = []float64{1, 3}
+
+	for name, storage := range newConformanceStorages(t, StorageConfig{Key: "secret"}) {
+		t.Run(name, func(t *testing.T) {
+			body, err := json.Marshal(&signed)
+			require.NoError(t, err)
+			require.NoError(t, storage.GetJSONUpdate(body))
+
+			for _, tampered := range []Histogram{movedCount, movedBound} {
+				metrics := signed
+				metrics.Histogram = &tampered
+				body, err := json.Marshal(&metrics)
+				require.NoError(t, err)
+				assert.ErrorIs(t, storage.GetJSONUpdate(body), ErrBadSignature)
+			}
+
+			stored, err := storage.GetHistogramValue("ReportLatency")
+			require.NoError(t, err)
+			assert.Equal(t, []uint64{1, 1, 0}, stored.Counts)
+		})
+	}
+}
+
+func TestFileStorageHistogram(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.json")
+	storage, cancel := newWALTestStorage(t, storeFile)
+
+	require.NoError(t, storage.GetUpdate(HistogramTypeName, "ReportLatency", "0.3"))
+	require.NoError(t, storage.GetJSONUpdate([]byte(`{"id":"ReportLatency","type":"histogram","histogram":`+
+		`{"bounds":[0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10],"counts":[0,1,0,0,0,0,0,0,0,0,0,1],"sum":20.007,"count":2}}`)))
+	assert.Error(t, storage.UpdateHistogram("ReportLatency", NewHistogram([]float64{1})))
+	assert.Error(t, storage.GetJSONUpdate([]byte(`{"id":"ReportLatency","type":"histogram"}`)))
+
+	histogram, err := storage.GetHistogramValue("ReportLatency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), histogram.Count)
+	assert.Equal(t, []uint64{0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}, histogram.Counts)
+	_, err = storage.GetHistogramValue("Unknown")
+	assert.Error(t, err)
+
+	body, err := storage.GetJSONValue([]byte(`{"id":"ReportLatency","type":"histogram"}`))
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"count":3`)
+	cancel()
+
+	// restored from the log, then from the snapshot written on open
+	for i := 0; i < 2; i++ {
+		restored, cancel := newWALTestStorage(t, storeFile)
+		histogram, err = restored.GetHistogramValue("ReportLatency")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), histogram.Count)
+		assert.InDelta(t, 20.307, histogram.Sum, 1e-9)
+		cancel()
+	}
+
+	samples, err := storage.GetHistory(HistogramTypeName, "ReportLatency", time.Unix(0, 0), time.Now())
+	assert.Error(t, err)
+	assert.Empty(t, samples)
+}
@@ -0,0 +1,53 @@
+package datastorage
+
+import (
+	"time"
+)
+
+const DefaultHistoryLimit = 10000
+
+// HistorySample is the value stored for a metric right after an accepted update:
+// the gauge value for gauges and the accumulated total for counters.
+type HistorySample struct {
+	ID        string    `json:"id"`
+	MType     string    `json:"type"`
+	Delta     int64     `json:"delta,omitempty"`
+	Value     float64   `json:"value,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type HistoryDataRequest struct {
+	MType    string
+	Name     string
+	From     time.Time
+	To       time.Time
+	Responce chan HistoryDataResponce
+}
+
+type HistoryDataResponce struct {
+	Samples []HistorySample
+	Success bool
+}
+
+func historyKey(metricType string, metricName string) string {
+	return metricType + ":" + metricName
+}
+
+func appendHistory(history []HistorySample, sample HistorySample, limit int) []HistorySample {
+	history = append(history, sample)
+	if limit > 0 && len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	return history
+}
+
+func filterHistory(history []HistorySample, from time.Time, to time.Time) []HistorySample {
+	samples := []HistorySample{}
+	for _, sample := range history {
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
@@ -0,0 +1,97 @@
+package datastorage
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SeriesKey identifies a labeled series: name{a="1",b="2"} with labels sorted by name and
+// values quoted, or just name without labels. Both storages key the metrics by it.
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	labelNames := make([]string, 0, len(labels))
+	for labelName := range labels {
+		labelNames = append(labelNames, labelName)
+	}
+	sort.Strings(labelNames)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, labelName := range labelNames {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labelName)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[labelName]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// ParseSeriesKey splits a SeriesKey back into the name and labels. A key which is not a canonical
+// labeled series, like an old metric with braces in the name, is returned as the name.
+func ParseSeriesKey(key string) (string, map[string]string) {
+	start := strings.IndexByte(key, '{')
+	if start <= 0 || !strings.HasSuffix(key, "}") {
+		return key, nil
+	}
+	labels := map[string]string{}
+	rest := key[start+1 : len(key)-1]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return key, nil
+		}
+		labelName := rest[:eq]
+		quoted, err := strconv.QuotedPrefix(rest[eq+1:])
+		if err != nil {
+			return key, nil
+		}
+		value, _ := strconv.Unquote(quoted)
+		labels[labelName] = value
+
+		rest = rest[eq+1+len(quoted):]
+		if rest != "" {
+			if rest[0] != ',' || len(rest) == 1 {
+				return key, nil
+			}
+			rest = rest[1:]
+		}
+	}
+	// only the canonical form is a series, so parsing and SeriesKey round trip
+	if ValidateLabels(labels) != nil || SeriesKey(key[:start], labels) != key {
+		return key, nil
+	}
+	return key[:start], labels
+}
+
+// ValidateLabels checks label names against the Prometheus charset [a-zA-Z_][a-zA-Z0-9_]*.
+func ValidateLabels(labels map[string]string) error {
+	for labelName := range labels {
+		if labelName == "" {
+			return wrapError(ErrParse, "label name should be not empty")
+		}
+		for i, r := range labelName {
+			valid := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || (i > 0 && r >= '0' && r <= '9')
+			if !valid {
+				return wrapError(ErrParse, "invalid label name "+labelName)
+			}
+		}
+	}
+	return nil
+}
+
+// MatchLabels reports whether labels carry every matcher with the same value.
+func MatchLabels(labels map[string]string, matchers map[string]string) bool {
+	for labelName, value := range matchers {
+		if labelValue, ok := labels[labelName]; !ok || labelValue != value {
+			return false
+		}
+	}
+	return true
+}
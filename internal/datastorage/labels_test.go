@@ -0,0 +1,97 @@
+package datastorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesKey(t *testing.T) {
+	tests := []struct {
+		testName string
+		name     string
+		labels   map[string]string
+		key      string
+	}{
+		{testName: "no_labels", name: "PollCount", key: "PollCount"},
+		{testName: "sorted", name: "cpu", labels: map[string]string{"host": "a", "core": "3"}, key: `cpu{core="3",host="a"}`},
+		{testName: "escaped", name: "cpu", labels: map[string]string{"path": "a\"b\\c,d=}"}, key: `cpu{path="a\"b\\c,d=}"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			key := SeriesKey(tt.name, tt.labels)
+			assert.Equal(t, tt.key, key)
+
+			name, labels := ParseSeriesKey(key)
+			assert.Equal(t, tt.name, name)
+			if len(tt.labels) > 0 {
+				assert.Equal(t, tt.labels, labels)
+			} else {
+				assert.Empty(t, labels)
+			}
+		})
+	}
+
+	for _, key := range []string{"a{}", "{a=\"1\"}", `a{b="1",}`, `a{b=1}`, `a{b="2",a="1"}`, `a{1b="1"}`} {
+		name, labels := ParseSeriesKey(key)
+		assert.Equal(t, key, name)
+		assert.Nil(t, labels)
+	}
+}
+
+func TestValidateAndMatchLabels(t *testing.T) {
+	assert.NoError(t, ValidateLabels(map[string]string{"core": "1", "_x9": ""}))
+	assert.Error(t, ValidateLabels(map[string]string{"": "1"}))
+	assert.Error(t, ValidateLabels(map[string]string{"9x": "1"}))
+	assert.Error(t, ValidateLabels(map[string]string{"a-b": "1"}))
+
+	labels := map[string]string{"core": "1", "host": "a"}
+	assert.True(t, MatchLabels(labels, nil))
+	assert.True(t, MatchLabels(labels, map[string]string{"core": "1"}))
+	assert.False(t, MatchLabels(labels, map[string]string{"core": "2"}))
+	assert.False(t, MatchLabels(labels, map[string]string{"zone": ""}))
+}
+
+func TestCalcHashCoversLabels(t *testing.T) {
+	metrics := Metrics{ID: "cpu", MType: GaugeTypeName, Value: 1}
+	plain, err := metrics.CalcHash("key")
+	require.NoError(t, err)
+
+	metrics.Labels = map[string]string{"core": "1"}
+	labeled, err := metrics.CalcHash("key")
+	require.NoError(t, err)
+	assert.NotEqual(t, plain, labeled)
+
+	metrics.Labels = map[string]string{"core": "2"}
+	other, err := metrics.CalcHash("key")
+	require.NoError(t, err)
+	assert.NotEqual(t, labeled, other)
+}
+
+func TestFileStorageLabels(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := NewFileStorage(StorageConfig{})
+	go storage.RunReciver(ctx)
+
+	_, err := storage.GetJSONArray([]byte(`[` +
+		`{"id":"cpu_utilization","type":"gauge","value":10,"labels":{"core":"1"}},` +
+		`{"id":"cpu_utilization","type":"gauge","value":20,"labels":{"core":"2"}},` +
+		`{"id":"cpu_utilization","type":"gauge","value":30}]`))
+	require.NoError(t, err)
+	assert.Error(t, storage.GetJSONUpdate([]byte(`{"id":"cpu_utilization","type":"gauge","value":1,"labels":{"bad-name":"1"}}`)))
+
+	body, err := storage.GetJSONValue([]byte(`{"id":"cpu_utilization","type":"gauge","labels":{"core":"2"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"cpu_utilization","type":"gauge","value":20,"labels":{"core":"2"}}`, string(body))
+
+	value, err := storage.GetGaugeValue("cpu_utilization")
+	require.NoError(t, err)
+	assert.Equal(t, 30.0, value)
+
+	gaugeData, _, err := storage.GetStatsFiltered(StatsFilter{Labels: map[string]string{"core": "1"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{`cpu_utilization{core="1"}`: 10}, gaugeData)
+}
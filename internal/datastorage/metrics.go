@@ -5,7 +5,6 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"strconv"
 )
@@ -13,9 +12,17 @@ import (
 type Metrics struct {
 	ID    string  `json:"id"`              // имя метрики
 	MType string  `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta uint64  `json:"delta,omitempty"` // значение метрики в случае передачи counter
+	Delta int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
 	Value float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
 	Hash  string  `json:"hash,omitempty"`  // значение хеш-функции
+
+	Histogram *Histogram        `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Labels    map[string]string `json:"labels,omitempty"`    // измерения метрики, входят в её ключ
+}
+
+// SeriesKey is the storage key of the metric, see SeriesKey.
+func (metrics *Metrics) SeriesKey() string {
+	return SeriesKey(metrics.ID, metrics.Labels)
 }
 
 func (metrics *Metrics) CalcHash(key string) (string, error) {
@@ -26,9 +33,15 @@ func (metrics *Metrics) CalcHash(key string) (string, error) {
 
 	switch metrics.MType {
 	case GaugeTypeName:
-		h.Write([]byte(fmt.Sprintf("%s:gauge:%f", metrics.ID, metrics.Value)))
+		h.Write([]byte(fmt.Sprintf("%s:gauge:%f", metrics.SeriesKey(), metrics.Value)))
 	case CounterTypeName:
-		h.Write([]byte(fmt.Sprintf("%s:counter:%d", metrics.ID, metrics.Delta)))
+		h.Write([]byte(fmt.Sprintf("%s:counter:%d", metrics.SeriesKey(), metrics.Delta)))
+	case HistogramTypeName:
+		if metrics.Histogram == nil {
+			return "", wrapError(ErrParse, "empty histogram")
+		}
+		h.Write([]byte(fmt.Sprintf("%s:histogram:%d:%f:%s", metrics.SeriesKey(), metrics.Histogram.Count, metrics.Histogram.Sum,
+			metrics.Histogram.hashBuckets())))
 	}
 
 	return hex.EncodeToString(h.Sum(nil)), nil
@@ -39,7 +52,7 @@ func (metrics *Metrics) GetStrValue() string {
 	case GaugeTypeName:
 		return strconv.FormatFloat(metrics.Value, 'g', 20, 64)
 	case CounterTypeName:
-		return strconv.FormatUint(metrics.Delta, 10)
+		return strconv.FormatInt(metrics.Delta, 10)
 	default:
 		return ""
 	}
@@ -49,35 +62,57 @@ func (metrics *Metrics) MarshalJSON() ([]byte, error) {
 	switch metrics.MType {
 	case CounterTypeName:
 		aliasValue := &struct {
-			ID    string `json:"id"`   // имя метрики
-			MType string `json:"type"` // параметр, принимающий значение gauge или counter
-			Delta uint64 `json:"delta"`
-			Hash  string `json:"hash,omitempty"`
+			ID     string            `json:"id"`   // имя метрики
+			MType  string            `json:"type"` // параметр, принимающий значение gauge или counter
+			Delta  int64             `json:"delta"`
+			Hash   string            `json:"hash,omitempty"`
+			Labels map[string]string `json:"labels,omitempty"`
 		}{
-			ID:    metrics.ID,
-			MType: metrics.MType,
-			Delta: metrics.Delta,
-			Hash:  metrics.Hash,
+			ID:     metrics.ID,
+			MType:  metrics.MType,
+			Delta:  metrics.Delta,
+			Hash:   metrics.Hash,
+			Labels: metrics.Labels,
 		}
 		return json.Marshal(aliasValue)
 	case GaugeTypeName:
 		aliasValue := &struct {
-			ID    string  `json:"id"`    // имя метрики
-			MType string  `json:"type"`  // параметр, принимающий значение gauge или counter
-			Value float64 `json:"value"` // значение метрики в случае передачи gauge
-			Hash  string  `json:"hash,omitempty"`
+			ID     string            `json:"id"`    // имя метрики
+			MType  string            `json:"type"`  // параметр, принимающий значение gauge или counter
+			Value  float64           `json:"value"` // значение метрики в случае передачи gauge
+			Hash   string            `json:"hash,omitempty"`
+			Labels map[string]string `json:"labels,omitempty"`
+		}{
+			ID:     metrics.ID,
+			MType:  metrics.MType,
+			Value:  metrics.Value,
+			Hash:   metrics.Hash,
+			Labels: metrics.Labels,
+		}
+		return json.Marshal(aliasValue)
+	case HistogramTypeName:
+		if metrics.Histogram == nil {
+			return nil, wrapError(ErrParse, "empty histogram")
+		}
+		aliasValue := &struct {
+			ID        string            `json:"id"`   // имя метрики
+			MType     string            `json:"type"` // параметр, принимающий значение gauge, counter или histogram
+			Histogram *Histogram        `json:"histogram"`
+			Hash      string            `json:"hash,omitempty"`
+			Labels    map[string]string `json:"labels,omitempty"`
 		}{
-			ID:    metrics.ID,
-			MType: metrics.MType,
-			Value: metrics.Value,
-			Hash:  metrics.Hash,
+			ID:        metrics.ID,
+			MType:     metrics.MType,
+			Histogram: metrics.Histogram,
+			Hash:      metrics.Hash,
+			Labels:    metrics.Labels,
 		}
 		return json.Marshal(aliasValue)
 	default:
-		return nil, errors.New("wrong MType")
+		return nil, wrapError(ErrBadType, metrics.MType)
 	}
 }
 
 func (metrics Metrics) String() string {
-	return fmt.Sprintf("ID:%v MType:%v Value:%f Delta:%d", metrics.ID, metrics.MType, metrics.Value, metrics.Delta)
+	return fmt.Sprintf("ID:%v MType:%v Value:%f Delta:%d", metrics.SeriesKey(), metrics.MType, metrics.Value, metrics.Delta)
 }
@@ -0,0 +1,229 @@
+package datastorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Migration is one schema step; Up and Down hold the statements per sql dialect ("postgres", "sqlite3").
+type Migration struct {
+	Version int
+	Name    string
+	Up      map[string][]string
+	Down    map[string][]string
+}
+
+type MigrationState struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+func (state MigrationState) String() string {
+	if state.Applied {
+		return fmt.Sprintf("%04d %s: applied at %s", state.Version, state.Name, state.AppliedAt.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%04d %s: pending", state.Version, state.Name)
+}
+
+func bothDialects(statements ...string) map[string][]string {
+	return map[string][]string{
+		"postgres": statements,
+		"sqlite3":  statements,
+	}
+}
+
+// Migrations are applied in order of Version; never edit an applied one, append a new one instead.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_statistics5",
+		Up: bothDialects(
+			"CREATE TABLE IF NOT EXISTS statistics5 ( ID text, MType text, Delta bigserial, Value double precision, CONSTRAINT id_pk PRIMARY KEY (ID), CONSTRAINT id_type_uq UNIQUE (ID, MType));",
+		),
+		Down: bothDialects(
+			"DROP TABLE IF EXISTS statistics5;",
+		),
+	},
+	{
+		Version: 2,
+		Name:    "create_history",
+		Up: bothDialects(
+			"CREATE TABLE IF NOT EXISTS history ( ID text, MType text, Delta bigint, Value double precision, TS bigint);",
+			"CREATE INDEX IF NOT EXISTS history_id_type_ts ON history (ID, MType, TS);",
+		),
+		Down: bothDialects(
+			"DROP INDEX IF EXISTS history_id_type_ts;",
+			"DROP TABLE IF EXISTS history;",
+		),
+	},
+	{
+		Version: 3,
+		Name:    "move_statistics5_to_metrics",
+		Up: bothDialects(
+			"CREATE TABLE metrics ( ID text NOT NULL, MType text NOT NULL, Delta bigint NOT NULL DEFAULT 0, Value double precision NOT NULL DEFAULT 0, CONSTRAINT metrics_pk PRIMARY KEY (ID, MType));",
+			"INSERT INTO metrics (ID, MType, Delta, Value) SELECT ID, MType, COALESCE(Delta, 0), COALESCE(Value, 0) FROM statistics5 WHERE ID IS NOT NULL AND MType IS NOT NULL;",
+			"DROP TABLE statistics5;",
+		),
+		// statistics5 is keyed by ID alone, so only one of a gauge and a counter sharing a name survives the rollback.
+		Down: bothDialects(
+			"CREATE TABLE statistics5 ( ID text, MType text, Delta bigserial, Value double precision, CONSTRAINT id_pk PRIMARY KEY (ID), CONSTRAINT id_type_uq UNIQUE (ID, MType));",
+			"INSERT INTO statistics5 (ID, MType, Delta, Value) SELECT ID, MType, Delta, Value FROM metrics WHERE true ORDER BY ID, MType ON CONFLICT DO NOTHING;",
+			"DROP TABLE metrics;",
+		),
+	},
+	{
+		Version: 4,
+		Name:    "create_histograms",
+		// Bounds and Counts are json arrays, an empty Bounds marks a row without observations yet.
+		Up: bothDialects(
+			"CREATE TABLE histograms ( ID text NOT NULL, Bounds text NOT NULL DEFAULT '', Counts text NOT NULL DEFAULT '', Sum double precision NOT NULL DEFAULT 0, Count bigint NOT NULL DEFAULT 0, CONSTRAINT histograms_pk PRIMARY KEY (ID));",
+		),
+		Down: bothDialects(
+			"DROP TABLE histograms;",
+		),
+	},
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	DBType     string
+	Migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, dbType string) *Migrator {
+	return &Migrator{DB: db, DBType: dbType, Migrations: Migrations}
+}
+
+func (migrator *Migrator) placeholder(n int) string {
+	if migrator.DBType == "postgres" {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+func (migrator *Migrator) ensureVersionTable(ctx context.Context) error {
+	_, err := migrator.DB.ExecContext(ctx,
+		"CREATE TABLE IF NOT EXISTS schema_migrations ( Version integer PRIMARY KEY, Name text NOT NULL, AppliedAt bigint NOT NULL);")
+	return err
+}
+
+func (migrator *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	if err := migrator.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := migrator.DB.QueryContext(ctx, "SELECT Version, AppliedAt FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(0, appliedAt)
+	}
+	return applied, rows.Err()
+}
+
+func (migrator *Migrator) Status(ctx context.Context) ([]MigrationState, error) {
+	applied, err := migrator.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]MigrationState, 0, len(migrator.Migrations))
+	for _, migration := range migrator.Migrations {
+		appliedAt, ok := applied[migration.Version]
+		states = append(states, MigrationState{migration.Version, migration.Name, ok, appliedAt})
+	}
+	return states, nil
+}
+
+// Version returns the latest applied migration version, 0 for an empty database.
+func (migrator *Migrator) Version(ctx context.Context) (int, error) {
+	applied, err := migrator.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+func (migrator *Migrator) run(ctx context.Context, migration Migration, statements []string, up bool) error {
+	if statements == nil {
+		return errors.New("DataStorage: Migrator: no statements for dialect " + migrator.DBType)
+	}
+	tx, err := migrator.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("DataStorage: Migrator: migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	if up {
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf("INSERT INTO schema_migrations VALUES(%s, %s, %s);", migrator.placeholder(1), migrator.placeholder(2), migrator.placeholder(3)),
+			migration.Version, migration.Name, time.Now().UnixNano())
+	} else {
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf("DELETE FROM schema_migrations WHERE Version = %s;", migrator.placeholder(1)),
+			migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Up applies every pending migration in order.
+func (migrator *Migrator) Up(ctx context.Context) error {
+	applied, err := migrator.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrator.Migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		log.Printf("Apply migration %d %s\n", migration.Version, migration.Name)
+		if err := migrator.run(ctx, migration, migration.Up[migrator.DBType], true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down reverts the latest applied migration.
+func (migrator *Migrator) Down(ctx context.Context) error {
+	applied, err := migrator.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for i := len(migrator.Migrations) - 1; i >= 0; i-- {
+		migration := migrator.Migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		log.Printf("Revert migration %d %s\n", migration.Version, migration.Name)
+		return migrator.run(ctx, migration, migration.Down[migrator.DBType], false)
+	}
+	return errors.New("DataStorage: Migrator: no applied migrations")
+}
@@ -0,0 +1,89 @@
+package datastorage
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	ctx := context.Background()
+	migrator := NewMigrator(openTestDB(t), "sqlite3")
+
+	version, err := migrator.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	require.NoError(t, migrator.Up(ctx))
+	version, err = migrator.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(Migrations), version)
+
+	// applying again is a no-op
+	require.NoError(t, migrator.Up(ctx))
+
+	states, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, states, len(Migrations))
+	for _, state := range states {
+		assert.True(t, state.Applied, state.String())
+	}
+
+	require.NoError(t, migrator.Down(ctx))
+	version, err = migrator.Version(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(Migrations)-1, version)
+
+	for i := 0; i < len(Migrations)-1; i++ {
+		require.NoError(t, migrator.Down(ctx))
+	}
+	assert.Error(t, migrator.Down(ctx))
+
+	states, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, state := range states {
+		assert.False(t, state.Applied, state.String())
+	}
+}
+
+func TestMigratorMovesLegacyData(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+
+	_, err := db.Exec("CREATE TABLE statistics5 ( ID text, MType text, Delta bigserial, Value double precision, CONSTRAINT id_pk PRIMARY KEY (ID), CONSTRAINT id_type_uq UNIQUE (ID, MType));")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO statistics5 VALUES ('PollCount', 'counter', 42, 0), ('HeapAlloc', 'gauge', 0, 1.5);")
+	require.NoError(t, err)
+
+	require.NoError(t, NewMigrator(db, "sqlite3").Up(ctx))
+
+	storage := NewSQLStorage(StorageConfig{DBType: "sqlite3"})
+	storage.DB = db
+	storage.ctx = ctx
+
+	gaugeData, counterData, err := storage.GetStats()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"HeapAlloc": 1.5}, gaugeData)
+	assert.Equal(t, map[string]int64{"PollCount": 42}, counterData)
+
+	// the new schema is keyed by id and type
+	require.NoError(t, storage.GetUpdate(GaugeTypeName, "PollCount", "2.5"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "1"))
+	gaugeValue, err := storage.GetGaugeValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, gaugeValue)
+	counterValue, err := storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(43), counterValue)
+}
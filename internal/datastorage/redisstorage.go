@@ -0,0 +1,536 @@
+package datastorage
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis keys: a string per metric, a set of the names per type and a list of the
+// history samples per metric.
+const redisKeyPrefix = "metrics:"
+
+// errRedisNotOpened is returned by a storage whose client wasn't created by Open.
+var errRedisNotOpened = wrapError(ErrUnavailable, "redis client is not opened")
+
+// redisTxRetries limits the retries of an update whose watched keys were changed by another replica.
+const redisTxRetries = 50
+
+func redisKey(metricType string, metricName string) string {
+	return redisKeyPrefix + metricType + ":" + metricName
+}
+
+func redisIndexKey(metricType string) string {
+	return redisKeyPrefix + metricType + "s"
+}
+
+func redisHistoryKey(metricType string, metricName string) string {
+	return redisKeyPrefix + "history:" + historyKey(metricType, metricName)
+}
+
+// RedisStorage keeps the metrics in Redis, so several servers can share them. Gauges are
+// written with SET and counters with INCRBY; every update is a MULTI which watches the
+// counters and histograms it was checked against.
+type RedisStorage struct {
+	cfg    StorageConfig
+	ctx    context.Context
+	client *redis.Client
+}
+
+func NewRedisStorage(cfg StorageConfig) *RedisStorage {
+	dataStorage := new(RedisStorage)
+	dataStorage.cfg = cfg
+	return dataStorage
+}
+
+func (storage *RedisStorage) Init() {
+}
+
+func (storage *RedisStorage) RunReciver(end context.Context) {
+	if storage.client == nil {
+		if err := storage.Open(end); err != nil {
+			return
+		}
+	}
+	defer storage.client.Close()
+	<-storage.ctx.Done()
+}
+
+// Open creates the client of RedisURL; the server calls it before the handlers start and RunReciver
+// calls it when nobody did. The connection is made by the first command, so Redis may come up after the server.
+func (storage *RedisStorage) Open(ctx context.Context) error {
+	storage.ctx = ctx
+
+	options, err := redis.ParseURL(storage.cfg.RedisURL)
+	if err != nil {
+		log.Println("redis url isnt parsed")
+		log.Println(err)
+		return err
+	}
+	storage.client = redis.NewClient(options)
+	return nil
+}
+
+func (storage *RedisStorage) Ping() bool {
+	if storage.client == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 1*time.Second)
+	defer cancel()
+	return storage.client.Ping(ctx).Err() == nil
+}
+
+// update reads the counters and histograms, stages the records against them and writes the records
+// in one MULTI. The read keys are watched, so a concurrent change of them runs the update again.
+func (storage *RedisStorage) update(counters []string, histograms []string, stage func(data *StoredData) ([]walRecord, error)) error {
+	if storage.client == nil {
+		return errRedisNotOpened
+	}
+	keys := make([]string, 0, len(counters)+len(histograms))
+	for _, name := range counters {
+		keys = append(keys, redisKey(CounterTypeName, name))
+	}
+	for _, name := range histograms {
+		keys = append(keys, redisKey(HistogramTypeName, name))
+	}
+
+	for attempt := 0; attempt < redisTxRetries; attempt++ {
+		err := storage.client.Watch(storage.ctx, func(tx *redis.Tx) error {
+			data, err := storage.readStaged(tx, counters, histograms)
+			if err != nil {
+				return err
+			}
+			records, err := stage(&data)
+			if err != nil {
+				return err
+			}
+			// an error of the function discards the queued commands, nothing is executed
+			_, err = tx.TxPipelined(storage.ctx, func(pipe redis.Pipeliner) error {
+				now := time.Now()
+				for _, record := range records {
+					record.Timestamp = now
+					if err := storage.queueRecord(pipe, record, &data); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			return err
+		}, keys...)
+		if err != redis.TxFailedErr {
+			return storageError(err)
+		}
+	}
+	return wrapError(ErrUnavailable, "too many concurrent updates")
+}
+
+// readStaged reads the stored counters and histograms, the missing ones stay out of the data.
+func (storage *RedisStorage) readStaged(tx *redis.Tx, counters []string, histograms []string) (StoredData, error) {
+	data := StoredData{}
+	data.initMaps()
+	if len(counters) > 0 {
+		values, err := tx.MGet(storage.ctx, redisKeys(CounterTypeName, counters)...).Result()
+		if err != nil {
+			return data, err
+		}
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+			if data.CounterData[counters[i]], err = strconv.ParseInt(value.(string), 10, 64); err != nil {
+				return data, err
+			}
+		}
+	}
+	if len(histograms) > 0 {
+		values, err := tx.MGet(storage.ctx, redisKeys(HistogramTypeName, histograms)...).Result()
+		if err != nil {
+			return data, err
+		}
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+			histogram := Histogram{}
+			if err := json.Unmarshal([]byte(value.(string)), &histogram); err != nil {
+				return data, err
+			}
+			data.HistogramData[histograms[i]] = histogram
+		}
+	}
+	return data, nil
+}
+
+func redisKeys(metricType string, names []string) []string {
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, redisKey(metricType, name))
+	}
+	return keys
+}
+
+// queueRecord adds the commands of the record to the MULTI, data tracks the counters for the history.
+// A value which can't be encoded fails the record, so no broken entry is written.
+func (storage *RedisStorage) queueRecord(pipe redis.Pipeliner, record walRecord, data *StoredData) error {
+	data.applyRecord(record, 1)
+	key := redisKey(record.MType, record.Name)
+	switch record.MType {
+	case GaugeTypeName:
+		if err := storage.queueHistory(pipe, HistorySample{ID: record.Name, MType: GaugeTypeName, Value: record.Value, Timestamp: record.Timestamp}); err != nil {
+			return err
+		}
+		pipe.Set(storage.ctx, key, record.Value, 0)
+	case CounterTypeName:
+		if err := storage.queueHistory(pipe, HistorySample{ID: record.Name, MType: CounterTypeName, Delta: data.CounterData[record.Name], Timestamp: record.Timestamp}); err != nil {
+			return err
+		}
+		if record.Reset {
+			pipe.Set(storage.ctx, key, 0, 0)
+		} else {
+			pipe.IncrBy(storage.ctx, key, record.Delta)
+		}
+	case HistogramTypeName:
+		payload, err := json.Marshal(record.Histogram)
+		if err != nil {
+			return newMetricError(record.Name, wrapError(ErrInvalidValue, err.Error()))
+		}
+		pipe.Set(storage.ctx, key, payload, 0)
+	}
+	pipe.SAdd(storage.ctx, redisIndexKey(record.MType), record.Name)
+	return nil
+}
+
+func (storage *RedisStorage) queueHistory(pipe redis.Pipeliner, sample HistorySample) error {
+	payload, err := json.Marshal(sample)
+	if err != nil {
+		return newMetricError(sample.ID, wrapError(ErrInvalidValue, err.Error()))
+	}
+	key := redisHistoryKey(sample.MType, sample.ID)
+	pipe.RPush(storage.ctx, key, payload)
+	if storage.cfg.HistoryLimit > 0 {
+		pipe.LTrim(storage.ctx, key, int64(-storage.cfg.HistoryLimit), -1)
+	}
+	return nil
+}
+
+func (storage *RedisStorage) GetUpdate(metricType string, metricName string, metricValue string) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+
+	switch metricType {
+	case GaugeTypeName:
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "gauge value "+strconv.Quote(metricValue)))
+		}
+		if err := checkFinite(value); err != nil {
+			return newMetricError(metricName, err)
+		}
+		return storage.update(nil, nil, func(data *StoredData) ([]walRecord, error) {
+			return []walRecord{{MType: GaugeTypeName, Name: metricName, Value: value}}, nil
+		})
+
+	case CounterTypeName:
+		value, err := strconv.ParseInt(metricValue, 10, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "counter value "+strconv.Quote(metricValue)))
+		}
+		return storage.updateCounter(metricName, value, false)
+
+	case HistogramTypeName:
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "histogram value "+strconv.Quote(metricValue)))
+		}
+		return storage.updateHistogram(metricName, nil, value)
+
+	default:
+		return newMetricError(metricName, wrapError(ErrBadType, metricType))
+	}
+}
+
+func (storage *RedisStorage) updateCounter(metricName string, delta int64, reset bool) error {
+	return storage.update([]string{metricName}, nil, func(data *StoredData) ([]walRecord, error) {
+		value, ok := data.CounterData[metricName]
+		if reset && !ok {
+			return nil, newMetricError(metricName, ErrCounterNotFound)
+		}
+		if _, err := addCounter(value, delta); err != nil {
+			return nil, newMetricError(metricName, err)
+		}
+		return []walRecord{{MType: CounterTypeName, Name: metricName, Delta: delta, Reset: reset}}, nil
+	})
+}
+
+func (storage *RedisStorage) updateHistogram(metricName string, delta *Histogram, observation float64) error {
+	return storage.update(nil, []string{metricName}, func(data *StoredData) ([]walRecord, error) {
+		var stored *Histogram
+		if value, ok := data.HistogramData[metricName]; ok {
+			stored = &value
+		}
+		merged, err := mergeHistogramUpdate(stored, delta, observation)
+		if err != nil {
+			return nil, newMetricError(metricName, err)
+		}
+		return []walRecord{{MType: HistogramTypeName, Name: metricName, Histogram: &merged}}, nil
+	})
+}
+
+// ResetCounter sets an existing counter to zero.
+func (storage *RedisStorage) ResetCounter(metricName string) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+	return storage.updateCounter(metricName, 0, true)
+}
+
+// UpdateHistogram merges the observations of histogram into the stored one.
+func (storage *RedisStorage) UpdateHistogram(metricName string, histogram Histogram) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+	return storage.updateHistogram(metricName, &histogram, 0)
+}
+
+func (storage *RedisStorage) GetJSONUpdate(jsonDump []byte) error {
+	metrics := Metrics{}
+	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
+		log.Println(err)
+		return wrapError(ErrParse, err.Error())
+	}
+
+	metricsHash, _ := metrics.CalcHash(storage.cfg.Key)
+	if storage.cfg.Key != "" && metricsHash != metrics.Hash {
+		log.Println("Wrong hash, " + metricsHash + " " + metrics.Hash)
+		return newMetricError(metrics.SeriesKey(), ErrBadSignature)
+	}
+	if err := ValidateLabels(metrics.Labels); err != nil {
+		return err
+	}
+
+	if metrics.MType == HistogramTypeName {
+		if metrics.Histogram == nil {
+			return newMetricError(metrics.SeriesKey(), wrapError(ErrParse, "histogram should be not empty"))
+		}
+		return storage.UpdateHistogram(metrics.SeriesKey(), *metrics.Histogram)
+	}
+	return storage.GetUpdate(metrics.MType, metrics.SeriesKey(), metrics.GetStrValue())
+}
+
+// GetJSONArray applies a batch in one MULTI and returns the result of every metric. The batch
+// is staged against the stored values, so a failed atomic batch sends nothing to Redis.
+func (storage *RedisStorage) GetJSONArray(jsonDump []byte) ([]byte, error) {
+	metricsArray, err := storage.cfg.parseBatch(jsonDump)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	counters := []string{}
+	histograms := []string{}
+	for _, metrics := range metricsArray {
+		switch metrics.MType {
+		case CounterTypeName:
+			counters = append(counters, metrics.SeriesKey())
+		case HistogramTypeName:
+			histograms = append(histograms, metrics.SeriesKey())
+		}
+	}
+
+	var results []BatchResult
+	err = storage.update(counters, histograms, func(data *StoredData) ([]walRecord, error) {
+		records, batchResults, err := storage.cfg.stageBatch(metricsArray, storage.cfg.atomicBatch(), data)
+		results = batchResults
+		return records, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(results)
+}
+
+func (storage *RedisStorage) GetJSONValue(jsonDump []byte) ([]byte, error) {
+	metrics := Metrics{}
+	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
+		log.Println(err)
+		return jsonDump, wrapError(ErrParse, err.Error())
+	}
+
+	switch metrics.MType {
+	case GaugeTypeName:
+		value, err := storage.GetGaugeValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Value = value
+		metrics.Delta = 0
+
+	case CounterTypeName:
+		value, err := storage.GetCounterValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Delta = value
+		metrics.Value = 0
+
+	case HistogramTypeName:
+		value, err := storage.GetHistogramValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Histogram = &value
+		metrics.Delta = 0
+		metrics.Value = 0
+	default:
+		return jsonDump, newMetricError(metrics.SeriesKey(), wrapError(ErrBadType, metrics.MType))
+	}
+
+	metrics.Hash, _ = metrics.CalcHash(storage.cfg.Key)
+	return metrics.MarshalJSON()
+}
+
+func (storage *RedisStorage) get(metricType string, metricName string) (string, error) {
+	if metricName == "" {
+		return "", wrapError(ErrParse, "metricName should be not empty")
+	}
+	if storage.client == nil {
+		return "", newMetricError(metricName, errRedisNotOpened)
+	}
+	value, err := storage.client.Get(storage.ctx, redisKey(metricType, metricName)).Result()
+	if err == redis.Nil {
+		return "", newMetricError(metricName, ErrNotFound)
+	}
+	if err != nil {
+		return "", newMetricError(metricName, storageError(err))
+	}
+	return value, nil
+}
+
+func (storage *RedisStorage) GetGaugeValue(metricName string) (float64, error) {
+	value, err := storage.get(GaugeTypeName, metricName)
+	if err != nil {
+		return 0, err
+	}
+	gaugeValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, newMetricError(metricName, storageError(err))
+	}
+	return gaugeValue, nil
+}
+
+func (storage *RedisStorage) GetCounterValue(metricName string) (int64, error) {
+	value, err := storage.get(CounterTypeName, metricName)
+	if err != nil {
+		return 0, err
+	}
+	counterValue, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, newMetricError(metricName, storageError(err))
+	}
+	return counterValue, nil
+}
+
+func (storage *RedisStorage) GetHistogramValue(metricName string) (Histogram, error) {
+	value, err := storage.get(HistogramTypeName, metricName)
+	if err != nil {
+		return Histogram{}, err
+	}
+	histogram := Histogram{}
+	if err := json.Unmarshal([]byte(value), &histogram); err != nil {
+		return Histogram{}, newMetricError(metricName, storageError(err))
+	}
+	return histogram, nil
+}
+
+// GetStats reads the values of the indexed metrics in one MULTI, so a batch is either seen
+// whole or not at all; a metric added after the names were read is left for the next call.
+func (storage *RedisStorage) GetStats() (map[string]float64, map[string]int64, error) {
+	if storage.client == nil {
+		return nil, nil, errRedisNotOpened
+	}
+	gaugeNames, err := storage.client.SMembers(storage.ctx, redisIndexKey(GaugeTypeName)).Result()
+	if err != nil {
+		return nil, nil, storageError(err)
+	}
+	counterNames, err := storage.client.SMembers(storage.ctx, redisIndexKey(CounterTypeName)).Result()
+	if err != nil {
+		return nil, nil, storageError(err)
+	}
+
+	var gaugeValues, counterValues *redis.SliceCmd
+	_, err = storage.client.TxPipelined(storage.ctx, func(pipe redis.Pipeliner) error {
+		if len(gaugeNames) > 0 {
+			gaugeValues = pipe.MGet(storage.ctx, redisKeys(GaugeTypeName, gaugeNames)...)
+		}
+		if len(counterNames) > 0 {
+			counterValues = pipe.MGet(storage.ctx, redisKeys(CounterTypeName, counterNames)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, storageError(err)
+	}
+
+	gaugeData := make(map[string]float64, len(gaugeNames))
+	if gaugeValues != nil {
+		for i, value := range gaugeValues.Val() {
+			if value == nil {
+				continue
+			}
+			if gaugeData[gaugeNames[i]], err = strconv.ParseFloat(value.(string), 64); err != nil {
+				return nil, nil, storageError(err)
+			}
+		}
+	}
+	counterData := make(map[string]int64, len(counterNames))
+	if counterValues != nil {
+		for i, value := range counterValues.Val() {
+			if value == nil {
+				continue
+			}
+			if counterData[counterNames[i]], err = strconv.ParseInt(value.(string), 10, 64); err != nil {
+				return nil, nil, storageError(err)
+			}
+		}
+	}
+	return gaugeData, counterData, nil
+}
+
+func (storage *RedisStorage) GetStatsFiltered(filter StatsFilter) (map[string]float64, map[string]int64, error) {
+	gaugeData, counterData, err := storage.GetStats()
+	if err != nil {
+		return nil, nil, err
+	}
+	gaugeData, counterData = filterStats(gaugeData, counterData, filter)
+	return gaugeData, counterData, nil
+}
+
+func (storage *RedisStorage) GetHistory(metricType string, metricName string, from time.Time, to time.Time) ([]HistorySample, error) {
+	if metricName == "" {
+		return nil, wrapError(ErrParse, "metricName should be not empty")
+	}
+	if metricType != GaugeTypeName && metricType != CounterTypeName {
+		return nil, newMetricError(metricName, wrapError(ErrBadType, metricType+" has no history"))
+	}
+	if storage.client == nil {
+		return nil, newMetricError(metricName, errRedisNotOpened)
+	}
+	values, err := storage.client.LRange(storage.ctx, redisHistoryKey(metricType, metricName), 0, -1).Result()
+	if err != nil {
+		return nil, newMetricError(metricName, storageError(err))
+	}
+	history := make([]HistorySample, 0, len(values))
+	for _, value := range values {
+		sample := HistorySample{}
+		if err := json.Unmarshal([]byte(value), &sample); err != nil {
+			return nil, newMetricError(metricName, storageError(err))
+		}
+		history = append(history, sample)
+	}
+	return filterHistory(history, from, to), nil
+}
@@ -0,0 +1,150 @@
+package datastorage
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisStorage(t *testing.T, server *miniredis.Miniredis, cfg StorageConfig) *RedisStorage {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	cfg.RedisURL = "redis://" + server.Addr()
+	storage := NewRedisStorage(cfg)
+	require.NoError(t, storage.Open(ctx))
+	t.Cleanup(func() { storage.client.Close() })
+	return storage
+}
+
+func TestRedisStorage(t *testing.T) {
+	server := miniredis.RunT(t)
+	storage := newTestRedisStorage(t, server, StorageConfig{HistoryLimit: 2})
+	assert.True(t, storage.Ping())
+
+	require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1.5"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "4"))
+	require.NoError(t, storage.GetUpdate(HistogramTypeName, "Latency", "0.5"))
+	require.NoError(t, storage.GetUpdate(HistogramTypeName, "Latency", "2"))
+	require.NoError(t, storage.GetJSONUpdate([]byte(`{"id":"Requests","type":"counter","delta":1,"labels":{"code":"200"}}`)))
+
+	// gauges are plain strings and counters are integers, so other clients can read them
+	assert.Equal(t, "1.5", mustGet(t, server, "metrics:gauge:HeapAlloc"))
+	assert.Equal(t, "9", mustGet(t, server, "metrics:counter:PollCount"))
+
+	gaugeValue, err := storage.GetGaugeValue("HeapAlloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, gaugeValue)
+	counterValue, err := storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), counterValue)
+	histogram, err := storage.GetHistogramValue("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), histogram.Count)
+	body, err := storage.GetJSONValue([]byte(`{"id":"Requests","type":"counter","labels":{"code":"200"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"Requests","type":"counter","delta":1,"labels":{"code":"200"}}`, string(body))
+
+	_, err = storage.GetGaugeValue("Missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, storage.GetUpdate("summary", "HeapAlloc", "1"), ErrBadType)
+	assert.ErrorIs(t, storage.GetUpdate(CounterTypeName, "PollCount", strconv.FormatInt(math.MaxInt64, 10)), ErrCounterOverflow)
+	assert.ErrorIs(t, storage.ResetCounter("Missing"), ErrCounterNotFound)
+
+	gaugeData, counterData, err := storage.GetStatsFiltered(StatsFilter{Labels: map[string]string{"code": "200"}})
+	require.NoError(t, err)
+	assert.Empty(t, gaugeData)
+	assert.Equal(t, map[string]int64{`Requests{code="200"}`: 1}, counterData)
+
+	samples, err := storage.GetHistory(CounterTypeName, "PollCount", time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, int64(5), samples[0].Delta)
+	assert.Equal(t, int64(9), samples[1].Delta)
+
+	require.NoError(t, storage.ResetCounter("PollCount"))
+	counterValue, err = storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), counterValue)
+
+	server.Close()
+	assert.False(t, storage.Ping())
+	_, err = storage.GetGaugeValue("HeapAlloc")
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.ErrorIs(t, storage.GetUpdate(CounterTypeName, "PollCount", "1"), ErrUnavailable)
+}
+
+func mustGet(t *testing.T, server *miniredis.Miniredis, key string) string {
+	value, err := server.Get(key)
+	require.NoError(t, err)
+	return value
+}
+
+// TestRedisStorageReplicas updates one counter from two storages at once, the watched
+// updates have to retry instead of losing increments.
+func TestRedisStorageReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	replicas := []*RedisStorage{
+		newTestRedisStorage(t, server, StorageConfig{}),
+		newTestRedisStorage(t, server, StorageConfig{}),
+	}
+
+	const workers, updates = 2, 50
+	var wg sync.WaitGroup
+	for _, replica := range replicas {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(replica *RedisStorage) {
+				defer wg.Done()
+				for i := 0; i < updates; i++ {
+					assert.NoError(t, replica.GetUpdate(CounterTypeName, "PollCount", "1"))
+					_, err := replica.GetJSONArray([]byte(`[{"id":"Left","type":"gauge","value":` + strconv.Itoa(i) + `},{"id":"Right","type":"gauge","value":` + strconv.Itoa(i) + `}]`))
+					assert.NoError(t, err)
+				}
+			}(replica)
+		}
+	}
+	wg.Wait()
+
+	for _, replica := range replicas {
+		counterValue, err := replica.GetCounterValue("PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(replicas)*workers*updates), counterValue)
+		gaugeData, _, err := replica.GetStats()
+		require.NoError(t, err)
+		assert.Equal(t, gaugeData["Left"], gaugeData["Right"])
+	}
+}
+
+// TestRedisStorageUnencodableRecord stages a value the history can't encode, the whole MULTI is dropped.
+func TestRedisStorageUnencodableRecord(t *testing.T) {
+	server := miniredis.RunT(t)
+	storage := newTestRedisStorage(t, server, StorageConfig{})
+
+	err := storage.update(nil, nil, func(data *StoredData) ([]walRecord, error) {
+		return []walRecord{
+			{MType: GaugeTypeName, Name: "HeapAlloc", Value: 1},
+			{MType: GaugeTypeName, Name: "Broken", Value: math.NaN()},
+		}, nil
+	})
+	assert.ErrorIs(t, err, ErrInvalidValue)
+
+	_, err = storage.GetGaugeValue("HeapAlloc")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, server.Exists(redisHistoryKey(GaugeTypeName, "Broken")))
+
+	require.NoError(t, storage.GetUpdate(GaugeTypeName, "Broken", "2"))
+	samples, err := storage.GetHistory(GaugeTypeName, "Broken", time.Unix(0, 0), time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, float64(2), samples[0].Value)
+}
@@ -0,0 +1,531 @@
+package datastorage
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Engines of the in-memory storage, the sql storage is chosen by DataBaseDSN instead.
+const (
+	EngineReceiver = "receiver"
+	EngineSharded  = "sharded"
+
+	DefaultEngine = EngineReceiver
+)
+
+// ShardCount is the number of shards of ShardedStorage.
+const ShardCount = 32
+
+type storageShard struct {
+	mu   sync.RWMutex
+	data StoredData
+}
+
+// ShardedStorage keeps the metrics in memory like FileStorage, but in shards guarded by
+// their own locks instead of a single receiver goroutine, so updates of different metrics
+// don't wait for each other. The snapshots are the same as FileStorage ones, there is no
+// write-ahead log: a crash loses the updates since the last snapshot.
+type ShardedStorage struct {
+	shards  []*storageShard
+	cfg     StorageConfig
+	storeMu sync.Mutex
+}
+
+func NewShardedStorage(cfg StorageConfig) *ShardedStorage {
+	log.Println("Create Sharded Storage")
+	log.Println(cfg)
+	storage := &ShardedStorage{cfg: cfg}
+	if _, err := GetSnapshotCodec(cfg.StoreFormat); err != nil {
+		panic(err)
+	}
+	storage.Init()
+	if err := storage.RestoreData(); err != nil {
+		panic(err)
+	}
+	return storage
+}
+
+// Init creates the shards once, it keeps the restored data on the next calls.
+func (storage *ShardedStorage) Init() {
+	if storage.shards != nil {
+		return
+	}
+	storage.shards = make([]*storageShard, ShardCount)
+	for i := range storage.shards {
+		storage.shards[i] = &storageShard{}
+		storage.shards[i].data.initMaps()
+	}
+}
+
+func (storage *ShardedStorage) Ping() bool {
+	return true
+}
+
+// shardIndex is the fnv-1a hash of the series key.
+func shardIndex(name string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= 16777619
+	}
+	return int(hash % ShardCount)
+}
+
+func (storage *ShardedStorage) shard(name string) *storageShard {
+	return storage.shards[shardIndex(name)]
+}
+
+// lockShards write locks the shards of names in the index order, so two batches can't deadlock.
+func (storage *ShardedStorage) lockShards(names []string) func() {
+	indexes := []int{}
+	locked := map[int]bool{}
+	for _, name := range names {
+		index := shardIndex(name)
+		if !locked[index] {
+			locked[index] = true
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		storage.shards[index].mu.Lock()
+	}
+	return func() {
+		for _, index := range indexes {
+			storage.shards[index].mu.Unlock()
+		}
+	}
+}
+
+// readLockAll read locks every shard, the readers of the whole data see no half applied batch.
+func (storage *ShardedStorage) readLockAll() func() {
+	for _, shard := range storage.shards {
+		shard.mu.RLock()
+	}
+	return func() {
+		for _, shard := range storage.shards {
+			shard.mu.RUnlock()
+		}
+	}
+}
+
+// counterValue and histogramValue stage a batch, the caller holds the lock of the shard.
+func (storage *ShardedStorage) counterValue(name string) int64 {
+	return storage.shard(name).data.CounterData[name]
+}
+
+func (storage *ShardedStorage) histogramValue(name string) (Histogram, bool) {
+	value, ok := storage.shard(name).data.HistogramData[name]
+	return value, ok
+}
+
+func (storage *ShardedStorage) RestoreData() error {
+	if !(storage.cfg.Restore && storage.cfg.Store) {
+		log.Println("No data restoring")
+		return nil
+	}
+	log.Println("Start restore data from: " + storage.cfg.StoreFile)
+
+	data, err := storage.cfg.readStoredData()
+	if err != nil {
+		return err
+	}
+	for name, value := range data.GaugeData {
+		storage.shard(name).data.GaugeData[name] = value
+	}
+	for name, value := range data.CounterData {
+		storage.shard(name).data.CounterData[name] = value
+	}
+	for name, value := range data.HistogramData {
+		storage.shard(name).data.HistogramData[name] = value
+	}
+	for key, history := range data.History {
+		// the history key is "type:name"
+		name := key[strings.Index(key, ":")+1:]
+		storage.shard(name).data.History[key] = history
+	}
+
+	log.Println("Restore data: succesed")
+	return nil
+}
+
+// StoreData writes the data of all shards as one snapshot. The stored histograms and history
+// samples are never changed in place, so only the maps are copied under the locks.
+func (storage *ShardedStorage) StoreData(t time.Time) error {
+	if !storage.cfg.Store {
+		return nil
+	}
+	storage.storeMu.Lock()
+	defer storage.storeMu.Unlock()
+
+	data := StoredData{storedTS: t}
+	data.initMaps()
+	unlock := storage.readLockAll()
+	for _, shard := range storage.shards {
+		for name, value := range shard.data.GaugeData {
+			data.GaugeData[name] = value
+		}
+		for name, value := range shard.data.CounterData {
+			data.CounterData[name] = value
+		}
+		for name, value := range shard.data.HistogramData {
+			data.HistogramData[name] = value
+		}
+		for key, history := range shard.data.History {
+			data.History[key] = history
+		}
+	}
+	unlock()
+
+	if err := storage.cfg.writeStoredData(&data); err != nil {
+		return err
+	}
+	log.Println("Store data: succesed")
+	return nil
+}
+
+// storeSynchronized writes the snapshot after every update in the synchronized mode, a failed
+// write fails the update like in the file storage.
+func (storage *ShardedStorage) storeSynchronized() error {
+	if !storage.cfg.Synchronized {
+		return nil
+	}
+	if err := storage.StoreData(time.Now()); err != nil {
+		log.Println("Store data error: " + err.Error())
+		return storageError(err)
+	}
+	return nil
+}
+
+// RunReciver only writes the snapshots, the updates don't go through it.
+func (storage *ShardedStorage) RunReciver(end context.Context) {
+	log.Println("Start Reciver")
+	if storage.cfg.StoreInterval <= 0 {
+		<-end.Done()
+		log.Println("End Reciver")
+		return
+	}
+	storeTimer := time.NewTicker(storage.cfg.StoreInterval)
+	defer storeTimer.Stop()
+	for {
+		select {
+		case t := <-storeTimer.C:
+			if err := storage.StoreData(t); err != nil {
+				log.Println("Store data error: " + err.Error())
+			}
+		case <-end.Done():
+			log.Println("End Reciver")
+			return
+		}
+	}
+}
+
+func (storage *ShardedStorage) apply(record walRecord) {
+	record.Timestamp = time.Now()
+	storage.shard(record.Name).data.applyRecord(record, storage.cfg.HistoryLimit)
+}
+
+func (storage *ShardedStorage) GetUpdate(metricType string, metricName string, metricValue string) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+
+	switch metricType {
+	case GaugeTypeName:
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "gauge value "+strconv.Quote(metricValue)))
+		}
+		if err := checkFinite(value); err != nil {
+			return newMetricError(metricName, err)
+		}
+		shard := storage.shard(metricName)
+		shard.mu.Lock()
+		storage.apply(walRecord{MType: GaugeTypeName, Name: metricName, Value: value})
+		shard.mu.Unlock()
+
+	case CounterTypeName:
+		value, err := strconv.ParseInt(metricValue, 10, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "counter value "+strconv.Quote(metricValue)))
+		}
+		if err := storage.updateCounter(metricName, value, false); err != nil {
+			return err
+		}
+
+	case HistogramTypeName:
+		value, err := strconv.ParseFloat(metricValue, 64)
+		if err != nil {
+			return newMetricError(metricName, wrapError(ErrParse, "histogram value "+strconv.Quote(metricValue)))
+		}
+		if err := storage.updateHistogram(metricName, nil, value); err != nil {
+			return err
+		}
+
+	default:
+		return newMetricError(metricName, wrapError(ErrBadType, metricType))
+	}
+	if err := storage.storeSynchronized(); err != nil {
+		return newMetricError(metricName, err)
+	}
+
+	return nil
+}
+
+func (storage *ShardedStorage) updateCounter(metricName string, delta int64, reset bool) error {
+	shard := storage.shard(metricName)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	value, ok := shard.data.CounterData[metricName]
+	if reset && !ok {
+		return newMetricError(metricName, ErrCounterNotFound)
+	}
+	if _, err := addCounter(value, delta); err != nil {
+		return newMetricError(metricName, err)
+	}
+	storage.apply(walRecord{MType: CounterTypeName, Name: metricName, Delta: delta, Reset: reset})
+	return nil
+}
+
+func (storage *ShardedStorage) updateHistogram(metricName string, delta *Histogram, observation float64) error {
+	shard := storage.shard(metricName)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	var stored *Histogram
+	if value, ok := shard.data.HistogramData[metricName]; ok {
+		stored = &value
+	}
+	merged, err := mergeHistogramUpdate(stored, delta, observation)
+	if err != nil {
+		return newMetricError(metricName, err)
+	}
+	storage.apply(walRecord{MType: HistogramTypeName, Name: metricName, Histogram: &merged})
+	return nil
+}
+
+// ResetCounter sets an existing counter to zero.
+func (storage *ShardedStorage) ResetCounter(metricName string) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+	if err := storage.updateCounter(metricName, 0, true); err != nil {
+		return err
+	}
+	if err := storage.storeSynchronized(); err != nil {
+		return newMetricError(metricName, err)
+	}
+	return nil
+}
+
+// UpdateHistogram merges the observations of histogram into the stored one.
+func (storage *ShardedStorage) UpdateHistogram(metricName string, histogram Histogram) error {
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
+	}
+	if err := storage.updateHistogram(metricName, &histogram, 0); err != nil {
+		return err
+	}
+	if err := storage.storeSynchronized(); err != nil {
+		return newMetricError(metricName, err)
+	}
+	return nil
+}
+
+func (storage *ShardedStorage) GetJSONUpdate(jsonDump []byte) error {
+	metrics := Metrics{}
+	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
+		log.Println(err)
+		return wrapError(ErrParse, err.Error())
+	}
+
+	metricsHash, _ := metrics.CalcHash(storage.cfg.Key)
+	if storage.cfg.Key != "" && metricsHash != metrics.Hash {
+		log.Println("Wrong hash, " + metricsHash + " " + metrics.Hash)
+		return newMetricError(metrics.SeriesKey(), ErrBadSignature)
+	}
+	if err := ValidateLabels(metrics.Labels); err != nil {
+		return err
+	}
+
+	if metrics.MType == HistogramTypeName {
+		if metrics.Histogram == nil {
+			return newMetricError(metrics.SeriesKey(), wrapError(ErrParse, "histogram should be not empty"))
+		}
+		return storage.UpdateHistogram(metrics.SeriesKey(), *metrics.Histogram)
+	}
+	return storage.GetUpdate(metrics.MType, metrics.SeriesKey(), metrics.GetStrValue())
+}
+
+// GetJSONArray applies a batch under the locks of all its shards and returns the result of every metric.
+func (storage *ShardedStorage) GetJSONArray(jsonDump []byte) ([]byte, error) {
+	metricsArray, err := storage.cfg.parseBatch(jsonDump)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	names := make([]string, 0, len(metricsArray))
+	for _, metrics := range metricsArray {
+		names = append(names, metrics.SeriesKey())
+	}
+	unlock := storage.lockShards(names)
+	records, results, err := storage.cfg.stageBatch(metricsArray, storage.cfg.atomicBatch(), storage)
+	if err == nil {
+		now := time.Now()
+		for _, record := range records {
+			record.Timestamp = now
+			storage.shard(record.Name).data.applyRecord(record, storage.cfg.HistoryLimit)
+		}
+	}
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if storage.storeSynchronized() != nil {
+		if storage.cfg.atomicBatch() {
+			skipApplied(results)
+			return nil, &BatchError{Err: ErrUnavailable, Results: results}
+		}
+		for i := range results {
+			if results[i].Status == BatchStatusOK {
+				results[i].fail(ErrUnavailable)
+			}
+		}
+	}
+	return json.Marshal(results)
+}
+
+func (storage *ShardedStorage) GetJSONValue(jsonDump []byte) ([]byte, error) {
+	metrics := Metrics{}
+	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
+		log.Println(err)
+		return jsonDump, wrapError(ErrParse, err.Error())
+	}
+
+	switch metrics.MType {
+	case GaugeTypeName:
+		value, err := storage.GetGaugeValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Value = value
+		metrics.Delta = 0
+
+	case CounterTypeName:
+		value, err := storage.GetCounterValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Delta = value
+		metrics.Value = 0
+
+	case HistogramTypeName:
+		value, err := storage.GetHistogramValue(metrics.SeriesKey())
+		if err != nil {
+			return jsonDump, err
+		}
+		metrics.Histogram = &value
+		metrics.Delta = 0
+		metrics.Value = 0
+	default:
+		return jsonDump, newMetricError(metrics.SeriesKey(), wrapError(ErrBadType, metrics.MType))
+	}
+
+	metrics.Hash, _ = metrics.CalcHash(storage.cfg.Key)
+	return metrics.MarshalJSON()
+}
+
+func (storage *ShardedStorage) GetGaugeValue(metricName string) (float64, error) {
+	if metricName == "" {
+		return 0, wrapError(ErrParse, "metricName should be not empty")
+	}
+	shard := storage.shard(metricName)
+	shard.mu.RLock()
+	value, ok := shard.data.GaugeData[metricName]
+	shard.mu.RUnlock()
+	if !ok {
+		return 0, newMetricError(metricName, ErrNotFound)
+	}
+	return value, nil
+}
+
+func (storage *ShardedStorage) GetCounterValue(metricName string) (int64, error) {
+	if metricName == "" {
+		return 0, wrapError(ErrParse, "metricName should be not empty")
+	}
+	shard := storage.shard(metricName)
+	shard.mu.RLock()
+	value, ok := shard.data.CounterData[metricName]
+	shard.mu.RUnlock()
+	if !ok {
+		return 0, newMetricError(metricName, ErrNotFound)
+	}
+	return value, nil
+}
+
+func (storage *ShardedStorage) GetHistogramValue(metricName string) (Histogram, error) {
+	if metricName == "" {
+		return Histogram{}, wrapError(ErrParse, "metricName should be not empty")
+	}
+	shard := storage.shard(metricName)
+	shard.mu.RLock()
+	value, ok := shard.data.HistogramData[metricName]
+	shard.mu.RUnlock()
+	if !ok {
+		return Histogram{}, newMetricError(metricName, ErrNotFound)
+	}
+	return value.Copy(), nil
+}
+
+// GetStats returns copies of the gauges and counters taken under the read locks of all shards.
+func (storage *ShardedStorage) GetStats() (map[string]float64, map[string]int64, error) {
+	unlock := storage.readLockAll()
+	defer unlock()
+	gauges, counters := 0, 0
+	for _, shard := range storage.shards {
+		gauges += len(shard.data.GaugeData)
+		counters += len(shard.data.CounterData)
+	}
+	gaugeData := make(map[string]float64, gauges)
+	counterData := make(map[string]int64, counters)
+	for _, shard := range storage.shards {
+		for name, value := range shard.data.GaugeData {
+			gaugeData[name] = value
+		}
+		for name, value := range shard.data.CounterData {
+			counterData[name] = value
+		}
+	}
+	return gaugeData, counterData, nil
+}
+
+func (storage *ShardedStorage) GetStatsFiltered(filter StatsFilter) (map[string]float64, map[string]int64, error) {
+	gaugeData, counterData, err := storage.GetStats()
+	if err != nil {
+		return nil, nil, err
+	}
+	gaugeData, counterData = filterStats(gaugeData, counterData, filter)
+	return gaugeData, counterData, nil
+}
+
+func (storage *ShardedStorage) GetHistory(metricType string, metricName string, from time.Time, to time.Time) ([]HistorySample, error) {
+	if metricName == "" {
+		return nil, wrapError(ErrParse, "metricName should be not empty")
+	}
+	if metricType != GaugeTypeName && metricType != CounterTypeName {
+		return nil, newMetricError(metricName, wrapError(ErrBadType, metricType+" has no history"))
+	}
+	shard := storage.shard(metricName)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return filterHistory(shard.data.History[historyKey(metricType, metricName)], from, to), nil
+}
@@ -0,0 +1,155 @@
+package datastorage
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedStorage(t *testing.T) {
+	storage := NewShardedStorage(StorageConfig{})
+
+	require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1.5"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+	require.NoError(t, storage.GetUpdate(HistogramTypeName, "Latency", "0.5"))
+	require.NoError(t, storage.GetJSONUpdate([]byte(`{"id":"Requests","type":"counter","delta":1,"labels":{"code":"200"}}`)))
+
+	gaugeValue, err := storage.GetGaugeValue("HeapAlloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, gaugeValue)
+	counterValue, err := storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counterValue)
+	histogram, err := storage.GetHistogramValue("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), histogram.Count)
+	body, err := storage.GetJSONValue([]byte(`{"id":"Requests","type":"counter","labels":{"code":"200"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"Requests","type":"counter","delta":1,"labels":{"code":"200"}}`, string(body))
+
+	_, err = storage.GetGaugeValue("Missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, storage.GetUpdate("summary", "HeapAlloc", "1"), ErrBadType)
+	assert.ErrorIs(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "none"), ErrParse)
+	assert.ErrorIs(t, storage.GetUpdate(CounterTypeName, "PollCount", strconv.FormatInt(math.MaxInt64, 10)), ErrCounterOverflow)
+	assert.ErrorIs(t, storage.ResetCounter("Missing"), ErrCounterNotFound)
+	require.NoError(t, storage.ResetCounter("PollCount"))
+	counterValue, err = storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), counterValue)
+
+	gaugeData, counterData, err := storage.GetStatsFiltered(StatsFilter{Prefix: "Requests"})
+	require.NoError(t, err)
+	assert.Empty(t, gaugeData)
+	assert.Equal(t, map[string]int64{`Requests{code="200"}`: 1}, counterData)
+
+	samples, err := storage.GetHistory(CounterTypeName, "PollCount", time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	assert.Len(t, samples, 3)
+	_, err = storage.GetHistory(HistogramTypeName, "Latency", time.Unix(0, 0), time.Now())
+	assert.ErrorIs(t, err, ErrBadType)
+}
+
+func TestShardedStorageSnapshot(t *testing.T) {
+	cfg := StorageConfig{
+		StoreFile: filepath.Join(t.TempDir(), "metrics.json"),
+		Store:     true,
+		Restore:   true,
+	}
+	storage := NewShardedStorage(cfg)
+	require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1.5"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	require.NoError(t, storage.GetUpdate(HistogramTypeName, "Latency", "0.5"))
+	require.NoError(t, storage.StoreData(time.Now()))
+
+	// the snapshot is the FileStorage one, so the engines can be switched
+	for _, restored := range []conformanceStorage{NewShardedStorage(cfg), NewFileStorage(cfg)} {
+		if fileStorage, ok := restored.(*FileStorage); ok {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go fileStorage.RunReciver(ctx)
+		}
+		gaugeValue, err := restored.GetGaugeValue("HeapAlloc")
+		require.NoError(t, err)
+		assert.Equal(t, 1.5, gaugeValue)
+		counterValue, err := restored.GetCounterValue("PollCount")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), counterValue)
+	}
+
+	restored := NewShardedStorage(cfg)
+	samples, err := restored.GetHistory(CounterTypeName, "PollCount", time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	assert.Len(t, samples, 1)
+	histogram, err := restored.GetHistogramValue("Latency")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), histogram.Count)
+}
+
+// TestShardedStorageConcurrentGetStats is TestFileStorageConcurrentGetStats for the sharded storage,
+// the batches lock two shards at once.
+func TestShardedStorageConcurrentGetStats(t *testing.T) {
+	storage := NewShardedStorage(StorageConfig{})
+
+	const writers, updates = 4, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				value := strconv.Itoa(w*updates + i)
+				_, err := storage.GetJSONArray([]byte(`[{"id":"Left","type":"gauge","value":` + value + `},{"id":"Right","type":"gauge","value":` + value + `}]`))
+				assert.NoError(t, err)
+				assert.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "1"))
+				assert.NoError(t, storage.GetUpdate(GaugeTypeName, "Gauge"+strconv.Itoa(i), value))
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	readErrs := make(chan string, writers)
+	for r := 0; r < writers; r++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					readErrs <- ""
+					return
+				default:
+				}
+				gaugeData, _, err := storage.GetStats()
+				if err != nil {
+					readErrs <- err.Error()
+					return
+				}
+				if gaugeData["Left"] != gaugeData["Right"] {
+					readErrs <- "half applied batch"
+					return
+				}
+				if _, err := storage.GetHistory(CounterTypeName, "PollCount", time.Unix(0, 0), time.Now()); err != nil {
+					readErrs <- err.Error()
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	for r := 0; r < writers; r++ {
+		assert.Empty(t, <-readErrs)
+	}
+
+	counterValue, err := storage.GetCounterValue("PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(writers*updates), counterValue)
+}
@@ -0,0 +1,178 @@
+package datastorage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	DefaultStoreRetention = 3
+
+	snapshotMagic   = "PMSNAP"
+	snapshotVersion = 1
+)
+
+var (
+	ErrSnapshotCorrupted = errors.New("DataStorage: snapshot is corrupted")
+	ErrNoSnapshot        = errors.New("DataStorage: no valid snapshot")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Snapshot layout: a text header line "PMSNAP <version> <crc32c hex> <payload length>\n" and the payload.
+func encodeSnapshotHeader(payload []byte) []byte {
+	return []byte(fmt.Sprintf("%s %d %08x %d\n", snapshotMagic, snapshotVersion, crc32.Checksum(payload, crcTable), len(payload)))
+}
+
+// decodeSnapshot validates the header and returns the payload. Files written before the header
+// existed are returned as is, the decoder of the payload is the only check for them.
+func decodeSnapshot(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic+" ")) {
+		return data, nil
+	}
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, ErrSnapshotCorrupted
+	}
+
+	var magic string
+	var version int
+	var checksum uint32
+	var length int
+	if _, err := fmt.Sscanf(string(data[:end]), "%s %d %x %d", &magic, &version, &checksum, &length); err != nil {
+		return nil, ErrSnapshotCorrupted
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("DataStorage: unsupported snapshot version %d", version)
+	}
+	payload := data[end+1:]
+	if len(payload) != length || crc32.Checksum(payload, crcTable) != checksum {
+		return nil, ErrSnapshotCorrupted
+	}
+	return payload, nil
+}
+
+// snapshotPaths lists the snapshot files from the newest: path, path.1, ..., path.(retention-1).
+func snapshotPaths(path string, retention int) []string {
+	if retention < 1 {
+		retention = 1
+	}
+	paths := []string{path}
+	for i := 1; i < retention; i++ {
+		paths = append(paths, path+"."+strconv.Itoa(i))
+	}
+	return paths
+}
+
+// writeSnapshot atomically replaces path with a new snapshot: the data goes to a synced temp file
+// which is renamed over path after the previous snapshots are shifted by one.
+func writeSnapshot(path string, data []byte, retention int) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	paths := snapshotPaths(path, retention)
+	for i := len(paths) - 1; i > 0; i-- {
+		if err := os.Rename(paths[i-1], paths[i]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// readSnapshots calls decode with the payload of every snapshot from the newest one
+// and stops at the first one decode accepts. io.EOF means there is nothing to restore.
+func readSnapshots(path string, retention int, decode func([]byte) error) (string, error) {
+	broken := 0
+	for _, snapshotPath := range snapshotPaths(path, retention) {
+		data, err := os.ReadFile(snapshotPath)
+		if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+			continue
+		}
+		if err == nil {
+			var payload []byte
+			if payload, err = decodeSnapshot(data); err == nil {
+				err = decode(payload)
+			}
+		}
+		if err != nil {
+			log.Println("Skip snapshot " + snapshotPath + ": " + err.Error())
+			broken++
+			continue
+		}
+		return snapshotPath, nil
+	}
+	if broken == 0 {
+		return "", io.EOF
+	}
+	return "", ErrNoSnapshot
+}
+
+// readStoredData restores the data from the newest readable snapshot.
+func (cfg StorageConfig) readStoredData() (StoredData, error) {
+	data := StoredData{}
+	path, err := readSnapshots(cfg.StoreFile, cfg.StoreRetention, func(payload []byte) error {
+		// the format is detected, so switching STORE_FORMAT keeps the old snapshots readable
+		decoded := StoredData{}
+		if err := SnapshotCodecs[DetectStoreFormat(payload)].Decode(payload, &decoded); err != nil {
+			return err
+		}
+		data = decoded
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return StoredData{}, err
+	}
+	if path != "" && path != cfg.StoreFile {
+		log.Println("Restore data: fallback to " + path)
+	}
+	data.initMaps()
+	return data, nil
+}
+
+// writeStoredData writes the data as a new checksummed snapshot in the configured format.
+func (cfg StorageConfig) writeStoredData(data *StoredData) error {
+	codec, err := GetSnapshotCodec(cfg.StoreFormat)
+	if err != nil {
+		return err
+	}
+	payload, err := codec.Encode(data)
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(cfg.StoreFile, append(encodeSnapshotHeader(payload), payload...), cfg.StoreRetention)
+}
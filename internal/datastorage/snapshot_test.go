@@ -0,0 +1,157 @@
+package datastorage
+
+import (
+	"context"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSnapshotTestStorage(storeFile string) *FileStorage {
+	return NewFileStorage(StorageConfig{
+		StoreFile:      storeFile,
+		StoreInterval:  time.Hour,
+		Store:          true,
+		Restore:        true,
+		StoreRetention: 3,
+	})
+}
+
+func TestSnapshotHeader(t *testing.T) {
+	payload := []byte("payload")
+	data := append(encodeSnapshotHeader(payload), payload...)
+
+	decoded, err := decodeSnapshot(data)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-1] = 'X'
+	_, err = decodeSnapshot(corrupted)
+	assert.ErrorIs(t, err, ErrSnapshotCorrupted)
+
+	_, err = decodeSnapshot(data[:len(data)-2])
+	assert.ErrorIs(t, err, ErrSnapshotCorrupted)
+
+	_, err = decodeSnapshot([]byte("PMSNAP 99 00000000 0\n"))
+	assert.Error(t, err)
+}
+
+func TestStoreDataRetention(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.db")
+	storage := newSnapshotTestStorage(storeFile)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, storage.StoreData(time.Now()))
+	}
+
+	for _, path := range []string{storeFile, storeFile + ".1", storeFile + ".2"} {
+		_, err := os.Stat(path)
+		assert.NoError(t, err, path)
+	}
+	_, err := os.Stat(storeFile + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	matches, err := filepath.Glob(storeFile + ".tmp-*")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestRestoreDataFallback(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.db")
+	storage := newSnapshotTestStorage(storeFile)
+	storage.Data.GaugeData["HeapAlloc"] = 1
+	require.NoError(t, storage.StoreData(time.Now()))
+	storage.Data.GaugeData["HeapAlloc"] = 2
+	require.NoError(t, storage.StoreData(time.Now()))
+
+	// a torn newest snapshot
+	data, err := os.ReadFile(storeFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(storeFile, data[:len(data)/2], 0644))
+
+	restored := newSnapshotTestStorage(storeFile)
+	assert.Equal(t, float64(1), restored.Data.GaugeData["HeapAlloc"])
+
+	// nothing valid left
+	for _, path := range snapshotPaths(storeFile, 3) {
+		require.NoError(t, os.WriteFile(path, []byte("garbage"), 0644))
+	}
+	storage = &FileStorage{cfg: restored.cfg}
+	assert.ErrorIs(t, storage.RestoreData(), ErrNoSnapshot)
+}
+
+func TestRestoreLegacySnapshot(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.db")
+	file, err := os.Create(storeFile)
+	require.NoError(t, err)
+	legacy := StoredData{
+		GaugeData:   map[string]float64{"HeapAlloc": 3},
+		CounterData: map[string]int64{"PollCount": 4},
+	}
+	require.NoError(t, gob.NewEncoder(file).Encode(&legacy))
+	require.NoError(t, file.Close())
+
+	restored := newSnapshotTestStorage(storeFile)
+	assert.Equal(t, float64(3), restored.Data.GaugeData["HeapAlloc"])
+	assert.Equal(t, int64(4), restored.Data.CounterData["PollCount"])
+}
+
+// TestSynchronizedStore updates a synchronized storage from several goroutines, the snapshot is
+// written by the receiver after every update; run it with -race.
+func TestSynchronizedStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storeFile := filepath.Join(t.TempDir(), "metrics.db")
+	storage := NewFileStorage(StorageConfig{StoreFile: storeFile, Store: true, Synchronized: true})
+	go storage.RunReciver(ctx)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				assert.NoError(t, storage.GetUpdate(GaugeTypeName, "Gauge"+strconv.Itoa(w), strconv.Itoa(i)))
+				assert.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "1"))
+				_, err := storage.GetJSONArray([]byte(`[{"id":"Batch","type":"counter","delta":1}]`))
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	data, err := StorageConfig{StoreFile: storeFile}.readStoredData()
+	require.NoError(t, err)
+	assert.Equal(t, int64(80), data.CounterData["PollCount"])
+	assert.Equal(t, int64(80), data.CounterData["Batch"])
+	assert.Equal(t, float64(19), data.GaugeData["Gauge0"])
+}
+
+func TestSynchronizedStoreError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := StorageConfig{StoreFile: filepath.Join(t.TempDir(), "missing", "metrics.db"), Store: true, Synchronized: true}
+	fileStorage := NewFileStorage(cfg)
+	go fileStorage.RunReciver(ctx)
+	shardedStorage := NewShardedStorage(cfg)
+	go shardedStorage.RunReciver(ctx)
+
+	for name, storage := range map[string]conformanceStorage{"file": fileStorage, "sharded": shardedStorage} {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1"), ErrUnavailable)
+			assert.ErrorIs(t, storage.GetUpdate(CounterTypeName, "PollCount", "1"), ErrUnavailable)
+			assert.ErrorIs(t, storage.ResetCounter("PollCount"), ErrUnavailable)
+			assert.ErrorIs(t, storage.GetUpdate(HistogramTypeName, "Latency", "1"), ErrUnavailable)
+			_, err := storage.GetJSONArray([]byte(`[{"id":"PollCount","type":"counter","delta":1}]`))
+			assert.ErrorIs(t, err, ErrUnavailable)
+		})
+	}
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"log"
 	"strconv"
 	"time"
@@ -26,61 +24,121 @@ func NewSQLStorage(cfg StorageConfig) *SQLStorage {
 	return dataStorage
 }
 
+// GetJSONArray applies a batch and returns the result of every metric. An atomic batch runs
+// in one transaction, a best-effort one in a transaction per metric.
 func (storage *SQLStorage) GetJSONArray(jsonDump []byte) ([]byte, error) {
 	log.Println("Start butch update")
 
-	metricsArray := []Metrics{}
 	log.Println(string(jsonDump))
-	if err := json.Unmarshal(jsonDump, &metricsArray); err != nil {
+	metricsArray, err := storage.cfg.parseBatch(jsonDump)
+	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 	log.Println("json parsed")
 
-	for _, el := range metricsArray {
-		metricsHash, _ := el.CalcHash(storage.cfg.Key)
-		if storage.cfg.Key != "" && metricsHash != el.Hash {
-			log.Println("Wrong hash, " + metricsHash + " " + el.Hash)
-			return nil, errors.New("wrong hash")
+	results := newBatchResults(metricsArray)
+	if !storage.cfg.atomicBatch() {
+		for i, metric := range metricsArray {
+			stored, err := storage.applyInTx(metric)
+			if err != nil {
+				log.Println("Metric didnt insert: " + metric.String() + ". Error: " + err.Error())
+				results[i].fail(err)
+				continue
+			}
+			results[i].store(stored)
+		}
+		return json.Marshal(results)
+	}
+
+	for i, metric := range metricsArray {
+		if err := storage.cfg.validateMetrics(metric); err != nil {
+			results[i].fail(err)
+			return nil, &BatchError{Err: err, Results: results}
 		}
 	}
 
 	tx, err := storage.DB.Begin()
 	if err != nil {
 		log.Println("Transaxtion didnt started: " + err.Error())
-		return nil, err
+		return nil, storageError(err)
 	}
 	// шаг 1.1 — если возникает ошибка, откатываем изменения
 	defer tx.Rollback()
 
-	// шаг 2 — готовим инструкцию
-	var queryTemplate string
-	switch storage.cfg.DBType {
-	case "sqlite3":
-		queryTemplate = "INSERT INTO statistics5 VALUES(?, ?, ?, ?) ON CONFLICT (ID, MType) DO UPDATE SET Delta = statistics5.Delta + ?, Value = ?;"
-	case "postgres":
-		queryTemplate = "INSERT INTO statistics5 VALUES($1, $2, $3, $4) ON CONFLICT (ID, MType) DO UPDATE SET Delta = statistics5.Delta + $5, Value = $6;"
+	for i, metric := range metricsArray {
+		log.Println("insert metric: " + metric.String())
+		stored, err := storage.applyMetrics(tx, metric)
+		if err != nil {
+			log.Println("Metric didnt insert: " + metric.String() + ". Error: " + err.Error())
+			skipApplied(results)
+			results[i].fail(err)
+			return nil, &BatchError{Err: err, Results: results}
+		}
+		results[i].store(stored)
+	}
+	if err = tx.Commit(); err != nil {
+		skipApplied(results)
+		return nil, &BatchError{Err: storageError(err), Results: results}
+	}
+	return json.Marshal(results)
+}
+
+// applyInTx validates and applies one metric in its own transaction.
+func (storage *SQLStorage) applyInTx(metric Metrics) (Metrics, error) {
+	if err := storage.cfg.validateMetrics(metric); err != nil {
+		return Metrics{}, err
 	}
-	stmt, err := tx.PrepareContext(storage.ctx, queryTemplate)
+	tx, err := storage.DB.BeginTx(storage.ctx, nil)
 	if err != nil {
-		log.Println("Context didnt prepared: " + err.Error())
-		return nil, err
+		return Metrics{}, newMetricError(metric.SeriesKey(), storageError(err))
 	}
+	defer tx.Rollback()
 
-	// шаг 2.1 — не забываем закрыть инструкцию, когда она больше не нужна
-	defer stmt.Close()
+	stored, err := storage.applyMetrics(tx, metric)
+	if err != nil {
+		return Metrics{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Metrics{}, newMetricError(metric.SeriesKey(), storageError(err))
+	}
+	return stored, nil
+}
 
-	for _, metric := range metricsArray {
-		log.Println("insert metric: " + metric.String())
-		if _, err = stmt.ExecContext(storage.ctx, metric.ID, metric.MType, metric.Delta, metric.Value, metric.Delta, metric.Value); err != nil {
-			log.Println("Metric didnt insert: " + metric.String() + ". Error: " + err.Error())
-			return nil, err
+// applyMetrics updates a validated metric inside tx and returns the stored value.
+func (storage *SQLStorage) applyMetrics(tx *sql.Tx, metric Metrics) (Metrics, error) {
+	stored := Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels}
+	if metric.MType == HistogramTypeName {
+		histogram, err := storage.mergeHistogram(tx, metric.SeriesKey(), metric.Histogram, 0)
+		if err != nil {
+			return Metrics{}, newMetricError(metric.SeriesKey(), storageError(err))
 		}
+		stored.Histogram = &histogram
+		stored.Hash, _ = stored.CalcHash(storage.cfg.Key)
+		return stored, nil
 	}
-	if err = tx.Commit(); err != nil {
-		return nil, err
+
+	if metric.MType == CounterTypeName {
+		if err := storage.checkCounter(tx, metric.SeriesKey(), metric.Delta); err != nil {
+			return Metrics{}, newMetricError(metric.SeriesKey(), storageError(err))
+		}
+	}
+	var queryTemplate string
+	switch storage.cfg.DBType {
+	case "sqlite3":
+		queryTemplate = "INSERT INTO metrics VALUES(?, ?, ?, ?) ON CONFLICT (ID, MType) DO UPDATE SET Delta = metrics.Delta + ?, Value = ? RETURNING Delta, Value;"
+	case "postgres":
+		queryTemplate = "INSERT INTO metrics VALUES($1, $2, $3, $4) ON CONFLICT (ID, MType) DO UPDATE SET Delta = metrics.Delta + $5, Value = $6 RETURNING Delta, Value;"
+	}
+	row := tx.QueryRowContext(storage.ctx, queryTemplate, metric.SeriesKey(), metric.MType, metric.Delta, metric.Value, metric.Delta, metric.Value)
+	if err := row.Scan(&stored.Delta, &stored.Value); err != nil {
+		return Metrics{}, newMetricError(metric.SeriesKey(), storageError(err))
 	}
-	return metricsArray[0].MarshalJSON()
+	if err := storage.insertHistory(tx, metric.SeriesKey(), metric.MType, stored.Delta, stored.Value); err != nil {
+		return Metrics{}, newMetricError(metric.SeriesKey(), storageError(err))
+	}
+	stored.Hash, _ = stored.CalcHash(storage.cfg.Key)
+	return stored, nil
 }
 
 func (storage *SQLStorage) GetUpdate(metricType string, metricName string, metricValue string) error {
@@ -88,9 +146,13 @@ func (storage *SQLStorage) GetUpdate(metricType string, metricName string, metri
 	var queryTemplate string
 	switch storage.cfg.DBType {
 	case "sqlite3":
-		queryTemplate = "INSERT INTO statistics5 VALUES(?, ?, ?, ?) ON CONFLICT (ID, MType) DO UPDATE SET Delta = statistics5.Delta + ?, Value = ?;"
+		queryTemplate = "INSERT INTO metrics VALUES(?, ?, ?, ?) ON CONFLICT (ID, MType) DO UPDATE SET Delta = metrics.Delta + ?, Value = ? RETURNING Delta, Value;"
 	case "postgres":
-		queryTemplate = "INSERT INTO statistics5 VALUES($1, $2, $3, $4) ON CONFLICT (ID, MType) DO UPDATE SET Delta = statistics5.Delta + $5, Value = $6;"
+		queryTemplate = "INSERT INTO metrics VALUES($1, $2, $3, $4) ON CONFLICT (ID, MType) DO UPDATE SET Delta = metrics.Delta + $5, Value = $6 RETURNING Delta, Value;"
+	}
+
+	if metricName == "" {
+		return wrapError(ErrParse, "metricName should be not empty")
 	}
 
 	switch metricType {
@@ -98,114 +160,440 @@ func (storage *SQLStorage) GetUpdate(metricType string, metricName string, metri
 		value, err := strconv.ParseFloat(metricValue, 64)
 		if err != nil {
 			log.Println("DataStorage: GetUpdate: error whith parsing gauge metricValue: " + err.Error())
-			return errors.New("DataStorage: GetUpdate: error whith parsing gauge metricValue: " + err.Error())
+			return newMetricError(metricName, wrapError(ErrParse, "gauge value "+strconv.Quote(metricValue)))
 		}
-		res, err := storage.DB.ExecContext(storage.ctx, queryTemplate, metricName, metricType, 0, value, 0, value)
-		if err != nil {
-			log.Println("DataStorage: GetUpdate: error whith upsert to DB: " + err.Error())
-			return errors.New("DataStorage: GetUpdate: error whith upsert to DB: " + err.Error())
+		if err := checkFinite(value); err != nil {
+			return newMetricError(metricName, err)
 		}
-		count, err := res.RowsAffected()
-		if err != nil {
-			log.Println(err)
+		if err := storage.upsertWithHistory(queryTemplate, metricName, metricType, 0, value); err != nil {
+			log.Println("DataStorage: GetUpdate: error whith upsert to DB: " + err.Error())
+			return newMetricError(metricName, storageError(err))
 		}
-		fmt.Println(count)
 
 	case CounterTypeName:
-		value, err := strconv.ParseUint(metricValue, 10, 64)
+		value, err := strconv.ParseInt(metricValue, 10, 64)
 		if err != nil {
-			log.Println("DataStorage: GetUpdate: error whith parsing gauge metricValue: " + err.Error())
-			return errors.New("DataStorage: GetUpdate: error whith parsing counter metricValue: " + err.Error())
+			log.Println("DataStorage: GetUpdate: error whith parsing counter metricValue: " + err.Error())
+			return newMetricError(metricName, wrapError(ErrParse, "counter value "+strconv.Quote(metricValue)))
 		}
-		res, err := storage.DB.ExecContext(storage.ctx, queryTemplate, metricName, metricType, value, 0, value, 0)
-		if err != nil {
+		if err := storage.upsertWithHistory(queryTemplate, metricName, metricType, value, 0); err != nil {
 			log.Println("DataStorage: GetUpdate: error whith upsert to DB: " + err.Error())
-			return errors.New("DataStorage: GetUpdate: error whith upsert to DB: " + err.Error())
+			return newMetricError(metricName, storageError(err))
 		}
-		count, err := res.RowsAffected()
+	case HistogramTypeName:
+		value, err := strconv.ParseFloat(metricValue, 64)
 		if err != nil {
-			log.Println(err)
+			log.Println("DataStorage: GetUpdate: error whith parsing histogram metricValue: " + err.Error())
+			return newMetricError(metricName, wrapError(ErrParse, "histogram value "+strconv.Quote(metricValue)))
+		}
+		if err := storage.updateHistogram(metricName, nil, value); err != nil {
+			log.Println("DataStorage: GetUpdate: error whith upsert to DB: " + err.Error())
+			return newMetricError(metricName, storageError(err))
 		}
-		fmt.Println(count)
 	default:
-		log.Println("DataStorage: GetUpdate: invalid metricType value: " + metricType + ", valid values: " + GaugeTypeName + ", " + CounterTypeName)
-		return errors.New(
-			"DataStorage: GetUpdate: invalid metricType value, valid values: " + GaugeTypeName + ", " + CounterTypeName)
+		log.Println("DataStorage: GetUpdate: invalid metricType value: " + metricType + ", valid values: " + GaugeTypeName + ", " + CounterTypeName + ", " + HistogramTypeName)
+		return newMetricError(metricName, wrapError(ErrBadType, metricType))
 	}
 
 	return nil
 }
 
+func (storage *SQLStorage) historyInsertQuery() string {
+	switch storage.cfg.DBType {
+	case "sqlite3":
+		return "INSERT INTO history VALUES(?, ?, ?, ?, ?);"
+	case "postgres":
+		return "INSERT INTO history VALUES($1, $2, $3, $4, $5);"
+	}
+	return ""
+}
+
+// historyPruneQuery deletes all but the newest rows of a series.
+func (storage *SQLStorage) historyPruneQuery() string {
+	switch storage.cfg.DBType {
+	case "sqlite3":
+		return "DELETE FROM history WHERE ID = ? and MType = ? and TS NOT IN (SELECT TS FROM history WHERE ID = ? and MType = ? ORDER BY TS DESC LIMIT ?);"
+	case "postgres":
+		return "DELETE FROM history WHERE ID = $1 and MType = $2 and TS NOT IN (SELECT TS FROM history WHERE ID = $3 and MType = $4 ORDER BY TS DESC LIMIT $5);"
+	}
+	return ""
+}
+
+// insertHistory appends a sample to the series history and keeps its HistoryLimit newest samples.
+func (storage *SQLStorage) insertHistory(tx *sql.Tx, metricName string, metricType string, delta int64, value float64) error {
+	if _, err := tx.ExecContext(storage.ctx, storage.historyInsertQuery(), metricName, metricType, delta, value, time.Now().UnixNano()); err != nil {
+		return err
+	}
+	if storage.cfg.HistoryLimit <= 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(storage.ctx, storage.historyPruneQuery(), metricName, metricType, metricName, metricType, storage.cfg.HistoryLimit)
+	return err
+}
+
+func (storage *SQLStorage) upsertWithHistory(queryTemplate string, metricName string, metricType string, delta int64, value float64) error {
+	tx, err := storage.DB.BeginTx(storage.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if metricType == CounterTypeName {
+		if err := storage.checkCounter(tx, metricName, delta); err != nil {
+			return err
+		}
+	}
+	row := tx.QueryRowContext(storage.ctx, queryTemplate, metricName, metricType, delta, value, delta, value)
+	if err := row.Scan(&delta, &value); err != nil {
+		return err
+	}
+	if err := storage.insertHistory(tx, metricName, metricType, delta, value); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkCounter locks the counter row, if there is one, and checks the update against its value:
+// sqlite turns an overflowing integer sum into a real, postgres fails with its own error.
+func (storage *SQLStorage) checkCounter(tx *sql.Tx, metricName string, delta int64) error {
+	var queryTemplate string
+	switch storage.cfg.DBType {
+	case "sqlite3":
+		queryTemplate = "SELECT Delta FROM metrics WHERE ID = ? and MType = ?;"
+	case "postgres":
+		queryTemplate = "SELECT Delta FROM metrics WHERE ID = $1 and MType = $2 FOR UPDATE;"
+	}
+
+	var value int64
+	err := tx.QueryRowContext(storage.ctx, queryTemplate, metricName, CounterTypeName).Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	_, err = addCounter(value, delta)
+	return err
+}
+
+// ResetCounter sets an existing counter to zero.
+func (storage *SQLStorage) ResetCounter(metricName string) error {
+	var queryTemplate string
+	switch storage.cfg.DBType {
+	case "sqlite3":
+		queryTemplate = "UPDATE metrics SET Delta = 0 WHERE ID = ? and MType = ?;"
+	case "postgres":
+		queryTemplate = "UPDATE metrics SET Delta = 0 WHERE ID = $1 and MType = $2;"
+	}
+
+	tx, err := storage.DB.BeginTx(storage.ctx, nil)
+	if err != nil {
+		return newMetricError(metricName, storageError(err))
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(storage.ctx, queryTemplate, metricName, CounterTypeName)
+	if err != nil {
+		log.Println("DataStorage: ResetCounter: " + err.Error())
+		return newMetricError(metricName, storageError(err))
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return newMetricError(metricName, storageError(err))
+	} else if affected == 0 {
+		return newMetricError(metricName, ErrCounterNotFound)
+	}
+	if err := storage.insertHistory(tx, metricName, CounterTypeName, 0, 0); err != nil {
+		return newMetricError(metricName, storageError(err))
+	}
+	if err := tx.Commit(); err != nil {
+		return newMetricError(metricName, storageError(err))
+	}
+	return nil
+}
+
+// UpdateHistogram merges the observations of histogram into the stored one.
+func (storage *SQLStorage) UpdateHistogram(metricName string, histogram Histogram) error {
+	if err := storage.updateHistogram(metricName, &histogram, 0); err != nil {
+		log.Println("DataStorage: UpdateHistogram: " + err.Error())
+		return newMetricError(metricName, storageError(err))
+	}
+	return nil
+}
+
+func (storage *SQLStorage) updateHistogram(metricName string, delta *Histogram, observation float64) error {
+	tx, err := storage.DB.BeginTx(storage.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := storage.mergeHistogram(tx, metricName, delta, observation); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// mergeHistogram reads, merges and writes the histogram row inside tx and returns the merged one.
+// The row is created first, so concurrent merges into a new histogram wait on the row lock instead of racing.
+func (storage *SQLStorage) mergeHistogram(tx *sql.Tx, metricName string, delta *Histogram, observation float64) (Histogram, error) {
+	var insertQuery, selectQuery, updateQuery string
+	switch storage.cfg.DBType {
+	case "sqlite3":
+		insertQuery = "INSERT INTO histograms (ID) VALUES(?) ON CONFLICT (ID) DO NOTHING;"
+		selectQuery = "SELECT Bounds, Counts, Sum, Count FROM histograms WHERE ID = ?;"
+		updateQuery = "UPDATE histograms SET Bounds = ?, Counts = ?, Sum = ?, Count = ? WHERE ID = ?;"
+	case "postgres":
+		insertQuery = "INSERT INTO histograms (ID) VALUES($1) ON CONFLICT (ID) DO NOTHING;"
+		selectQuery = "SELECT Bounds, Counts, Sum, Count FROM histograms WHERE ID = $1 FOR UPDATE;"
+		updateQuery = "UPDATE histograms SET Bounds = $1, Counts = $2, Sum = $3, Count = $4 WHERE ID = $5;"
+	}
+
+	if _, err := tx.ExecContext(storage.ctx, insertQuery, metricName); err != nil {
+		return Histogram{}, err
+	}
+	var stored *Histogram
+	histogram, err := scanHistogram(tx.QueryRowContext(storage.ctx, selectQuery, metricName))
+	if err != nil && err != sql.ErrNoRows {
+		return Histogram{}, err
+	}
+	if err == nil {
+		stored = &histogram
+	}
+
+	merged, err := mergeHistogramUpdate(stored, delta, observation)
+	if err != nil {
+		return Histogram{}, err
+	}
+	bounds, err := json.Marshal(merged.Bounds)
+	if err != nil {
+		return Histogram{}, err
+	}
+	counts, err := json.Marshal(merged.Counts)
+	if err != nil {
+		return Histogram{}, err
+	}
+	if _, err = tx.ExecContext(storage.ctx, updateQuery, string(bounds), string(counts), merged.Sum, merged.Count, metricName); err != nil {
+		return Histogram{}, err
+	}
+	return merged, nil
+}
+
+// scanHistogram reads a histograms row, sql.ErrNoRows also stands for a row without observations.
+func scanHistogram(row *sql.Row) (Histogram, error) {
+	var bounds, counts string
+	histogram := Histogram{}
+	if err := row.Scan(&bounds, &counts, &histogram.Sum, &histogram.Count); err != nil {
+		return Histogram{}, err
+	}
+	if counts == "" {
+		return Histogram{}, sql.ErrNoRows
+	}
+	if err := json.Unmarshal([]byte(bounds), &histogram.Bounds); err != nil {
+		return Histogram{}, err
+	}
+	if err := json.Unmarshal([]byte(counts), &histogram.Counts); err != nil {
+		return Histogram{}, err
+	}
+	return histogram, nil
+}
+
+func (storage *SQLStorage) GetHistogramValue(metricName string) (Histogram, error) {
+	var queryTemplate string
+	switch storage.cfg.DBType {
+	case "sqlite3":
+		queryTemplate = "SELECT Bounds, Counts, Sum, Count FROM histograms WHERE ID = ?;"
+	case "postgres":
+		queryTemplate = "SELECT Bounds, Counts, Sum, Count FROM histograms WHERE ID = $1;"
+	}
+
+	histogram, err := scanHistogram(storage.DB.QueryRowContext(storage.ctx, queryTemplate, metricName))
+	if err != nil {
+		log.Println(err)
+		return Histogram{}, newMetricError(metricName, notFoundError(err))
+	}
+	return histogram, nil
+}
+
+func (storage *SQLStorage) GetHistory(metricType string, metricName string, from time.Time, to time.Time) ([]HistorySample, error) {
+	if metricType != GaugeTypeName && metricType != CounterTypeName {
+		return nil, newMetricError(metricName, wrapError(ErrBadType, metricType+" has no history"))
+	}
+
+	var queryTemplate string
+	switch storage.cfg.DBType {
+	case "sqlite3":
+		queryTemplate = "SELECT Delta, Value, TS FROM history WHERE ID = ? and MType = ? and TS >= ? and TS <= ? ORDER BY TS;"
+	case "postgres":
+		queryTemplate = "SELECT Delta, Value, TS FROM history WHERE ID = $1 and MType = $2 and TS >= $3 and TS <= $4 ORDER BY TS;"
+	}
+
+	rows, err := storage.DB.QueryContext(storage.ctx, queryTemplate, metricName, metricType, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		log.Println(err)
+		return nil, storageError(err)
+	}
+	defer rows.Close()
+
+	samples := []HistorySample{}
+	for rows.Next() {
+		sample := HistorySample{ID: metricName, MType: metricType}
+		var ts int64
+		if err := rows.Scan(&sample.Delta, &sample.Value, &ts); err != nil {
+			return nil, storageError(err)
+		}
+		sample.Timestamp = time.Unix(0, ts)
+		samples = append(samples, sample)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, storageError(err)
+	}
+	return samples, nil
+}
+
 func (storage *SQLStorage) GetGaugeValue(metricName string) (float64, error) {
 	var queryTemplate string
 	switch storage.cfg.DBType {
 	case "sqlite3":
-		queryTemplate = "SELECT Value FROM statistics5 WHERE ID = ? and MType = ? limit 1;"
+		queryTemplate = "SELECT Value FROM metrics WHERE ID = ? and MType = ? limit 1;"
 	case "postgres":
-		queryTemplate = "SELECT Value FROM statistics5 WHERE ID = $1 and MType = $2 limit 1;"
+		queryTemplate = "SELECT Value FROM metrics WHERE ID = $1 and MType = $2 limit 1;"
 	}
 
 	row := storage.DB.QueryRowContext(storage.ctx, queryTemplate, metricName, "gauge")
 	var res float64
 	err := row.Scan(&res)
 	if err != nil {
-		return 0, errors.New("no data")
+		return 0, newMetricError(metricName, notFoundError(err))
 	}
 
 	return res, nil
 }
 
-func (storage *SQLStorage) GetCounterValue(metricName string) (uint64, error) {
+func (storage *SQLStorage) GetCounterValue(metricName string) (int64, error) {
 	var queryTemplate string
 	switch storage.cfg.DBType {
 	case "sqlite3":
-		queryTemplate = "SELECT Delta FROM statistics5 WHERE ID = ? and MType = ? limit 1;"
+		queryTemplate = "SELECT Delta FROM metrics WHERE ID = ? and MType = ? limit 1;"
 	case "postgres":
-		queryTemplate = "SELECT Delta FROM statistics5 WHERE ID = $1 and MType = $2 limit 1;"
+		queryTemplate = "SELECT Delta FROM metrics WHERE ID = $1 and MType = $2 limit 1;"
 	}
 
 	row := storage.DB.QueryRowContext(storage.ctx, queryTemplate, metricName, "counter")
-	var res uint64
+	var res int64
 	err := row.Scan(&res)
 	if err != nil {
 		log.Println(err)
-		return 0, errors.New("no data")
+		return 0, newMetricError(metricName, notFoundError(err))
 	}
 
 	return res, nil
 }
 
-func (storage *SQLStorage) GetStats() (map[string]float64, map[string]uint64, error) {
-	return map[string]float64{}, map[string]uint64{}, nil
+func (storage *SQLStorage) GetStats() (map[string]float64, map[string]int64, error) {
+	return storage.GetStatsFiltered(StatsFilter{})
+}
+
+func (storage *SQLStorage) GetStatsFiltered(filter StatsFilter) (map[string]float64, map[string]int64, error) {
+	// labels are a part of the id, so label matchers are applied, and the page is taken, after the query
+	if len(filter.Labels) > 0 {
+		gaugeData, counterData, err := storage.GetStatsFiltered(StatsFilter{Prefix: filter.Prefix})
+		if err != nil {
+			return nil, nil, err
+		}
+		gaugeData, counterData = filterStats(gaugeData, counterData, filter)
+		return gaugeData, counterData, nil
+	}
+
+	limit := int64(-1)
+	if filter.Limit > 0 {
+		limit = int64(filter.Limit)
+	}
+	offset := 0
+	if filter.Offset > 0 {
+		offset = filter.Offset
+	}
+
+	var queryTemplate string
+	var args []interface{}
+	switch storage.cfg.DBType {
+	case "sqlite3":
+		// LIKE ignores the ASCII case in sqlite, the prefix is compared as is
+		queryTemplate = "SELECT ID, MType, Delta, Value FROM metrics WHERE substr(ID, 1, length(?)) = ? ORDER BY ID, MType LIMIT ? OFFSET ?;"
+		args = []interface{}{filter.Prefix, filter.Prefix, limit, offset}
+	case "postgres":
+		queryTemplate = "SELECT ID, MType, Delta, Value FROM metrics WHERE ID LIKE $1 ESCAPE '\\' ORDER BY ID, MType LIMIT $2 OFFSET $3;"
+		var pgLimit interface{}
+		if limit > 0 {
+			pgLimit = limit
+		}
+		args = []interface{}{escapeLikePattern(filter.Prefix) + "%", pgLimit, offset}
+	}
+
+	rows, err := storage.DB.QueryContext(storage.ctx, queryTemplate, args...)
+	if err != nil {
+		log.Println("DataStorage: GetStats: " + err.Error())
+		return nil, nil, storageError(err)
+	}
+	defer rows.Close()
+
+	gaugeData := map[string]float64{}
+	counterData := map[string]int64{}
+	for rows.Next() {
+		var id, mType string
+		var delta int64
+		var value float64
+		if err := rows.Scan(&id, &mType, &delta, &value); err != nil {
+			log.Println("DataStorage: GetStats: " + err.Error())
+			return nil, nil, storageError(err)
+		}
+		switch mType {
+		case GaugeTypeName:
+			gaugeData[id] = value
+		case CounterTypeName:
+			counterData[id] = delta
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, storageError(err)
+	}
+	return gaugeData, counterData, nil
 }
 
 func (storage *SQLStorage) Init() {
 }
 
 func (storage *SQLStorage) RunReciver(end context.Context) {
-	storage.ctx = end
+	if storage.DB == nil {
+		if err := storage.Open(end); err != nil {
+			return
+		}
+	}
+	defer storage.DB.Close()
+	<-storage.ctx.Done()
+}
+
+// Open connects to the database and prepares the schema; the server calls it before the handlers
+// start and RunReciver calls it when nobody did.
+func (storage *SQLStorage) Open(ctx context.Context) error {
+	storage.ctx = ctx
 
 	db, err := sql.Open(storage.cfg.DBType, storage.cfg.DataBaseDSN)
-	storage.DB = db
 	if err != nil {
 		log.Println("sql arent opened")
 		log.Println(err)
-		return
+		return err
 	}
-	defer db.Close()
+	storage.DB = db
 
-	// create table
-	_, err = storage.DB.ExecContext(storage.ctx,
-		"CREATE TABLE IF NOT EXISTS statistics5 ( ID text, MType text, Delta bigserial, Value double precision, CONSTRAINT id_pk PRIMARY KEY (ID), CONSTRAINT id_type_uq UNIQUE (ID, MType));")
-	if err != nil {
-		log.Println("table arent created")
+	if err := NewMigrator(db, storage.cfg.DBType).Up(storage.ctx); err != nil {
+		log.Println("migrations arent applied")
 		log.Println(err)
-		return
+		return err
 	}
-	<-storage.ctx.Done()
+	return nil
 }
 
 func (storage *SQLStorage) Ping() bool {
+	if storage.DB == nil {
+		return false
+	}
 	ctx, cancel := context.WithTimeout(context.TODO(), 1*time.Second)
 	defer cancel()
 	err := storage.DB.PingContext(ctx)
@@ -217,7 +605,7 @@ func (storage *SQLStorage) GetJSONUpdate(jsonDump []byte) error {
 	log.Println(string(jsonDump))
 	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
 		log.Println(err)
-		return err
+		return wrapError(ErrParse, err.Error())
 	}
 	log.Println("json parsed")
 
@@ -225,10 +613,19 @@ func (storage *SQLStorage) GetJSONUpdate(jsonDump []byte) error {
 	metricsHash, _ := metrics.CalcHash(storage.cfg.Key)
 	if storage.cfg.Key != "" && metricsHash != metrics.Hash {
 		log.Println("Wrong hash, " + metricsHash + " " + metrics.Hash)
-		return errors.New("wrong hash")
+		return newMetricError(metrics.SeriesKey(), ErrBadSignature)
+	}
+	if err := ValidateLabels(metrics.Labels); err != nil {
+		return err
 	}
 
-	return storage.GetUpdate(metrics.MType, metrics.ID, metrics.GetStrValue())
+	if metrics.MType == HistogramTypeName {
+		if metrics.Histogram == nil {
+			return newMetricError(metrics.SeriesKey(), wrapError(ErrParse, "histogram should be not empty"))
+		}
+		return storage.UpdateHistogram(metrics.SeriesKey(), *metrics.Histogram)
+	}
+	return storage.GetUpdate(metrics.MType, metrics.SeriesKey(), metrics.GetStrValue())
 
 }
 
@@ -237,13 +634,13 @@ func (storage *SQLStorage) GetJSONValue(jsonDump []byte) ([]byte, error) {
 	metrics := Metrics{}
 	if err := json.Unmarshal(jsonDump, &metrics); err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, wrapError(ErrParse, err.Error())
 	}
 	log.Println(metrics.String())
 
 	switch metrics.MType {
 	case GaugeTypeName:
-		value, err := storage.GetGaugeValue(metrics.ID)
+		value, err := storage.GetGaugeValue(metrics.SeriesKey())
 		if err != nil {
 			log.Println(err)
 			return jsonDump, err
@@ -252,21 +649,31 @@ func (storage *SQLStorage) GetJSONValue(jsonDump []byte) ([]byte, error) {
 		metrics.Delta = 0
 
 	case CounterTypeName:
-		value, err := storage.GetCounterValue(metrics.ID)
+		value, err := storage.GetCounterValue(metrics.SeriesKey())
 		if err != nil {
 			log.Println(err)
 			return jsonDump, err
 		}
 		metrics.Delta = value
 		metrics.Value = 0
+
+	case HistogramTypeName:
+		value, err := storage.GetHistogramValue(metrics.SeriesKey())
+		if err != nil {
+			log.Println(err)
+			return jsonDump, err
+		}
+		metrics.Histogram = &value
+		metrics.Delta = 0
+		metrics.Value = 0
 	default:
-		return jsonDump, errors.New("Wrong MType: " + metrics.MType)
+		return jsonDump, newMetricError(metrics.SeriesKey(), wrapError(ErrBadType, metrics.MType))
 	}
 
 	metrics.Hash, _ = metrics.CalcHash(storage.cfg.Key)
 	res, err := metrics.MarshalJSON()
 	if err != nil {
-		return jsonDump, errors.New("error on encoding json")
+		return jsonDump, err
 	}
 	return res, nil
 }
@@ -0,0 +1,214 @@
+package datastorage
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLStorage(t *testing.T) *SQLStorage {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	storage := NewSQLStorage(StorageConfig{
+		DBType:      "sqlite3",
+		DataBaseDSN: filepath.Join(t.TempDir(), "metrics.db"),
+	})
+	require.NoError(t, storage.Open(ctx))
+	t.Cleanup(func() { storage.DB.Close() })
+	return storage
+}
+
+func TestSQLStorageGetStats(t *testing.T) {
+	storage := newTestSQLStorage(t)
+
+	gaugeData, counterData, err := storage.GetStats()
+	require.NoError(t, err)
+	assert.Empty(t, gaugeData)
+	assert.Empty(t, counterData)
+
+	require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "10.5"))
+	require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapIdle", "3"))
+	require.NoError(t, storage.GetUpdate(GaugeTypeName, "Heap_x", "4"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	_, err = storage.GetJSONArray([]byte(`[{"id":"PollCount","type":"counter","delta":3},{"id":"Sys","type":"gauge","value":7}]`))
+	require.NoError(t, err)
+
+	gaugeData, counterData, err = storage.GetStats()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"HeapAlloc": 10.5, "HeapIdle": 3, "Heap_x": 4, "Sys": 7}, gaugeData)
+	assert.Equal(t, map[string]int64{"PollCount": 5}, counterData)
+
+	tests := []struct {
+		testName    string
+		filter      StatsFilter
+		gaugeData   map[string]float64
+		counterData map[string]int64
+	}{
+		{
+			testName:    "prefix",
+			filter:      StatsFilter{Prefix: "Heap"},
+			gaugeData:   map[string]float64{"HeapAlloc": 10.5, "HeapIdle": 3, "Heap_x": 4},
+			counterData: map[string]int64{},
+		},
+		{
+			testName:    "prefix_with_wildcard",
+			filter:      StatsFilter{Prefix: "Heap_"},
+			gaugeData:   map[string]float64{"Heap_x": 4},
+			counterData: map[string]int64{},
+		},
+		{
+			testName:    "prefix_case",
+			filter:      StatsFilter{Prefix: "heap"},
+			gaugeData:   map[string]float64{},
+			counterData: map[string]int64{},
+		},
+		{
+			testName:    "prefix_mixed_case",
+			filter:      StatsFilter{Prefix: "HeapI"},
+			gaugeData:   map[string]float64{"HeapIdle": 3},
+			counterData: map[string]int64{},
+		},
+		{
+			testName:    "limit",
+			filter:      StatsFilter{Limit: 2},
+			gaugeData:   map[string]float64{"HeapAlloc": 10.5, "HeapIdle": 3},
+			counterData: map[string]int64{},
+		},
+		{
+			testName:    "offset",
+			filter:      StatsFilter{Offset: 3},
+			gaugeData:   map[string]float64{"Sys": 7},
+			counterData: map[string]int64{"PollCount": 5},
+		},
+		{
+			testName:    "offset_and_limit",
+			filter:      StatsFilter{Offset: 2, Limit: 2},
+			gaugeData:   map[string]float64{"Heap_x": 4},
+			counterData: map[string]int64{"PollCount": 5},
+		},
+		{
+			testName:    "no_match",
+			filter:      StatsFilter{Prefix: "Missing"},
+			gaugeData:   map[string]float64{},
+			counterData: map[string]int64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			gaugeData, counterData, err := storage.GetStatsFiltered(tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.gaugeData, gaugeData)
+			assert.Equal(t, tt.counterData, counterData)
+		})
+	}
+}
+
+func TestSQLStorageHistory(t *testing.T) {
+	storage := newTestSQLStorage(t)
+
+	start := time.Now()
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	_, err := storage.GetJSONArray([]byte(`[{"id":"PollCount","type":"counter","delta":3}]`))
+	require.NoError(t, err)
+
+	samples, err := storage.GetHistory(CounterTypeName, "PollCount", start, time.Now())
+	require.NoError(t, err)
+	if assert.Len(t, samples, 2) {
+		assert.Equal(t, int64(2), samples[0].Delta)
+		assert.Equal(t, int64(5), samples[1].Delta)
+	}
+}
+
+func TestSQLStorageHistogram(t *testing.T) {
+	storage := newTestSQLStorage(t)
+
+	_, err := storage.GetHistogramValue("ReportLatency")
+	assert.Error(t, err)
+
+	require.NoError(t, storage.GetUpdate(HistogramTypeName, "ReportLatency", "0.3"))
+	_, err = storage.GetJSONArray([]byte(`[{"id":"PollCount","type":"counter","delta":1},{"id":"ReportLatency","type":"histogram","histogram":` +
+		`{"bounds":[0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10],"counts":[0,1,0,0,0,0,0,0,0,0,0,1],"sum":20.007,"count":2}}]`))
+	require.NoError(t, err)
+	assert.Error(t, storage.UpdateHistogram("ReportLatency", NewHistogram([]float64{1})))
+
+	histogram, err := storage.GetHistogramValue("ReportLatency")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultHistogramBounds, histogram.Bounds)
+	assert.Equal(t, []uint64{0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1}, histogram.Counts)
+	assert.Equal(t, uint64(3), histogram.Count)
+	assert.InDelta(t, 20.307, histogram.Sum, 1e-9)
+
+	body, err := storage.GetJSONValue([]byte(`{"id":"ReportLatency","type":"histogram"}`))
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"count":3`)
+
+	// a failed merge rolls the whole batch back
+	_, err = storage.GetJSONArray([]byte(`[{"id":"PollCount","type":"counter","delta":1},{"id":"ReportLatency","type":"histogram","histogram":` +
+		`{"bounds":[1],"counts":[1,0],"sum":1,"count":1}}]`))
+	assert.Error(t, err)
+	counterValue, err := storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counterValue)
+}
+
+func TestSQLStorageLabels(t *testing.T) {
+	storage := newTestSQLStorage(t)
+
+	_, err := storage.GetJSONArray([]byte(`[` +
+		`{"id":"cpu_utilization","type":"gauge","value":10,"labels":{"core":"1"}},` +
+		`{"id":"cpu_utilization","type":"gauge","value":20,"labels":{"core":"2"}},` +
+		`{"id":"disk_used","type":"gauge","value":5,"labels":{"core":"1"}},` +
+		`{"id":"cpu_utilization","type":"gauge","value":30}]`))
+	require.NoError(t, err)
+	_, err = storage.GetJSONArray([]byte(`[{"id":"cpu_utilization","type":"gauge","value":1,"labels":{"bad-name":"1"}}]`))
+	assert.Error(t, err)
+
+	body, err := storage.GetJSONValue([]byte(`{"id":"cpu_utilization","type":"gauge","labels":{"core":"2"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"cpu_utilization","type":"gauge","value":20,"labels":{"core":"2"}}`, string(body))
+
+	gaugeData, _, err := storage.GetStatsFiltered(StatsFilter{Labels: map[string]string{"core": "1"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{`cpu_utilization{core="1"}`: 10, `disk_used{core="1"}`: 5}, gaugeData)
+
+	gaugeData, _, err = storage.GetStatsFiltered(StatsFilter{Prefix: "cpu", Labels: map[string]string{"core": "1"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{`cpu_utilization{core="1"}`: 10}, gaugeData)
+
+	gaugeData, _, err = storage.GetStatsFiltered(StatsFilter{Labels: map[string]string{"core": "1"}, Offset: 1, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{`disk_used{core="1"}`: 5}, gaugeData)
+}
+
+func TestSQLStorageCounterOverflowAndReset(t *testing.T) {
+	storage := newTestSQLStorage(t)
+
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", strconv.FormatInt(math.MaxInt64-1, 10)))
+	assert.ErrorIs(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"), ErrCounterOverflow)
+	_, err := storage.GetJSONArray([]byte(`[{"id":"Sys","type":"gauge","value":7},{"id":"PollCount","type":"counter","delta":2}]`))
+	assert.ErrorIs(t, err, ErrCounterOverflow)
+	counterValue, err := storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64-1), counterValue)
+	_, err = storage.GetGaugeValue("Sys")
+	assert.Error(t, err)
+
+	assert.ErrorIs(t, storage.ResetCounter("Unknown"), ErrCounterNotFound)
+	require.NoError(t, storage.ResetCounter("PollCount"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "-3"))
+	counterValue, err = storage.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-3), counterValue)
+
+	samples, err := storage.GetHistory(CounterTypeName, "PollCount", time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	assert.Equal(t, int64(0), samples[1].Delta)
+}
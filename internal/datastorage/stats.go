@@ -0,0 +1,78 @@
+package datastorage
+
+import (
+	"sort"
+	"strings"
+)
+
+// StatsFilter narrows GetStatsFiltered to metrics whose id starts with Prefix and whose labels
+// match Labels, see MatchLabels, and pages through them ordered by id and type. Limit <= 0 means no limit.
+type StatsFilter struct {
+	Prefix string
+	Labels map[string]string
+	Offset int
+	Limit  int
+}
+
+func (filter StatsFilter) match(key string) bool {
+	if !strings.HasPrefix(key, filter.Prefix) {
+		return false
+	}
+	if len(filter.Labels) == 0 {
+		return true
+	}
+	_, labels := ParseSeriesKey(key)
+	return MatchLabels(labels, filter.Labels)
+}
+
+type statsKey struct {
+	ID    string
+	MType string
+}
+
+func filterStats(gaugeData map[string]float64, counterData map[string]int64, filter StatsFilter) (map[string]float64, map[string]int64) {
+	keys := make([]statsKey, 0, len(gaugeData)+len(counterData))
+	for name := range gaugeData {
+		if filter.match(name) {
+			keys = append(keys, statsKey{name, GaugeTypeName})
+		}
+	}
+	for name := range counterData {
+		if filter.match(name) {
+			keys = append(keys, statsKey{name, CounterTypeName})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ID != keys[j].ID {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].MType < keys[j].MType
+	})
+
+	if filter.Offset > 0 {
+		if filter.Offset >= len(keys) {
+			keys = nil
+		} else {
+			keys = keys[filter.Offset:]
+		}
+	}
+	if filter.Limit > 0 && filter.Limit < len(keys) {
+		keys = keys[:filter.Limit]
+	}
+
+	gauges := map[string]float64{}
+	counters := map[string]int64{}
+	for _, key := range keys {
+		switch key.MType {
+		case GaugeTypeName:
+			gauges[key.ID] = gaugeData[key.ID]
+		case CounterTypeName:
+			counters[key.ID] = counterData[key.ID]
+		}
+	}
+	return gauges, counters
+}
+
+func escapeLikePattern(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+}
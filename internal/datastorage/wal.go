@@ -0,0 +1,136 @@
+package datastorage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// walMaxRecordSize bounds a json line of the log, readWAL can't read a longer one.
+const walMaxRecordSize = 1024 * 1024
+
+var errWALRecordTooLarge = errors.New("DataStorage: WAL record is too large")
+
+// walRecord is one accepted update: the gauge value, the counter increment or reset, or the merged histogram.
+type walRecord struct {
+	Seq       uint64     `json:"seq"`
+	MType     string     `json:"type"`
+	Name      string     `json:"id"`
+	Delta     int64      `json:"delta,omitempty"`
+	Reset     bool       `json:"reset,omitempty"`
+	Value     float64    `json:"value,omitempty"`
+	Histogram *Histogram `json:"histogram,omitempty"`
+	Timestamp time.Time  `json:"ts"`
+	// Batch is the number of records of the batch, it is set on its first record only.
+	Batch int `json:"batch,omitempty"`
+}
+
+// writeAheadLog is an append-only file of json lines; every record is synced before the update is acknowledged.
+type writeAheadLog struct {
+	path string
+	file *os.File
+}
+
+func openWAL(path string) (*writeAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &writeAheadLog{path: path, file: file}, nil
+}
+
+// encodeRecord returns the json line of the record, a record too large to be read back is rejected.
+func encodeRecord(record walRecord) ([]byte, error) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) >= walMaxRecordSize {
+		return nil, errWALRecordTooLarge
+	}
+	return line, nil
+}
+
+func (wal *writeAheadLog) Append(record walRecord) error {
+	line, err := encodeRecord(record)
+	if err != nil {
+		return err
+	}
+	if _, err := wal.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return wal.file.Sync()
+}
+
+// AppendBatch writes the records of a batch with a single write and sync.
+func (wal *writeAheadLog) AppendBatch(records []walRecord) error {
+	lines := []byte{}
+	for _, record := range records {
+		line, err := encodeRecord(record)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if _, err := wal.file.Write(lines); err != nil {
+		return err
+	}
+	return wal.file.Sync()
+}
+
+// Truncate drops every record, it is called once they are all covered by a snapshot.
+func (wal *writeAheadLog) Truncate() error {
+	if err := wal.file.Truncate(0); err != nil {
+		return err
+	}
+	return wal.file.Sync()
+}
+
+func (wal *writeAheadLog) Close() error {
+	return wal.file.Close()
+}
+
+// readWAL returns the records of the log in order. Reading stops at the first broken line,
+// which is what a crash in the middle of Append leaves behind, and a batch cut by such
+// a line is dropped whole.
+func readWAL(path string) ([]walRecord, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []walRecord{}
+	batchStart, batchLeft := 0, 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), walMaxRecordSize)
+	for scanner.Scan() {
+		record := walRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Println("WAL: stop replay on broken record: " + err.Error())
+			break
+		}
+		if batchLeft == 0 && record.Batch > 0 {
+			batchStart, batchLeft = len(records), record.Batch
+		}
+		if batchLeft > 0 {
+			batchLeft--
+		}
+		records = append(records, record)
+	}
+	// a torn line is shorter than the record, an error here is not a crash in Append
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if batchLeft > 0 {
+		log.Printf("WAL: drop torn batch of %d records\n", len(records)-batchStart)
+		records = records[:batchStart]
+	}
+	return records, nil
+}
@@ -0,0 +1,125 @@
+package datastorage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWALTestStorage(t *testing.T, storeFile string) (*FileStorage, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	storage := NewFileStorage(StorageConfig{
+		StoreFile:     storeFile,
+		StoreInterval: time.Hour,
+		Store:         true,
+		Restore:       true,
+	})
+	go storage.RunReciver(ctx)
+	return storage, cancel
+}
+
+func TestWALRestoresUpdatesSinceSnapshot(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.db")
+
+	storage, cancel := newWALTestStorage(t, storeFile)
+	require.NoError(t, storage.GetUpdate(GaugeTypeName, "HeapAlloc", "1.5"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+	// the receiver is stopped without a snapshot, like a crash between two ticks
+	cancel()
+
+	restored, cancel := newWALTestStorage(t, storeFile)
+	defer cancel()
+	gaugeValue, err := restored.GetGaugeValue("HeapAlloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, gaugeValue)
+	counterValue, err := restored.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counterValue)
+
+	samples, err := restored.GetHistory(CounterTypeName, "PollCount", time.Unix(0, 0), time.Now())
+	require.NoError(t, err)
+	assert.Len(t, samples, 2)
+}
+
+func TestWALDoesNotReplaySnapshottedRecords(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.db")
+
+	storage, cancel := newWALTestStorage(t, storeFile)
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	// snapshot written, but the process dies before the log is truncated
+	require.NoError(t, storage.StoreData(time.Now()))
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+	cancel()
+
+	restored, cancel := newWALTestStorage(t, storeFile)
+	counterValue, err := restored.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counterValue)
+	cancel()
+
+	// the restore compacts the log, so a second restart sees the same value
+	restored, cancel = newWALTestStorage(t, storeFile)
+	defer cancel()
+	counterValue, err = restored.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counterValue)
+}
+
+func TestWALCompactionAndTornTail(t *testing.T) {
+	storeFile := filepath.Join(t.TempDir(), "metrics.db")
+
+	storage, cancel := newWALTestStorage(t, storeFile)
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "2"))
+	storage.storeAndCompact(time.Now())
+	info, err := os.Stat(storeFile + ".wal")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	require.NoError(t, storage.GetUpdate(CounterTypeName, "PollCount", "3"))
+	cancel()
+
+	file, err := os.OpenFile(storeFile+".wal", os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"seq":99,"type":"counter","id":"PollCo`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	restored, cancel := newWALTestStorage(t, storeFile)
+	defer cancel()
+	counterValue, err := restored.GetCounterValue("PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counterValue)
+}
+
+func TestWALRecordTooLarge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db.wal")
+	wal, err := openWAL(path)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	require.NoError(t, wal.Append(walRecord{Seq: 1, MType: GaugeTypeName, Name: "HeapAlloc", Value: 1}))
+	large := walRecord{Seq: 2, MType: GaugeTypeName, Name: strings.Repeat("x", walMaxRecordSize), Value: 2}
+	assert.ErrorIs(t, wal.Append(large), errWALRecordTooLarge)
+	assert.ErrorIs(t, wal.AppendBatch([]walRecord{large}), errWALRecordTooLarge)
+
+	records, err := readWAL(path)
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	// a record over the limit written by someone else is an error, not a torn tail
+	line, err := json.Marshal(large)
+	require.NoError(t, err)
+	_, err = wal.file.Write(append(line, '\n'))
+	require.NoError(t, err)
+	_, err = readWAL(path)
+	assert.ErrorIs(t, err, bufio.ErrTooLong)
+}
@@ -0,0 +1,155 @@
+// Package encryption implements the hybrid scheme used between agent and server:
+// the body is sealed with a fresh AES-256-GCM key, and that key is wrapped with RSA-OAEP (SHA-256).
+//
+// Envelope layout: version(1) | wrapped key length(2, big endian) | wrapped key | nonce | ciphertext.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"os"
+)
+
+const (
+	// HeaderName marks an encrypted request body, the value is Scheme.
+	HeaderName = "X-Encryption"
+	Scheme     = "rsa-oaep-aes-gcm"
+
+	envelopeVersion byte = 1
+	aesKeySize           = 32
+)
+
+var ErrMalformed = errors.New("encryption: malformed envelope")
+
+func Encrypt(publicKey *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	key := make([]byte, aesKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	envelope := make([]byte, 3, 3+len(wrappedKey)+len(nonce)+len(plaintext)+gcm.Overhead())
+	envelope[0] = envelopeVersion
+	binary.BigEndian.PutUint16(envelope[1:3], uint16(len(wrappedKey)))
+	envelope = append(envelope, wrappedKey...)
+	envelope = append(envelope, nonce...)
+	return gcm.Seal(envelope, nonce, plaintext, envelope[:3]), nil
+}
+
+func Decrypt(privateKey *rsa.PrivateKey, envelope []byte) ([]byte, error) {
+	if len(envelope) < 3 || envelope[0] != envelopeVersion {
+		return nil, ErrMalformed
+	}
+	keyLen := int(binary.BigEndian.Uint16(envelope[1:3]))
+	if len(envelope) < 3+keyLen {
+		return nil, ErrMalformed
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, envelope[3:3+keyLen], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	rest := envelope[3+keyLen:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], envelope[:3])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// GenerateKeys returns a PEM encoded PKCS#1 private key and PKIX public key.
+func GenerateKeys(bits int) ([]byte, []byte, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	return privatePEM, publicPEM, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("encryption: no PEM data in " + path)
+	}
+	return block, nil
+}
+
+// LoadPublicKey reads a PEM file with a PKIX or PKCS#1 RSA public key.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("encryption: " + path + " is not an RSA public key")
+	}
+	return publicKey, nil
+}
+
+// LoadPrivateKey reads a PEM file with a PKCS#1 or PKCS#8 RSA private key.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("encryption: " + path + " is not an RSA private key")
+	}
+	return privateKey, nil
+}
@@ -0,0 +1,83 @@
+package encryption
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestKeys(t *testing.T) (string, string) {
+	privatePEM, publicPEM, err := GenerateKeys(2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(privatePath, privatePEM, 0600))
+	require.NoError(t, os.WriteFile(publicPath, publicPEM, 0644))
+	return privatePath, publicPath
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	privatePath, publicPath := writeTestKeys(t)
+	privateKey, err := LoadPrivateKey(privatePath)
+	require.NoError(t, err)
+	publicKey, err := LoadPublicKey(publicPath)
+	require.NoError(t, err)
+
+	tests := []struct {
+		testName  string
+		plaintext []byte
+	}{
+		{testName: "empty", plaintext: []byte{}},
+		{testName: "small", plaintext: []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)},
+		{testName: "bigger_than_rsa_block", plaintext: bytes.Repeat([]byte("metrics"), 100000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			envelope, err := Encrypt(publicKey, tt.plaintext)
+			require.NoError(t, err)
+			if len(tt.plaintext) > 0 {
+				assert.NotContains(t, string(envelope), string(tt.plaintext))
+			}
+
+			plaintext, err := Decrypt(privateKey, envelope)
+			require.NoError(t, err)
+			assert.Equal(t, tt.plaintext, append([]byte{}, plaintext...))
+		})
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	privatePath, publicPath := writeTestKeys(t)
+	privateKey, err := LoadPrivateKey(privatePath)
+	require.NoError(t, err)
+	publicKey, err := LoadPublicKey(publicPath)
+	require.NoError(t, err)
+	otherPrivatePath, _ := writeTestKeys(t)
+	otherPrivateKey, err := LoadPrivateKey(otherPrivatePath)
+	require.NoError(t, err)
+
+	envelope, err := Encrypt(publicKey, []byte("payload"))
+	require.NoError(t, err)
+
+	tampered := append([]byte{}, envelope...)
+	tampered[len(tampered)-1] ^= 0xff
+	_, err = Decrypt(privateKey, tampered)
+	assert.Error(t, err)
+
+	_, err = Decrypt(otherPrivateKey, envelope)
+	assert.Error(t, err)
+
+	_, err = Decrypt(privateKey, envelope[:10])
+	assert.Error(t, err)
+
+	_, err = Decrypt(privateKey, nil)
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	_, err = LoadPublicKey(privatePath)
+	assert.Error(t, err)
+}
@@ -0,0 +1,64 @@
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+package proto
+
+import (
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+func FromMetrics(metrics datastorage.Metrics) *Metric {
+	metric := &Metric{
+		Id:     metrics.ID,
+		Type:   metrics.MType,
+		Delta:  metrics.Delta,
+		Value:  metrics.Value,
+		Hash:   metrics.Hash,
+		Labels: metrics.Labels,
+	}
+	if metrics.Histogram != nil {
+		metric.Histogram = &Histogram{
+			Bounds: metrics.Histogram.Bounds,
+			Counts: metrics.Histogram.Counts,
+			Sum:    metrics.Histogram.Sum,
+			Count:  metrics.Histogram.Count,
+		}
+	}
+	return metric
+}
+
+func (metric *Metric) ToMetrics() datastorage.Metrics {
+	if metric == nil {
+		return datastorage.Metrics{}
+	}
+	metrics := datastorage.Metrics{
+		ID:     metric.Id,
+		MType:  metric.Type,
+		Delta:  metric.Delta,
+		Value:  metric.Value,
+		Hash:   metric.Hash,
+		Labels: metric.Labels,
+	}
+	if metric.Histogram != nil {
+		metrics.Histogram = &datastorage.Histogram{
+			Bounds: metric.Histogram.Bounds,
+			Counts: metric.Histogram.Counts,
+			Sum:    metric.Histogram.Sum,
+			Count:  metric.Histogram.Count,
+		}
+	}
+	return metrics
+}
+
+func FromBatchResult(result datastorage.BatchResult) *MetricResult {
+	metricResult := &MetricResult{
+		Id:     result.ID,
+		Type:   result.MType,
+		Labels: result.Labels,
+		Status: result.Status,
+		Error:  result.Error,
+	}
+	if result.Stored != nil {
+		metricResult.Metric = FromMetrics(*result.Stored)
+	}
+	return metricResult
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+// ErrorBody is the body of every error responce, a failed batch adds the result of every metric.
+type ErrorBody struct {
+	Code    int                       `json:"code"`
+	Message string                    `json:"message"`
+	Metric  string                    `json:"metric,omitempty"`
+	Results []datastorage.BatchResult `json:"results,omitempty"`
+}
+
+// statusCode maps the storage errors to the http status, the errors which are not
+// a storage one are bad requests.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, datastorage.ErrBadSignature):
+		return http.StatusUnauthorized
+	case errors.Is(err, datastorage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, datastorage.ErrBadType), errors.Is(err, datastorage.ErrInvalidValue):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, datastorage.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, datastorage.ErrBatchTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func writeError(rw http.ResponseWriter, code int, message string, metric string) {
+	writeErrorBody(rw, ErrorBody{Code: code, Message: message, Metric: metric})
+}
+
+func writeErrorBody(rw http.ResponseWriter, errorBody ErrorBody) {
+	body, _ := json.Marshal(errorBody)
+	rw.Header().Set("content-type", "application/json")
+	rw.WriteHeader(errorBody.Code)
+	rw.Write(body)
+}
+
+// writeStorageError writes err with the status of statusCode, the metric of a MetricError
+// and the results of a BatchError go to their own fields.
+func writeStorageError(rw http.ResponseWriter, err error, metric string) {
+	errorBody := ErrorBody{Code: statusCode(err), Message: err.Error(), Metric: metric}
+	var metricErr *datastorage.MetricError
+	if errors.As(err, &metricErr) {
+		errorBody.Message = metricErr.Err.Error()
+		errorBody.Metric = metricErr.Metric
+	}
+	var batchErr *datastorage.BatchError
+	if errors.As(err, &batchErr) {
+		errorBody.Results = batchErr.Results
+	}
+	writeErrorBody(rw, errorBody)
+}
+
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, datastorage.ErrBadSignature):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, datastorage.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, datastorage.ErrBadType), errors.Is(err, datastorage.ErrInvalidValue):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, datastorage.ErrUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, datastorage.ErrBatchTooLarge):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+}
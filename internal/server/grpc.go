@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+	pb "github.com/nikolaevs92/Practicum/internal/proto"
+)
+
+const defaultWatchInterval = time.Second
+
+// minWatchInterval bounds how often a watch reads the stats, a smaller requested interval is raised to it.
+const minWatchInterval = 100 * time.Millisecond
+
+var grpcWriteMethods = map[string]bool{
+	"/metrics.Metrics/UpdateMetric":  true,
+	"/metrics.Metrics/UpdateMetrics": true,
+}
+
+// TrustedSubnetInterceptor applies the HTTP trusted subnet rule to the write methods,
+// the agent address is taken from the x-real-ip metadata.
+func TrustedSubnetInterceptor(subnets []*net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if len(subnets) == 0 || !grpcWriteMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		var realIP string
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("x-real-ip")) > 0 {
+			realIP = md.Get("x-real-ip")[0]
+		}
+		if !InSubnets(ParseRealIP(realIP), subnets) {
+			return nil, status.Error(codes.PermissionDenied, "ip is not trusted")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GRPCServer serves the Metrics service on top of the same DataBase the HTTP router uses.
+type GRPCServer struct {
+	pb.UnimplementedMetricsServer
+	data DataBase
+}
+
+func NewGRPCServer(data DataBase) *GRPCServer {
+	return &GRPCServer{data: data}
+}
+
+func (s *GRPCServer) UpdateMetric(ctx context.Context, req *pb.UpdateMetricRequest) (*pb.UpdateMetricResponse, error) {
+	metrics := req.Metric.ToMetrics()
+	body, err := metrics.MarshalJSON()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.data.GetJSONUpdate(body); err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.UpdateMetricResponse{Metric: req.Metric}, nil
+}
+
+func (s *GRPCServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if len(req.Metrics) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty batch")
+	}
+	metricsArray := make([]datastorage.Metrics, 0, len(req.Metrics))
+	for _, metric := range req.Metrics {
+		metricsArray = append(metricsArray, metric.ToMetrics())
+	}
+	body, err := json.Marshal(metricsArray)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resp, err := s.data.GetJSONArray(body)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	results := []datastorage.BatchResult{}
+	if err := json.Unmarshal(resp, &results); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	response := &pb.UpdateMetricsResponse{}
+	for _, result := range results {
+		if response.Metric == nil && result.Stored != nil {
+			response.Metric = pb.FromMetrics(*result.Stored)
+		}
+		response.Results = append(response.Results, pb.FromBatchResult(result))
+	}
+	return response, nil
+}
+
+func (s *GRPCServer) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	body, err := json.Marshal(datastorage.Metrics{ID: req.Id, MType: req.Type, Labels: req.Labels})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resp, err := s.data.GetJSONValue(body)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	metrics := datastorage.Metrics{}
+	if err := json.Unmarshal(resp, &metrics); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.GetMetricResponse{Metric: pb.FromMetrics(metrics)}, nil
+}
+
+func (s *GRPCServer) WatchMetrics(req *pb.WatchMetricsRequest, stream pb.Metrics_WatchMetricsServer) error {
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	if interval < minWatchInterval {
+		interval = minWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sentGauges := map[string]float64{}
+	sentCounters := map[string]int64{}
+	for {
+		gaugeData, counterData, err := s.data.GetStatsFiltered(datastorage.StatsFilter{Prefix: req.Prefix, Labels: req.Labels})
+		if err != nil {
+			return grpcError(err)
+		}
+		for name, value := range gaugeData {
+			if sent, ok := sentGauges[name]; ok && sent == value {
+				continue
+			}
+			id, labels := datastorage.ParseSeriesKey(name)
+			if err := stream.Send(&pb.Metric{Id: id, Type: datastorage.GaugeTypeName, Value: value, Labels: labels}); err != nil {
+				return err
+			}
+			sentGauges[name] = value
+		}
+		for name, value := range counterData {
+			if sent, ok := sentCounters[name]; ok && sent == value {
+				continue
+			}
+			id, labels := datastorage.ParseSeriesKey(name)
+			if err := stream.Send(&pb.Metric{Id: id, Type: datastorage.CounterTypeName, Delta: value, Labels: labels}); err != nil {
+				return err
+			}
+			sentCounters[name] = value
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (dataServer *DataServer) RunGRPCServer(end context.Context) {
+	listener, err := net.Listen("tcp", dataServer.GRPCServer)
+	if err != nil {
+		log.Println("grpc listener arent opened")
+		log.Println(err)
+		return
+	}
+
+	subnets, err := ParseSubnets(dataServer.TrustedSubnet)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(TrustedSubnetInterceptor(subnets)))
+	pb.RegisterMetricsServer(server, NewGRPCServer(dataServer.DataHolder))
+	go func() {
+		<-end.Done()
+		log.Println("Shutting down the gRPC server...")
+		server.GracefulStop()
+	}()
+	if err := server.Serve(listener); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/nikolaevs92/Practicum/internal/compression"
+	"github.com/nikolaevs92/Practicum/internal/encryption"
+)
+
+// RouterOptions holds the optional parts of the router built from the server config.
+type RouterOptions struct {
+	PrivateKey     *rsa.PrivateKey
+	TrustedSubnets []*net.IPNet
+	MaxBodySize    int64
+	Zstd           bool
+}
+
+func NewRouterOptions(cfg Config) (RouterOptions, error) {
+	opts := RouterOptions{MaxBodySize: cfg.MaxBodySize, Zstd: cfg.Zstd}
+	if cfg.CryptoKey != "" {
+		privateKey, err := encryption.LoadPrivateKey(cfg.CryptoKey)
+		if err != nil {
+			return opts, err
+		}
+		opts.PrivateKey = privateKey
+	}
+	subnets, err := ParseSubnets(cfg.TrustedSubnet)
+	if err != nil {
+		return opts, err
+	}
+	opts.TrustedSubnets = subnets
+	return opts, nil
+}
+
+// ParseSubnets parses a comma separated list of IPv4/IPv6 CIDRs, an empty string gives no subnets.
+func ParseSubnets(value string) ([]*net.IPNet, error) {
+	subnets := []*net.IPNet{}
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+func ParseRealIP(value string) net.IP {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	return net.ParseIP(value)
+}
+
+func InSubnets(ip net.IP, subnets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedSubnetHandle rejects requests whose X-Real-IP is outside of the subnets.
+// Without subnets every request is allowed.
+func trustedSubnetHandle(subnets []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(subnets) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !InSubnets(ParseRealIP(r.Header.Get("X-Real-IP")), subnets) {
+				log.Println("Request from untrusted ip: " + r.Header.Get("X-Real-IP"))
+				writeError(w, http.StatusForbidden, "ip is not trusted", "")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// decryptHandle replaces bodies sent with the encryption header by their plaintext.
+// Requests without the header are passed as is, an encrypted body over maxSize is rejected.
+func decryptHandle(privateKey *rsa.PrivateKey, maxSize int64) func(http.Handler) http.Handler {
+	if maxSize <= 0 {
+		maxSize = compression.DefaultMaxSize
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme := r.Header.Get(encryption.HeaderName)
+			if scheme == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if scheme != encryption.Scheme || privateKey == nil {
+				writeError(w, http.StatusBadRequest, "unsupported encryption", "")
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+			r.Body.Close()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error(), "")
+				return
+			}
+			if int64(len(body)) > maxSize {
+				writeError(w, http.StatusRequestEntityTooLarge, "request body too large", "")
+				return
+			}
+			plaintext, err := encryption.Decrypt(privateKey, body)
+			if err != nil {
+				log.Println("Decrypt error: " + err.Error())
+				writeError(w, http.StatusBadRequest, "body decryption failed", "")
+				return
+			}
+
+			r.Header.Del(encryption.HeaderName)
+			r.Body = io.NopCloser(bytes.NewReader(plaintext))
+			r.ContentLength = int64(len(plaintext))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// decompressHandle replaces compressed request bodies by the plain ones and lists the taken encodings
+// in the Accept-Encoding of every response. A body decompressed over maxSize is rejected.
+func decompressHandle(maxSize int64, zstdEnabled bool) func(http.Handler) http.Handler {
+	accepted := compression.Gzip
+	if zstdEnabled {
+		accepted += ", " + compression.Zstd
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Accept-Encoding", accepted)
+			encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if encoding == "" || encoding == compression.Identity {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !compression.Accepts(accepted, encoding) {
+				writeError(w, http.StatusUnsupportedMediaType, "unsupported content encoding", "")
+				return
+			}
+
+			body, err := compression.Decompress(encoding, r.Body, maxSize)
+			r.Body.Close()
+			if errors.Is(err, compression.ErrTooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, err.Error(), "")
+				return
+			}
+			if err != nil {
+				log.Println("Decompress error: " + err.Error())
+				writeError(w, http.StatusBadRequest, "body decompression failed", "")
+				return
+			}
+
+			r.Header.Del("Content-Encoding")
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nikolaevs92/Practicum/internal/datastorage"
+)
+
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	openMetricsMediaType   = "application/openmetrics-text"
+)
+
+type promSample struct {
+	name   string
+	source string
+	mType  string
+	labels string
+	value  string
+}
+
+// sanitizePromName maps a metric id to the Prometheus name charset [a-zA-Z_:][a-zA-Z0-9_:]*.
+func sanitizePromName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func formatPromFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func acceptsOpenMetrics(req *http.Request) bool {
+	for _, part := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if mediaType == openMetricsMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// formatPromLabels renders the label set as {a="1",b="2"}; label names are valid Prometheus
+// names already, values are escaped as the exposition format wants.
+func formatPromLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[name])
+		parts = append(parts, name+`="`+value+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func renderPrometheus(gaugeData map[string]float64, counterData map[string]int64, openMetrics bool) []byte {
+	samples := make([]promSample, 0, len(gaugeData)+len(counterData))
+	for key, value := range gaugeData {
+		source, labels := datastorage.ParseSeriesKey(key)
+		samples = append(samples, promSample{sanitizePromName(source), source, datastorage.GaugeTypeName, formatPromLabels(labels), formatPromFloat(value)})
+	}
+	for key, value := range counterData {
+		source, labels := datastorage.ParseSeriesKey(key)
+		familyName := sanitizePromName(source)
+		if openMetrics {
+			familyName = strings.TrimSuffix(familyName, "_total")
+		}
+		samples = append(samples, promSample{familyName, source, datastorage.CounterTypeName, formatPromLabels(labels), strconv.FormatInt(value, 10)})
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].name != samples[j].name {
+			return samples[i].name < samples[j].name
+		}
+		if samples[i].mType != samples[j].mType {
+			return samples[i].mType < samples[j].mType
+		}
+		if samples[i].source != samples[j].source {
+			return samples[i].source < samples[j].source
+		}
+		return samples[i].labels < samples[j].labels
+	})
+
+	var buf bytes.Buffer
+	// the first sample of a name decides the family, others with the same name are dropped
+	var family *promSample
+	for i := range samples {
+		sample := &samples[i]
+		if family == nil || family.name != sample.name {
+			family = sample
+			fmt.Fprintf(&buf, "# HELP %s %s metric %s\n", family.name, family.mType, escapePromHelp(family.source))
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", family.name, family.mType)
+		} else if family.mType != sample.mType || family.source != sample.source {
+			log.Println("Prometheus: skip duplicated metric name " + sample.name + " for " + sample.mType + " " + sample.source)
+			continue
+		}
+
+		sampleName := sample.name
+		if openMetrics && sample.mType == datastorage.CounterTypeName {
+			sampleName += "_total"
+		}
+		fmt.Fprintf(&buf, "%s%s %s\n", sampleName, sample.labels, sample.value)
+	}
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+	}
+	return buf.Bytes()
+}
+
+func escapePromHelp(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(text)
+}
+
+func MakeHandlePrometheus(data DataBase) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		gaugeData, counterData, err := data.GetStats()
+		if err != nil {
+			log.Println(err)
+			writeStorageError(rw, err, "")
+			return
+		}
+
+		openMetrics := acceptsOpenMetrics(req)
+		if openMetrics {
+			rw.Header().Set("content-type", openMetricsContentType)
+		} else {
+			rw.Header().Set("content-type", prometheusContentType)
+		}
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(renderPrometheus(gaugeData, counterData, openMetrics))
+	}
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
@@ -10,6 +11,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -20,16 +22,25 @@ import (
 type DataBase interface {
 	GetUpdate(string, string, string) error
 	GetGaugeValue(string) (float64, error)
-	GetCounterValue(string) (uint64, error)
-	GetStats() (map[string]float64, map[string]uint64, error)
+	GetCounterValue(string) (int64, error)
+	GetHistogramValue(string) (datastorage.Histogram, error)
+	ResetCounter(string) error
+	GetStats() (map[string]float64, map[string]int64, error)
+	GetStatsFiltered(datastorage.StatsFilter) (map[string]float64, map[string]int64, error)
 	Init()
 	RunReciver(context.Context)
 	GetJSONUpdate([]byte) error
 	GetJSONArray([]byte) ([]byte, error)
 	GetJSONValue([]byte) ([]byte, error)
+	GetHistory(string, string, time.Time, time.Time) ([]datastorage.HistorySample, error)
 	Ping() bool
 }
 
+// opener is a DataBase connected by Open, which has to succeed before the handlers use it.
+type opener interface {
+	Open(context.Context) error
+}
+
 type gzipWriter struct {
 	http.ResponseWriter
 	Writer io.Writer
@@ -51,7 +62,7 @@ func gzipHandle(next http.Handler) http.Handler {
 		// создаём gzip.Writer поверх текущего w
 		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
 		if err != nil {
-			io.WriteString(w, err.Error())
+			writeError(w, http.StatusInternalServerError, err.Error(), "")
 			return
 		}
 		defer gz.Close()
@@ -68,16 +79,12 @@ func MakeHandlerJSONUpdate(data DataBase) http.HandlerFunc {
 		rw.Header().Set("content-type", "application/json")
 		body, err := io.ReadAll(req.Body)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotFound)
+			writeError(rw, http.StatusBadRequest, err.Error(), "")
 			return
 		}
-		err = data.GetJSONUpdate(body)
-		if err != nil {
-			if err.Error() == "wrong hash" {
-				rw.WriteHeader(http.StatusBadRequest)
-			} else {
-				rw.WriteHeader(http.StatusNotFound)
-			}
+		if err := data.GetJSONUpdate(body); err != nil {
+			writeStorageError(rw, err, "")
+			return
 		}
 		rw.Write(body)
 	}
@@ -89,16 +96,13 @@ func MakeHandlerJSONArray(data DataBase) http.HandlerFunc {
 		rw.Header().Set("content-type", "application/json")
 		body, err := io.ReadAll(req.Body)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotFound)
+			writeError(rw, http.StatusBadRequest, err.Error(), "")
 			return
 		}
 		resp, err := data.GetJSONArray(body)
 		if err != nil {
-			if err.Error() == "wrong hash" {
-				rw.WriteHeader(http.StatusBadRequest)
-			} else {
-				rw.WriteHeader(http.StatusNotFound)
-			}
+			writeStorageError(rw, err, "")
+			return
 		}
 		rw.Write(resp)
 	}
@@ -109,16 +113,13 @@ func MakeHandlerJSONValue(data DataBase) http.HandlerFunc {
 		rw.Header().Set("content-type", "application/json")
 		body, err := io.ReadAll(req.Body)
 		if err != nil {
-			rw.WriteHeader(http.StatusNotFound)
+			writeError(rw, http.StatusBadRequest, err.Error(), "")
 			return
 		}
 		respBody, err := data.GetJSONValue(body)
 		if err != nil {
-			if err.Error() == "wrong hash" {
-				rw.WriteHeader(http.StatusBadRequest)
-			} else {
-				rw.WriteHeader(http.StatusNotFound)
-			}
+			writeStorageError(rw, err, "")
+			return
 		}
 		rw.Write(respBody)
 	}
@@ -132,27 +133,30 @@ func MakeHandlerUpdate(data DataBase) http.HandlerFunc {
 		metricName := chi.URLParam(req, "metricName")
 		metricValue := chi.URLParam(req, "metricValue")
 
-		if metricType != datastorage.GaugeTypeName && metricType != datastorage.CounterTypeName {
-			rw.WriteHeader(http.StatusNotImplemented)
-			rw.Write([]byte("Wrong metric type"))
+		// an unknown type in the path is a route which is not implemented, not a storage error
+		if metricType != datastorage.GaugeTypeName && metricType != datastorage.CounterTypeName && metricType != datastorage.HistogramTypeName {
+			writeError(rw, http.StatusNotImplemented, "Wrong metric type", metricName)
 			return
 		}
 
 		if metricName == "" {
-			rw.WriteHeader(http.StatusBadRequest)
-			rw.Write([]byte("Empty metric_id"))
+			writeError(rw, http.StatusBadRequest, "Empty metric_id", "")
+			return
+		}
+		labels, err := parseLabelMatchers(req)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "Wrong labels", metricName)
 			return
 		}
 		body := []byte("data is recieved")
 
-		err := data.GetUpdate(metricType, metricName, metricValue)
-
-		if err == nil {
-			rw.WriteHeader(http.StatusOK)
-		} else {
+		err = data.GetUpdate(metricType, datastorage.SeriesKey(metricName, labels), metricValue)
+		if err != nil {
 			log.Println(err)
-			rw.WriteHeader(http.StatusBadRequest)
+			writeStorageError(rw, err, metricName)
+			return
 		}
+		rw.WriteHeader(http.StatusOK)
 		rw.Write(body)
 	}
 }
@@ -163,20 +167,22 @@ func MakeHandleGaugeValue(data DataBase) http.HandlerFunc {
 		metricName := chi.URLParam(req, "metricName")
 
 		if metricName == "" {
-			rw.WriteHeader(http.StatusBadRequest)
-			rw.Write([]byte("Empty metric_id"))
+			writeError(rw, http.StatusBadRequest, "Empty metric_id", "")
+			return
+		}
+		labels, err := parseLabelMatchers(req)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "Wrong labels", metricName)
 			return
 		}
 
-		value, err := data.GetGaugeValue(metricName)
-
-		if err == nil {
-			rw.WriteHeader(http.StatusOK)
-			rw.Write([]byte(strconv.FormatFloat(value, 'f', -1, 64)))
-		} else {
-			rw.WriteHeader(http.StatusNotFound)
-			rw.Write([]byte("metric not found"))
+		value, err := data.GetGaugeValue(datastorage.SeriesKey(metricName, labels))
+		if err != nil {
+			writeStorageError(rw, err, metricName)
+			return
 		}
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(strconv.FormatFloat(value, 'f', -1, 64)))
 	}
 }
 
@@ -186,20 +192,149 @@ func MakeHandleCounterValue(data DataBase) http.HandlerFunc {
 		metricName := chi.URLParam(req, "metricName")
 
 		if metricName == "" {
-			rw.WriteHeader(http.StatusBadRequest)
-			rw.Write([]byte("Empty metric_id"))
+			writeError(rw, http.StatusBadRequest, "Empty metric_id", "")
+			return
+		}
+		labels, err := parseLabelMatchers(req)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "Wrong labels", metricName)
+			return
+		}
+
+		value, err := data.GetCounterValue(datastorage.SeriesKey(metricName, labels))
+		if err != nil {
+			writeStorageError(rw, err, metricName)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(strconv.FormatInt(value, 10)))
+	}
+}
+
+// MakeHandlerResetCounter sets an existing counter to zero.
+func MakeHandlerResetCounter(data DataBase) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("content-type", "text/plain; charset=utf-8")
+		metricName := chi.URLParam(req, "metricName")
+
+		if metricName == "" {
+			writeError(rw, http.StatusBadRequest, "Empty metric_id", "")
+			return
+		}
+		labels, err := parseLabelMatchers(req)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "Wrong labels", metricName)
+			return
+		}
+
+		if err := data.ResetCounter(datastorage.SeriesKey(metricName, labels)); err != nil {
+			writeStorageError(rw, err, metricName)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+// MakeHandleHistogramValue writes the histogram as json, it has no plain text form.
+func MakeHandleHistogramValue(data DataBase) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		metricName := chi.URLParam(req, "metricName")
+
+		if metricName == "" {
+			writeError(rw, http.StatusBadRequest, "Empty metric_id", "")
+			return
+		}
+		labels, err := parseLabelMatchers(req)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "Wrong labels", metricName)
 			return
 		}
 
-		value, err := data.GetCounterValue(metricName)
+		value, err := data.GetHistogramValue(datastorage.SeriesKey(metricName, labels))
+		if err != nil {
+			writeStorageError(rw, err, metricName)
+			return
+		}
 
-		if err == nil {
-			rw.WriteHeader(http.StatusOK)
-			rw.Write([]byte(strconv.FormatUint(value, 10)))
-		} else {
-			rw.WriteHeader(http.StatusNotFound)
-			rw.Write([]byte("metric not found"))
+		body, err := json.Marshal(value)
+		if err != nil {
+			writeError(rw, http.StatusInternalServerError, err.Error(), metricName)
+			return
 		}
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(body)
+	}
+}
+
+// parseLabelMatchers reads the repeated label=name=value query parameters. On the value and
+// history endpoints they are the labels of the series, on the listing ones a filter.
+func parseLabelMatchers(req *http.Request) (map[string]string, error) {
+	matchers := map[string]string{}
+	for _, matcher := range req.URL.Query()["label"] {
+		parts := strings.SplitN(matcher, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("wrong label matcher %q, should be name=value", matcher)
+		}
+		matchers[parts[0]] = parts[1]
+	}
+	if err := datastorage.ValidateLabels(matchers); err != nil {
+		return nil, err
+	}
+	return matchers, nil
+}
+
+func parseHistoryTime(value string, defaultValue time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func MakeHandleHistory(data DataBase) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		metricType := chi.URLParam(req, "metricType")
+		metricName := chi.URLParam(req, "metricName")
+
+		if metricType != datastorage.GaugeTypeName && metricType != datastorage.CounterTypeName {
+			writeError(rw, http.StatusNotImplemented, "Wrong metric type", metricName)
+			return
+		}
+
+		labels, err := parseLabelMatchers(req)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "Wrong labels", metricName)
+			return
+		}
+		from, err := parseHistoryTime(req.URL.Query().Get("from"), time.Unix(0, 0))
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "Wrong from value", metricName)
+			return
+		}
+		to, err := parseHistoryTime(req.URL.Query().Get("to"), time.Now())
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "Wrong to value", metricName)
+			return
+		}
+
+		samples, err := data.GetHistory(metricType, datastorage.SeriesKey(metricName, labels), from, to)
+		if err != nil {
+			log.Println(err)
+			writeStorageError(rw, err, metricName)
+			return
+		}
+
+		body, err := json.Marshal(samples)
+		if err != nil {
+			writeError(rw, http.StatusInternalServerError, err.Error(), metricName)
+			return
+		}
+		rw.Header().Set("content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(body)
 	}
 }
 
@@ -207,7 +342,17 @@ func MakeGetHomeHandler(dataStorage DataBase) http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Set("content-type", "text/html; charset=utf-8")
 
-		gaugeData, counterData, _ := dataStorage.GetStats()
+		query := req.URL.Query()
+		filter := datastorage.StatsFilter{Prefix: query.Get("prefix")}
+		filter.Offset, _ = strconv.Atoi(query.Get("offset"))
+		filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+		filter.Labels, _ = parseLabelMatchers(req)
+
+		gaugeData, counterData, err := dataStorage.GetStatsFiltered(filter)
+		if err != nil {
+			writeStorageError(rw, err, "")
+			return
+		}
 
 		metrics := map[string]string{}
 
@@ -221,6 +366,7 @@ func MakeGetHomeHandler(dataStorage DataBase) http.HandlerFunc {
 		t, err := template.ParseFiles("../../template/home_page.html")
 		if err != nil {
 			fmt.Println("Could not parse template:", err)
+			writeError(rw, http.StatusInternalServerError, "Could not parse template", "")
 			return
 		}
 		err = t.Execute(rw, metrics)
@@ -231,6 +377,10 @@ func MakeGetHomeHandler(dataStorage DataBase) http.HandlerFunc {
 }
 
 func MakeRouter(dataStorage DataBase) chi.Router {
+	return MakeRouterWithOptions(dataStorage, RouterOptions{})
+}
+
+func MakeRouterWithOptions(dataStorage DataBase, opts RouterOptions) chi.Router {
 
 	r := chi.NewRouter()
 
@@ -239,54 +389,66 @@ func MakeRouter(dataStorage DataBase) chi.Router {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(gzipHandle)
+	r.Use(decryptHandle(opts.PrivateKey, opts.MaxBodySize))
+	r.Use(decompressHandle(opts.MaxBodySize, opts.Zstd))
 
 	r.Get("/", MakeGetHomeHandler(dataStorage))
+	r.Get("/metrics", MakeHandlePrometheus(dataStorage))
+	r.NotFound(func(rw http.ResponseWriter, r *http.Request) {
+		writeError(rw, http.StatusNotFound, "route not found", "")
+	})
+	r.MethodNotAllowed(func(rw http.ResponseWriter, r *http.Request) {
+		writeError(rw, http.StatusMethodNotAllowed, "method not allowed", "")
+	})
+
 	r.Get("/ping", func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Set("content-type", "text/plain; charset=utf-8")
 		ok := dataStorage.Ping()
 		log.Println("is ping", ok)
-		if ok {
-			rw.WriteHeader(http.StatusOK)
-		} else {
-			rw.WriteHeader(http.StatusInternalServerError)
+		if !ok {
+			writeStorageError(rw, datastorage.ErrUnavailable, "")
+			return
 		}
+		rw.Header().Set("content-type", "text/plain; charset=utf-8")
+		rw.WriteHeader(http.StatusOK)
 		rw.Write(nil)
 	})
 
 	r.Route("/value", func(r chi.Router) {
 		r.Get("/gauge/{metricName}", MakeHandleGaugeValue(dataStorage))
 		r.Get("/counter/{metricName}", MakeHandleCounterValue(dataStorage))
+		r.Get("/histogram/{metricName}", MakeHandleHistogramValue(dataStorage))
 		r.Post("/", MakeHandlerJSONValue(dataStorage))
 
 		r.Post("/{metricType}/{metricName}", func(rw http.ResponseWriter, r *http.Request) {
-			rw.Header().Set("content-type", "text/plain; charset=utf-8")
-			rw.WriteHeader(http.StatusNotImplemented)
-			rw.Write(nil)
+			writeError(rw, http.StatusNotImplemented, "Wrong metric type", chi.URLParam(r, "metricName"))
 		})
 
 		r.Post("/gauge", func(rw http.ResponseWriter, r *http.Request) {
-			rw.Header().Set("content-type", "text/plain; charset=utf-8")
-			rw.WriteHeader(http.StatusBadRequest)
-			rw.Write(nil)
+			writeError(rw, http.StatusBadRequest, "Empty metric_id", "")
 		})
 		r.Post("/counter", func(rw http.ResponseWriter, r *http.Request) {
-			rw.Header().Set("content-type", "text/plain; charset=utf-8")
-			rw.WriteHeader(http.StatusBadRequest)
-			rw.Write(nil)
+			writeError(rw, http.StatusBadRequest, "Empty metric_id", "")
 		})
 	})
 
+	r.Get("/history/{metricType}/{metricName}", MakeHandleHistory(dataStorage))
+
 	r.Route("/updates", func(r chi.Router) {
+		r.Use(trustedSubnetHandle(opts.TrustedSubnets))
 		r.Post("/", MakeHandlerJSONArray(dataStorage))
 	})
 
+	r.Route("/reset", func(r chi.Router) {
+		r.Use(trustedSubnetHandle(opts.TrustedSubnets))
+		r.Post("/counter/{metricName}", MakeHandlerResetCounter(dataStorage))
+	})
+
 	r.Route("/update", func(r chi.Router) {
+		r.Use(trustedSubnetHandle(opts.TrustedSubnets))
 		r.Post("/{metricType}/{metricName}/{metricValue}", MakeHandlerUpdate(dataStorage))
 
 		r.Post("/{metricType}/{metricName}", func(rw http.ResponseWriter, r *http.Request) {
-			rw.Header().Set("content-type", "text/plain; charset=utf-8")
-			rw.WriteHeader(http.StatusBadRequest)
-			rw.Write(nil)
+			writeError(rw, http.StatusBadRequest, "Empty metric value", chi.URLParam(r, "metricName"))
 		})
 		r.Post("/", MakeHandlerJSONUpdate(dataStorage))
 	})
@@ -295,19 +457,24 @@ func MakeRouter(dataStorage DataBase) chi.Router {
 }
 
 type Config struct {
-	Server string
+	Server        string
+	GRPCServer    string
+	CryptoKey     string
+	TrustedSubnet string
+	MaxBodySize   int64
+	Zstd          bool
 	datastorage.StorageConfig
 }
 
 func (cfg Config) String() string {
 	if cfg.Store {
 		return fmt.Sprintf(
-			"Server:%s Store:%t Restore:%t StoreInterval:%ds StoreFile:%s",
-			cfg.Server, cfg.Store, cfg.Restore, int(cfg.StoreInterval.Seconds()), cfg.StoreFile)
+			"Server:%s GRPCServer:%s Store:%t Restore:%t StoreInterval:%ds StoreFile:%s",
+			cfg.Server, cfg.GRPCServer, cfg.Store, cfg.Restore, int(cfg.StoreInterval.Seconds()), cfg.StoreFile)
 	} else {
 		return fmt.Sprintf(
-			"Server:%s Store:%t Restore:%t",
-			cfg.Server, cfg.Store, cfg.Restore)
+			"Server:%s GRPCServer:%s Store:%t Restore:%t",
+			cfg.Server, cfg.GRPCServer, cfg.Store, cfg.Restore)
 	}
 }
 
@@ -322,19 +489,45 @@ func (dataServer *DataServer) Init() {
 
 func New(config Config) *DataServer {
 	server := new(DataServer)
-	server.Server = config.Server
-	if config.DataBaseDSN != "" {
+	server.Config = config
+	switch {
+	case config.DataBaseDSN != "" && config.DBType == datastorage.BoltDBType:
+		server.DataHolder = datastorage.NewBoltStorage(config.StorageConfig)
+	case config.DataBaseDSN != "":
 		server.DataHolder = datastorage.NewSQLStorage(config.StorageConfig)
-	} else {
+	case config.RedisURL != "":
+		server.DataHolder = datastorage.NewRedisStorage(config.StorageConfig)
+	case config.Engine == datastorage.EngineSharded:
+		server.DataHolder = datastorage.NewShardedStorage(config.StorageConfig)
+	default:
 		server.DataHolder = datastorage.NewFileStorage(config.StorageConfig)
 	}
 	server.Init()
 	return server
 }
 
+// Open connects the storage which needs it, the end of ctx closes the connection.
+func (dataServer *DataServer) Open(ctx context.Context) error {
+	if storage, ok := dataServer.DataHolder.(opener); ok {
+		return storage.Open(ctx)
+	}
+	return nil
+}
+
+// Router builds the HTTP router of the server with the options from its config.
+func (dataServer *DataServer) Router() (chi.Router, error) {
+	opts, err := NewRouterOptions(dataServer.Config)
+	if err != nil {
+		return nil, err
+	}
+	return MakeRouterWithOptions(dataServer.DataHolder, opts), nil
+}
+
 func (dataServer *DataServer) RunHTTPServer(end context.Context) {
-	dataServer.Init()
-	r := MakeRouter(dataServer.DataHolder)
+	r, err := dataServer.Router()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	server := &http.Server{
 		Addr:    dataServer.Server,
@@ -355,10 +548,19 @@ func (dataServer *DataServer) Run(end context.Context) {
 	log.Println(dataServer.Config)
 	DataHolderEndCtx, DataHolderCancel := context.WithCancel(end)
 	defer DataHolderCancel()
+	if err := dataServer.Open(DataHolderEndCtx); err != nil {
+		log.Fatalln(err)
+	}
 	go dataServer.DataHolder.RunReciver(DataHolderEndCtx)
 
 	httpServerEndCtx, httpServerCancel := context.WithCancel(end)
 	defer httpServerCancel()
 	go dataServer.RunHTTPServer(httpServerEndCtx)
+
+	if dataServer.GRPCServer != "" {
+		grpcServerEndCtx, grpcServerCancel := context.WithCancel(end)
+		defer grpcServerCancel()
+		go dataServer.RunGRPCServer(grpcServerEndCtx)
+	}
 	<-end.Done()
 }